@@ -1,19 +1,263 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"github.com/lzchong/receipt-processor/internal/api/receipt"
+	"github.com/lzchong/receipt-processor/internal/auth"
+	"github.com/lzchong/receipt-processor/internal/config"
+	"github.com/lzchong/receipt-processor/internal/health"
+	"github.com/lzchong/receipt-processor/internal/logging"
+	"github.com/lzchong/receipt-processor/internal/metrics"
+	"github.com/lzchong/receipt-processor/internal/openapi"
+	"github.com/lzchong/receipt-processor/internal/ratelimit"
 	"github.com/lzchong/receipt-processor/internal/server"
-	"log"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 func main() {
-	receiptRepository := receipt.NewRepository()
-	receiptService := receipt.NewService(receiptRepository)
-	receiptHandler := receipt.NewHandler(receiptService)
+	cfg, err := config.Load(os.Args[1:], os.Getenv, os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration:\n%v\n", err)
+		os.Exit(2)
+	}
 
-	router := server.NewRouter(receiptHandler)
-	s := server.NewServer(router)
+	// Messages from the standard log package are logged at the info level.
+	logger, err := logging.New(os.Stderr, cfg.LogFormat, cfg.LogLevel)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration:\n%v\n", err)
+		os.Exit(2)
+	}
+	slog.SetDefault(logger)
 
-	log.Println("Starting server on :8080...")
-	log.Fatal(s.ListenAndServe())
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		// A second signal kills the process without waiting for the drain.
+		stop()
+	}()
+
+	if err := run(ctx, cfg); err != nil {
+		slog.Error("Server stopped", "error", err)
+		os.Exit(1)
+	}
+	slog.Info("Server stopped")
+}
+
+// run serves requests until ctx is done, then drains in-flight requests and
+// closes the repository.
+func run(ctx context.Context, cfg *config.Config) (err error) {
+	receiptRepository, err := openRepository(cfg)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := receiptRepository.Close(); closeErr != nil {
+			err = errors.Join(err, fmt.Errorf("failed to close repository, %w", closeErr))
+		}
+	}()
+
+	rules, err := loadRuleRegistry(cfg.RuleFiles, activeRuleVersion(receiptRepository, cfg.RuleVersion))
+	if err != nil {
+		return err
+	}
+
+	m := metrics.New()
+	m.ObserveRepositorySize(func() int {
+		count, err := receiptRepository.Count(context.Background())
+		if err != nil {
+			slog.Error("Failed to count stored receipts", "error", err)
+		}
+		return count
+	})
+
+	receiptService := receipt.NewService(receiptRepository,
+		receipt.WithRuleRegistry(rules),
+		receipt.WithDuplicatePolicy(cfg.Duplicates),
+		receipt.WithObserver(m),
+	)
+	receiptHandler := receipt.NewHandler(receiptService,
+		receipt.WithValidationObserver(m),
+		receipt.WithIdempotencyWindow(cfg.IdempotencyWindow),
+		receipt.WithMaxBodySize(cfg.MaxBodySize),
+		receipt.WithMaxBatchSize(cfg.MaxBatchSize),
+		receipt.WithMaxBatchBodySize(cfg.MaxBatchBodySize),
+	)
+
+	checker := health.New()
+	checker.Register("repository", func(ctx context.Context) error {
+		return receiptRepository.Ping(ctx)
+	})
+	checker.Register("rules", func(ctx context.Context) error {
+		if len(rules.Active().Rules()) == 0 {
+			return fmt.Errorf("rule version %q has no rules", rules.Active().Version())
+		}
+		return nil
+	})
+
+	routerOptions := []server.RouterOption{
+		server.WithHealth(checker),
+		server.WithMetrics(m),
+		server.WithLogger(slog.Default()),
+	}
+	routerOptions = append(routerOptions,
+		server.WithRateLimits(rateLimiter(cfg.ReadRate, cfg.ReadBurst), rateLimiter(cfg.WriteRate, cfg.WriteBurst)),
+		server.WithScoreRateLimit(rateLimiter(cfg.ScoreRate, cfg.ScoreBurst)),
+		server.WithBatchRateLimit(rateLimiter(cfg.BatchRate, cfg.BatchBurst)),
+		server.WithIPRateLimit(rateLimiter(cfg.IPRate, cfg.IPBurst)),
+	)
+	if cfg.OpenAPIValidation {
+		validator, err := openapi.New(openapi.WithMaxBodySize(max(cfg.MaxBodySize, cfg.MaxBatchBodySize)))
+		if err != nil {
+			return err
+		}
+		routerOptions = append(routerOptions, server.WithOpenAPIValidation(validator))
+		slog.Warn("Checking every request and response against the OpenAPI document, which is meant for development")
+	}
+	keys, err := loadKeys(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	if keys != nil {
+		routerOptions = append(routerOptions, server.WithAuth(keys))
+	} else {
+		slog.Warn("No API keys are configured, so requests are not authenticated")
+	}
+
+	router := server.NewRouter(receiptHandler, routerOptions...)
+	s := server.NewServer(router,
+		server.WithAddr(cfg.Addr),
+		server.WithReadTimeout(cfg.ReadTimeout),
+		server.WithReadHeaderTimeout(cfg.ReadHeaderTimeout),
+		server.WithWriteTimeout(cfg.WriteTimeout),
+		server.WithIdleTimeout(cfg.IdleTimeout),
+	)
+
+	listener, err := net.Listen("tcp", cfg.Addr)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Starting server", "addr", listener.Addr().String(), "storage", cfg.Storage, "rules", rules.Active().Version())
+	return server.Serve(drain(ctx, checker, cfg.ShutdownDelay), s, listener, cfg.ShutdownTimeout)
+}
+
+// rateLimiter returns a limiter for the rate, or nil if it is zero.
+func rateLimiter(rate float64, burst int) *ratelimit.Limiter {
+	if rate == 0 {
+		return nil
+	}
+	return ratelimit.New(rate, burst)
+}
+
+// loadKeys returns the configured API keys, or nil if there are none. Keys
+// from the key file are read again whenever the process receives SIGHUP,
+// until ctx is done.
+func loadKeys(ctx context.Context, cfg *config.Config) (*auth.Keys, error) {
+	if len(cfg.APIKeys) == 0 && cfg.APIKeysFile == "" {
+		return nil, nil
+	}
+	keys, err := auth.New(auth.WithKeys(cfg.APIKeys...), auth.WithKeyFile(cfg.APIKeysFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load API keys: %w", err)
+	}
+
+	if cfg.APIKeysFile != "" {
+		reload := make(chan os.Signal, 1)
+		signal.Notify(reload, syscall.SIGHUP)
+		go func() {
+			defer signal.Stop(reload)
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-reload:
+					if err := keys.Reload(); err != nil {
+						slog.Error("Failed to reload API keys, keeping the current keys", "error", err)
+						continue
+					}
+					slog.Info("Reloaded API keys", "keys", keys.Len())
+				}
+			}
+		}()
+	}
+	return keys, nil
+}
+
+// drain returns a context that is done the delay after ctx is. In between,
+// readiness fails so traffic is routed elsewhere before connections are
+// refused.
+func drain(ctx context.Context, checker *health.Checker, delay time.Duration) context.Context {
+	drained, cancel := context.WithCancel(context.Background())
+	go func() {
+		defer cancel()
+		<-ctx.Done()
+		checker.Shutdown()
+		slog.Info("Shutting down", "delay", delay)
+		time.Sleep(delay)
+	}()
+	return drained
+}
+
+func openRepository(cfg *config.Config) (receipt.Repository, error) {
+	if cfg.Storage != config.StorageFile {
+		return receipt.NewRepository(), nil
+	}
+	return receipt.NewFileRepository(cfg.DataFile,
+		receipt.WithSyncPolicy(cfg.Fsync),
+		receipt.WithSyncInterval(cfg.FsyncInterval),
+	)
+}
+
+// activeRuleVersion returns the rule version a committed recalculation saved in
+// the repository, as the stored receipts were moved to it, or the configured
+// version if none was saved.
+func activeRuleVersion(repository receipt.Repository, configured string) string {
+	store, ok := repository.(receipt.ActiveVersionStore)
+	if !ok || store.ActiveVersion() == "" {
+		return configured
+	}
+	saved := store.ActiveVersion()
+	if configured != "" && configured != saved {
+		slog.Warn("Using the rule version activated by a recalculation instead of the configured one", "version", saved, "configured", configured)
+	}
+	return saved
+}
+
+// loadRuleRegistry registers the built-in rules and every rule file. The
+// built-in rules stay registered so receipts they scored can still be explained.
+func loadRuleRegistry(paths []string, activeVersion string) (*receipt.RuleRegistry, error) {
+	ruleSets := []*receipt.RuleSet{receipt.DefaultRuleSet()}
+	for _, path := range paths {
+		rules, err := receipt.LoadRuleSet(path)
+		if err != nil {
+			return nil, err
+		}
+		ruleSets = append(ruleSets, rules)
+	}
+
+	if activeVersion == "" && len(paths) > 0 {
+		activeVersion = ruleSets[1].Version()
+	} else if activeVersion == "" {
+		activeVersion = receipt.DefaultRuleVersion
+	}
+	registry, err := receipt.NewRuleRegistry(ruleSets[0], ruleSets[1:]...)
+	if err != nil {
+		return nil, err
+	}
+	if err := registry.Activate(activeVersion); err != nil {
+		return nil, fmt.Errorf("rule version %q is not loaded, %w", activeVersion, err)
+	}
+	return registry, nil
 }
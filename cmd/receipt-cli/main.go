@@ -0,0 +1,218 @@
+// Command receipt-cli scores receipts offline with the same validation and
+// rules as the server, so rule changes can be tried on historical data.
+//
+// Receipts are read from the files given as arguments, or from stdin when
+// there are none or the file is "-". Each input may hold a single receipt, a
+// JSON array of receipts, or JSONL with one receipt per line. A malformed line
+// of JSONL is reported with its line number and the other lines are still
+// scored.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/lzchong/receipt-processor/internal/api/receipt"
+)
+
+// Exit codes.
+const (
+	exitOK      = 0
+	exitInvalid = 1 // at least one receipt was invalid
+	exitFailure = 2 // the input or flags could not be used
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("receipt-cli", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	format := flags.String("format", "table", "output format: table, json, or csv")
+	breakdown := flags.Bool("breakdown", false, "include the points awarded by each rule")
+	rulesFile := flags.String("rules", "", "path to a JSON rule config; defaults to the built-in rules")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: receipt-cli [flags] [file ...]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return exitFailure
+	}
+
+	write, ok := writers[*format]
+	if !ok {
+		fmt.Fprintf(stderr, "unknown format %q, must be one of table, json, or csv\n", *format)
+		return exitFailure
+	}
+
+	rules := receipt.DefaultRuleSet()
+	if *rulesFile != "" {
+		loaded, err := receipt.LoadRuleSet(*rulesFile)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return exitFailure
+		}
+		rules = loaded
+	}
+
+	sources := flags.Args()
+	if len(sources) == 0 {
+		sources = []string{"-"}
+	}
+
+	var results []result
+	for _, source := range sources {
+		scored, err := scoreSource(source, stdin, rules)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return exitFailure
+		}
+		results = append(results, scored...)
+	}
+
+	if err := write(stdout, rules, results, *breakdown); err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitFailure
+	}
+
+	for _, r := range results {
+		if r.Error != "" {
+			return exitInvalid
+		}
+	}
+	return exitOK
+}
+
+// result is the score of one receipt, or why it could not be scored.
+type result struct {
+	Source    string                   `json:"source"`
+	Index     int                      `json:"index"`
+	Points    *int64                   `json:"points,omitempty"`
+	Breakdown *receipt.PointsBreakdown `json:"-"`
+	Error     string                   `json:"error,omitempty"`
+}
+
+func scoreSource(source string, stdin io.Reader, rules *receipt.RuleSet) ([]result, error) {
+	input := stdin
+	name := "stdin"
+	if source != "-" {
+		file, err := os.Open(source)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		input = file
+		name = source
+	}
+
+	entries, err := readEntries(input)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	results := make([]result, len(entries))
+	for i, entry := range entries {
+		results[i] = result{Source: name, Index: i}
+		if entry.err != nil {
+			results[i].Error = entry.err.Error()
+			continue
+		}
+		breakdown, err := score(entry.receipt, rules)
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		results[i].Points = &breakdown.Points
+		results[i].Breakdown = breakdown
+	}
+	return results, nil
+}
+
+// entry is one receipt of the input, or why it could not be read.
+type entry struct {
+	receipt json.RawMessage
+	err     error
+}
+
+// readEntries splits the input into receipts. Top-level arrays are flattened,
+// so a JSON array and a stream of JSON objects read the same way.
+func readEntries(input io.Reader) ([]entry, error) {
+	data, err := io.ReadAll(input)
+	if err != nil {
+		return nil, err
+	}
+	if entries, err := decodeEntries(data); err == nil {
+		return entries, nil
+	}
+
+	// The decoder cannot carry on past malformed JSON, so the input is read
+	// again as JSONL to report each malformed line on its own.
+	var entries []entry
+	for i, line := range bytes.Split(data, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		values, err := decodeEntries(line)
+		if err != nil {
+			entries = append(entries, entry{err: fmt.Errorf("line %d is not valid JSON: %w", i+1, err)})
+			continue
+		}
+		entries = append(entries, values...)
+	}
+	return entries, nil
+}
+
+// decodeEntries decodes a stream of JSON values, failing on the first that is
+// malformed.
+func decodeEntries(data []byte) ([]entry, error) {
+	var entries []entry
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	for {
+		var value json.RawMessage
+		err := decoder.Decode(&value)
+		if errors.Is(err, io.EOF) {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if value = bytes.TrimSpace(value); len(value) > 0 && value[0] == '[' {
+			var values []json.RawMessage
+			if err := json.Unmarshal(value, &values); err != nil {
+				return nil, err
+			}
+			for _, v := range values {
+				entries = append(entries, entry{receipt: v})
+			}
+		} else {
+			entries = append(entries, entry{receipt: value})
+		}
+	}
+}
+
+// score runs a receipt through the same validation and conversion as the
+// server before evaluating the rules.
+func score(entry json.RawMessage, rules *receipt.RuleSet) (*receipt.PointsBreakdown, error) {
+	var dto receipt.ProcessRequest
+	decoder := json.NewDecoder(bytes.NewReader(entry))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&dto); err != nil {
+		return nil, err
+	}
+
+	if err := dto.Validate(); err != nil {
+		return nil, err
+	}
+	r, err := dto.ToReceipt()
+	if err != nil {
+		return nil, err
+	}
+	return rules.Evaluate(r), nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	targetReceipt = `{"retailer":"Target","purchaseDate":"2022-01-01","purchaseTime":"13:01","items":[{"shortDescription":"Mountain Dew 12PK","price":"6.49"},{"shortDescription":"Emils Cheese Pizza","price":"12.25"},{"shortDescription":"Knorr Creamy Chicken","price":"1.26"},{"shortDescription":"Doritos Nacho Cheese","price":"3.35"},{"shortDescription":"   Klarbrunn 12-PK 12 FL OZ  ","price":"12.00"}],"total":"35.35"}`
+	marketReceipt = `{"retailer":"M&M Corner Market","purchaseDate":"2022-03-20","purchaseTime":"14:33","items":[{"shortDescription":"Gatorade","price":"2.25"},{"shortDescription":"Gatorade","price":"2.25"},{"shortDescription":"Gatorade","price":"2.25"},{"shortDescription":"Gatorade","price":"2.25"}],"total":"9.00"}`
+)
+
+func TestRun(t *testing.T) {
+	tests := map[string]struct {
+		args     []string
+		stdin    string
+		exitCode int
+		expected []string
+	}{
+		"JSONL": {
+			args:     []string{"-format", "csv"},
+			stdin:    targetReceipt + "\n" + marketReceipt + "\n",
+			exitCode: exitOK,
+			expected: []string{"source,index,points,error", "stdin,0,28,", "stdin,1,109,"},
+		},
+		"JSON array": {
+			args:     []string{"-format", "csv"},
+			stdin:    "[" + targetReceipt + ",\n" + marketReceipt + "]",
+			exitCode: exitOK,
+			expected: []string{"source,index,points,error", "stdin,0,28,", "stdin,1,109,"},
+		},
+		"breakdown": {
+			args:     []string{"-format", "csv", "-breakdown"},
+			stdin:    targetReceipt,
+			exitCode: exitOK,
+			expected: []string{
+				"source,index,points,retailerAlphanumeric,roundDollarTotal,quarterMultipleTotal,itemPairs,itemDescriptionLength,oddPurchaseDay,afternoonPurchaseTime,error",
+				"stdin,0,28,6,0,0,10,6,6,0,",
+			},
+		},
+		"invalid receipt": {
+			args:     []string{"-format", "csv"},
+			stdin:    targetReceipt + "\n" + `{"retailer":"Target","extra":true}`,
+			exitCode: exitInvalid,
+			expected: []string{"source,index,points,error", "stdin,0,28,", `stdin,1,,"json: unknown field ""extra"""`},
+		},
+		"malformed line": {
+			args:     []string{"-format", "csv"},
+			stdin:    targetReceipt + "\n{retailer\n\n" + marketReceipt + "\n",
+			exitCode: exitInvalid,
+			expected: []string{
+				"source,index,points,error",
+				"stdin,0,28,",
+				"stdin,1,,line 2 is not valid JSON: invalid character 'r' looking for beginning of object key string",
+				"stdin,2,109,",
+			},
+		},
+		"malformed input": {
+			args:     []string{"-format", "csv"},
+			stdin:    "{retailer",
+			exitCode: exitInvalid,
+			expected: []string{"source,index,points,error", "stdin,0,,line 1 is not valid JSON: invalid character 'r' looking for beginning of object key string"},
+		},
+		"missing file": {
+			args:     []string{"does-not-exist.jsonl"},
+			exitCode: exitFailure,
+		},
+		"unknown format": {
+			args:     []string{"-format", "xml"},
+			stdin:    targetReceipt,
+			exitCode: exitFailure,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			var stdout, stderr bytes.Buffer
+			got := run(test.args, strings.NewReader(test.stdin), &stdout, &stderr)
+
+			if got != test.exitCode {
+				t.Fatalf("expected exit code %d, but got %d with %q", test.exitCode, got, stderr.String())
+			}
+			if test.expected == nil {
+				if stderr.Len() == 0 {
+					t.Error("expected error message, but got nothing")
+				}
+				return
+			}
+			if lines := strings.Split(strings.TrimSpace(stdout.String()), "\n"); !equalLines(lines, test.expected) {
+				t.Errorf("expected output %q, but got %q", test.expected, lines)
+			}
+		})
+	}
+}
+
+func TestRun_Files(t *testing.T) {
+	dir := t.TempDir()
+	receipts := filepath.Join(dir, "receipts.jsonl")
+	if err := os.WriteFile(receipts, []byte(targetReceipt+"\n"+marketReceipt), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	rules := filepath.Join(dir, "rules.json")
+	config := `{"version":"retailer-only","rules":[{"type":"retailerAlphanumeric","pointsPerCharacter":2}]}`
+	if err := os.WriteFile(rules, []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if got := run([]string{"-format", "json", "-breakdown", "-rules", rules, receipts}, nil, &stdout, &stderr); got != exitOK {
+		t.Fatalf("expected exit code %d, but got %d with %q", exitOK, got, stderr.String())
+	}
+
+	var results []struct {
+		Source      string `json:"source"`
+		Index       int    `json:"index"`
+		Points      int64  `json:"points"`
+		RuleVersion string `json:"ruleVersion"`
+		Rules       []struct {
+			Name   string `json:"name"`
+			Points int64  `json:"points"`
+		} `json:"rules"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &results); err != nil {
+		t.Fatalf("failed to parse output %q, '%v'", stdout.String(), err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("expected 2 results, but got %d", len(results))
+	}
+	for i, want := range []int64{12, 28} {
+		got := results[i]
+		if got.Source != receipts || got.Index != i || got.Points != want || got.RuleVersion != "retailer-only" {
+			t.Errorf("expected %s receipt %d with %d points, but got %+v", receipts, i, want, got)
+		}
+		if len(got.Rules) != 1 || got.Rules[0].Name != "retailerAlphanumeric" {
+			t.Errorf("expected breakdown of retailerAlphanumeric, but got %+v", got.Rules)
+		}
+	}
+}
+
+func TestRun_Table(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if got := run(nil, strings.NewReader(targetReceipt), &stdout, &stderr); got != exitOK {
+		t.Fatalf("expected exit code %d, but got %d with %q", exitOK, got, stderr.String())
+	}
+
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected header and one row, but got %q", lines)
+	}
+	if got := strings.Fields(lines[1]); !equalLines(got, []string{"stdin", "0", "28"}) {
+		t.Errorf("expected row stdin 0 28, but got %q", got)
+	}
+}
+
+func equalLines(got, want []string) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
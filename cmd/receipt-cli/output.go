@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/lzchong/receipt-processor/internal/api/receipt"
+)
+
+type writer func(w io.Writer, rules *receipt.RuleSet, results []result, breakdown bool) error
+
+var writers = map[string]writer{
+	"table": writeTable,
+	"json":  writeJSON,
+	"csv":   writeCSV,
+}
+
+// rows lays out the results with one column per rule when a breakdown is
+// wanted. Receipts that could not be scored leave the point columns empty.
+func rows(rules *receipt.RuleSet, results []result, breakdown bool) [][]string {
+	header := []string{"source", "index", "points"}
+	if breakdown {
+		for _, rule := range rules.Rules() {
+			header = append(header, rule.Name())
+		}
+	}
+	header = append(header, "error")
+
+	rows := [][]string{header}
+	for _, r := range results {
+		row := []string{r.Source, strconv.Itoa(r.Index), ""}
+		if r.Points != nil {
+			row[2] = strconv.FormatInt(*r.Points, 10)
+		}
+		if breakdown {
+			for i := range rules.Rules() {
+				if r.Breakdown != nil {
+					row = append(row, strconv.FormatInt(r.Breakdown.Rules[i].Points, 10))
+				} else {
+					row = append(row, "")
+				}
+			}
+		}
+		rows = append(rows, append(row, r.Error))
+	}
+	return rows
+}
+
+func writeTable(w io.Writer, rules *receipt.RuleSet, results []result, breakdown bool) error {
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, row := range rows(rules, results, breakdown) {
+		fmt.Fprintln(table, strings.Join(row, "\t"))
+	}
+	return table.Flush()
+}
+
+func writeCSV(w io.Writer, rules *receipt.RuleSet, results []result, breakdown bool) error {
+	out := csv.NewWriter(w)
+	out.WriteAll(rows(rules, results, breakdown))
+	return out.Error()
+}
+
+type jsonResult struct {
+	result
+	RuleVersion string               `json:"ruleVersion,omitempty"`
+	Rules       []receipt.RulePoints `json:"rules,omitempty"`
+}
+
+func writeJSON(w io.Writer, rules *receipt.RuleSet, results []result, breakdown bool) error {
+	out := make([]jsonResult, len(results))
+	for i, r := range results {
+		out[i] = jsonResult{result: r}
+		if r.Breakdown != nil {
+			out[i].RuleVersion = r.Breakdown.RuleVersion
+			if breakdown {
+				out[i].Rules = r.Breakdown.Rules
+			}
+		}
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(out)
+}
@@ -0,0 +1,125 @@
+package problem
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/lzchong/receipt-processor/internal/apperr"
+)
+
+// FromError maps an error to the problem describing it to the client. Errors
+// of no apperr kind are internal, so their details are not revealed.
+func FromError(err error) *Problem {
+	var (
+		notFound    *apperr.NotFoundError
+		validation  *apperr.ValidationError
+		tooLarge    *apperr.TooLargeError
+		conflict    *apperr.ConflictError
+		mismatch    *apperr.MismatchError
+		duplicate   *apperr.DuplicateError
+		unavailable *apperr.StorageUnavailableError
+		rateLimited *apperr.RateLimitedError
+	)
+	switch {
+	case errors.As(err, &notFound):
+		if notFound.Reason != "" {
+			return New(http.StatusNotFound, sentence(notFound.Reason))
+		}
+		return New(http.StatusNotFound, fmt.Sprintf("No %s found for that %s.", notFound.Resource, notFound.Key))
+	case errors.As(err, &validation):
+		subject := validation.Subject
+		if subject == "" {
+			subject = "request"
+		}
+		detail := fmt.Sprintf("The %s is invalid.", subject)
+		if validation.Reason != "" {
+			detail = sentence(validation.Reason)
+		}
+		p := New(http.StatusBadRequest, detail)
+		p.Errors = violations(validation.Fields)
+		return p
+	case errors.As(err, &tooLarge):
+		p := New(http.StatusRequestEntityTooLarge, sentence(tooLarge.Reason))
+		p.Errors = violations(tooLarge.Fields)
+		return p
+	case errors.As(err, &conflict):
+		p := New(http.StatusConflict, sentence(conflict.Reason))
+		if conflict.InProgress {
+			p.Type = TypeInProgress
+		}
+		return p
+	case errors.As(err, &mismatch):
+		return New(http.StatusUnprocessableEntity, sentence(mismatch.Reason))
+	case errors.As(err, &duplicate):
+		return New(http.StatusConflict, fmt.Sprintf("This %s has already been submitted.", duplicate.Resource))
+	case errors.As(err, &unavailable):
+		return New(http.StatusServiceUnavailable, "The service is temporarily unavailable, please retry later.")
+	case errors.As(err, &rateLimited):
+		return New(http.StatusTooManyRequests, fmt.Sprintf("Too many requests, retry in %d seconds.", retryAfter(rateLimited)))
+	case errors.Is(err, context.Canceled):
+		p := New(StatusClientClosedRequest, "The request was canceled by the client.")
+		p.Title = "Client Closed Request"
+		return p
+	case errors.Is(err, context.DeadlineExceeded):
+		return New(http.StatusServiceUnavailable, "The request took too long, please retry later.")
+	default:
+		return New(http.StatusInternalServerError, "The request could not be completed.")
+	}
+}
+
+// TypeInProgress is the type of the problem answering a request that conflicts
+// with an identical one still being handled. Retrying it later may succeed,
+// unlike other conflicts.
+const TypeInProgress = "urn:receipt-processor:problem:in-progress"
+
+// violations lists the invalid fields for the client.
+func violations(fields []apperr.FieldError) []Violation {
+	violations := make([]Violation, len(fields))
+	for i, field := range fields {
+		violations[i] = Violation{Pointer: field.Pointer, Code: field.Code, Detail: field.Message}
+	}
+	return violations
+}
+
+// StatusClientClosedRequest is the status of a request the client gave up on
+// before it was answered. The client never sees it, but it keeps abandoned
+// requests apart from server errors in logs and metrics.
+const StatusClientClosedRequest = 499
+
+// WriteError sends the problem describing the error as the response. Errors
+// that are not the client's fault are logged, as the client is not told why
+// the request failed. A request that was canceled or ran out of time is not a
+// failure of the server, so it is not logged as one.
+func WriteError(w http.ResponseWriter, r *http.Request, err error) {
+	p := FromError(err)
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		slog.DebugContext(r.Context(), "Request was abandoned", "status", p.Status, "error", err)
+	case p.Status >= http.StatusInternalServerError:
+		slog.ErrorContext(r.Context(), "Failed to handle request", "status", p.Status, "error", err)
+	}
+	var rateLimited *apperr.RateLimitedError
+	if errors.As(err, &rateLimited) {
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter(rateLimited)))
+	}
+	Write(w, r, p)
+}
+
+// retryAfter rounds the wait up to whole seconds, waiting at least one.
+func retryAfter(err *apperr.RateLimitedError) int {
+	return max(int(math.Ceil(err.RetryAfter.Seconds())), 1)
+}
+
+// sentence capitalises the reason and ends it with a full stop.
+func sentence(reason string) string {
+	if reason == "" {
+		return ""
+	}
+	return strings.ToUpper(reason[:1]) + reason[1:] + "."
+}
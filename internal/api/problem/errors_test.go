@@ -0,0 +1,201 @@
+package problem
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/lzchong/receipt-processor/internal/apperr"
+)
+
+func TestFromError(t *testing.T) {
+	tests := map[string]struct {
+		err        error
+		wantStatus int
+		wantType   string
+		wantTitle  string
+		wantDetail string
+		wantErrors []Violation
+	}{
+		"not found": {
+			err:        &apperr.NotFoundError{Resource: "receipt", Key: "ID"},
+			wantStatus: http.StatusNotFound,
+			wantDetail: "No receipt found for that ID.",
+		},
+		"not found with reason": {
+			err:        &apperr.NotFoundError{Resource: "receipt", Key: "ID", Reason: "the receipt was not stored"},
+			wantStatus: http.StatusNotFound,
+			wantDetail: "The receipt was not stored.",
+		},
+		"validation": {
+			err: &apperr.ValidationError{Subject: "receipt", Fields: []apperr.FieldError{
+				{Pointer: "/total", Code: "invalid_amount", Message: "total must be a decimal number with two decimal places"},
+			}},
+			wantStatus: http.StatusBadRequest,
+			wantDetail: "The receipt is invalid.",
+			wantErrors: []Violation{{"/total", "invalid_amount", "total must be a decimal number with two decimal places"}},
+		},
+		"validation without subject": {
+			err:        &apperr.ValidationError{},
+			wantStatus: http.StatusBadRequest,
+			wantDetail: "The request is invalid.",
+			wantErrors: []Violation{},
+		},
+		"validation with reason": {
+			err: &apperr.ValidationError{Subject: "batch", Reason: "the batch is empty", Fields: []apperr.FieldError{
+				{Pointer: "", Code: "min_items", Message: "minimum of one receipt is required"},
+			}},
+			wantStatus: http.StatusBadRequest,
+			wantDetail: "The batch is empty.",
+			wantErrors: []Violation{{"", "min_items", "minimum of one receipt is required"}},
+		},
+		"too large": {
+			err: &apperr.TooLargeError{Reason: "the receipt is too large", Fields: []apperr.FieldError{
+				{Pointer: "", Code: "body_too_large", Message: "request body must not be larger than 1024 bytes"},
+			}},
+			wantStatus: http.StatusRequestEntityTooLarge,
+			wantDetail: "The receipt is too large.",
+			wantErrors: []Violation{{"", "body_too_large", "request body must not be larger than 1024 bytes"}},
+		},
+		"mismatch": {
+			err:        &apperr.MismatchError{Reason: "Idempotency-Key was already used with a different receipt"},
+			wantStatus: http.StatusUnprocessableEntity,
+			wantDetail: "Idempotency-Key was already used with a different receipt.",
+		},
+		"conflict": {
+			err:        &apperr.ConflictError{Reason: "a request with this Idempotency-Key is still being processed"},
+			wantStatus: http.StatusConflict,
+			wantDetail: "A request with this Idempotency-Key is still being processed.",
+		},
+		"conflict in progress": {
+			err:        &apperr.ConflictError{Reason: "a request with this Idempotency-Key is still being processed", InProgress: true},
+			wantStatus: http.StatusConflict,
+			wantType:   TypeInProgress,
+			wantDetail: "A request with this Idempotency-Key is still being processed.",
+		},
+		"duplicate": {
+			err:        &apperr.DuplicateError{Resource: "receipt"},
+			wantStatus: http.StatusConflict,
+			wantDetail: "This receipt has already been submitted.",
+		},
+		"storage unavailable": {
+			err:        &apperr.StorageUnavailableError{Err: errors.New("disk is full")},
+			wantStatus: http.StatusServiceUnavailable,
+			wantDetail: "The service is temporarily unavailable, please retry later.",
+		},
+		"rate limited": {
+			err:        &apperr.RateLimitedError{RetryAfter: 1500 * time.Millisecond},
+			wantStatus: http.StatusTooManyRequests,
+			wantDetail: "Too many requests, retry in 2 seconds.",
+		},
+		"wrapped": {
+			err:        fmt.Errorf("receipt could not be saved, %w", &apperr.StorageUnavailableError{Err: errors.New("disk is full")}),
+			wantStatus: http.StatusServiceUnavailable,
+			wantDetail: "The service is temporarily unavailable, please retry later.",
+		},
+		"canceled": {
+			err:        fmt.Errorf("receipt could not be saved, %w", context.Canceled),
+			wantStatus: StatusClientClosedRequest,
+			wantTitle:  "Client Closed Request",
+			wantDetail: "The request was canceled by the client.",
+		},
+		"deadline exceeded": {
+			err:        context.DeadlineExceeded,
+			wantStatus: http.StatusServiceUnavailable,
+			wantDetail: "The request took too long, please retry later.",
+		},
+		"internal": {
+			err:        errors.New("secret database password is wrong"),
+			wantStatus: http.StatusInternalServerError,
+			wantDetail: "The request could not be completed.",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			p := FromError(test.err)
+			if p.Status != test.wantStatus {
+				t.Errorf("expected status %d, but got %d", test.wantStatus, p.Status)
+			}
+			wantType := test.wantType
+			if wantType == "" {
+				wantType = "about:blank"
+			}
+			if p.Type != wantType {
+				t.Errorf("expected type %s, but got %s", wantType, p.Type)
+			}
+			wantTitle := test.wantTitle
+			if wantTitle == "" {
+				wantTitle = http.StatusText(test.wantStatus)
+			}
+			if p.Title != wantTitle {
+				t.Errorf("expected title %s, but got %s", wantTitle, p.Title)
+			}
+			if p.Detail != test.wantDetail {
+				t.Errorf("expected detail %q, but got %q", test.wantDetail, p.Detail)
+			}
+			if !reflect.DeepEqual(p.Errors, test.wantErrors) {
+				t.Errorf("expected violations %v, but got %v", test.wantErrors, p.Errors)
+			}
+		})
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	t.Run("rate limited", func(t *testing.T) {
+		request := httptest.NewRequest("GET", "/receipts/abc/points", nil)
+		response := httptest.NewRecorder()
+
+		WriteError(response, request, &apperr.RateLimitedError{RetryAfter: 100 * time.Millisecond})
+
+		if got, want := response.Code, http.StatusTooManyRequests; got != want {
+			t.Errorf("expected status %d, but got %d", want, got)
+		}
+		if got := response.Header().Get("Retry-After"); got != "1" {
+			t.Errorf("expected Retry-After 1, but got %q", got)
+		}
+		if got := response.Header().Get("content-type"); got != ContentType {
+			t.Errorf("expected content-type %s, but got %s", ContentType, got)
+		}
+	})
+
+	t.Run("canceled is not logged as an error", func(t *testing.T) {
+		var logs bytes.Buffer
+		defer slog.SetDefault(slog.Default())
+		slog.SetDefault(slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelInfo})))
+
+		request := httptest.NewRequest("GET", "/receipts/abc/points", nil)
+		response := httptest.NewRecorder()
+
+		WriteError(response, request, context.Canceled)
+
+		if got, want := response.Code, StatusClientClosedRequest; got != want {
+			t.Errorf("expected status %d, but got %d", want, got)
+		}
+		if logs.Len() != 0 {
+			t.Errorf("expected nothing logged, but got %s", logs.String())
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		request := httptest.NewRequest("GET", "/receipts/abc/points", nil)
+		response := httptest.NewRecorder()
+
+		WriteError(response, request, &apperr.NotFoundError{Resource: "receipt", Key: "ID"})
+
+		if got, want := response.Code, http.StatusNotFound; got != want {
+			t.Errorf("expected status %d, but got %d", want, got)
+		}
+		if got := response.Header().Get("Retry-After"); got != "" {
+			t.Errorf("expected no Retry-After, but got %q", got)
+		}
+	})
+}
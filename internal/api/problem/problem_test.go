@@ -0,0 +1,58 @@
+package problem
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	t.Run("defaults instance to path", func(t *testing.T) {
+		request := httptest.NewRequest("POST", "/receipts/process", nil)
+		response := httptest.NewRecorder()
+
+		p := New(http.StatusBadRequest, "The receipt is invalid.")
+		p.Errors = []Violation{{"/items/2/price", "invalid_amount", "price must be a decimal number with two decimal places"}}
+		Write(response, request, p)
+
+		if got, want := response.Code, http.StatusBadRequest; got != want {
+			t.Errorf("expected status %d, but got %d", want, got)
+		}
+		if got := response.Header().Get("content-type"); got != ContentType {
+			t.Errorf("expected content-type %s, but got %s", ContentType, got)
+		}
+
+		var got Problem
+		if err := json.NewDecoder(response.Body).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		want := Problem{
+			Type:     "about:blank",
+			Title:    "Bad Request",
+			Status:   http.StatusBadRequest,
+			Detail:   "The receipt is invalid.",
+			Instance: "/receipts/process",
+			Errors:   []Violation{{"/items/2/price", "invalid_amount", "price must be a decimal number with two decimal places"}},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("expected problem %+v, but got %+v", want, got)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		request := httptest.NewRequest("GET", "/receipts/abc/points", nil)
+		response := httptest.NewRecorder()
+
+		Error(response, request, "No receipt found for that ID.", http.StatusNotFound)
+
+		var got Problem
+		if err := json.NewDecoder(response.Body).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Status != http.StatusNotFound || got.Title != "Not Found" || got.Errors != nil {
+			t.Errorf("unexpected problem %+v", got)
+		}
+	})
+}
@@ -0,0 +1,195 @@
+package receipt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/lzchong/receipt-processor/internal/api/problem"
+	"github.com/lzchong/receipt-processor/internal/apperr"
+)
+
+const (
+	DefaultMaxBatchSize     = 100
+	DefaultMaxBatchBodySize = 10 << 20 // 10MB
+)
+
+// WithMaxBatchSize sets how many receipts a single batch may contain.
+func WithMaxBatchSize(size int) HandlerOption {
+	return func(h *handlerImpl) {
+		h.maxBatchSize = size
+	}
+}
+
+// WithMaxBatchBodySize sets the largest batch request body in bytes.
+func WithMaxBatchBodySize(size int64) HandlerOption {
+	return func(h *handlerImpl) {
+		h.maxBatchBodySize = size
+	}
+}
+
+// BatchResult is the outcome of one receipt in a batch. A receipt that was
+// processed has an ID and points; one that was not has an error.
+type BatchResult struct {
+	Index  int              `json:"index"`
+	Status int              `json:"status"`
+	ID     string           `json:"id,omitempty"`
+	Points *int64           `json:"points,omitempty"`
+	Error  *problem.Problem `json:"error,omitempty"`
+}
+
+type BatchResponse struct {
+	Succeeded int           `json:"succeeded"`
+	Failed    int           `json:"failed"`
+	Results   []BatchResult `json:"results"`
+}
+
+// ProcessBatch processes a JSON array or an NDJSON stream of receipts. Each
+// receipt is validated and processed on its own, so one invalid receipt does
+// not stop the rest.
+func (h *handlerImpl) ProcessBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Body == nil {
+		problem.WriteError(w, r, &ValidationError{Reason: "missing request body, please provide a JSON array or NDJSON stream of receipts"})
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, h.maxBatchBodySize)
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			h.writeInvalid(w, r, OperationBatch, bodyTooLargeError("batch", maxBytesErr.Limit))
+			return
+		}
+		problem.WriteError(w, r, &ValidationError{Reason: "the batch could not be read"})
+		return
+	}
+
+	entries, err := splitBatch(body)
+	if err != nil {
+		h.writeInvalid(w, r, OperationBatch, &ValidationError{Subject: "batch", Fields: []FieldError{decodeFieldError(err, nil)}})
+		return
+	}
+	if len(entries) == 0 {
+		h.writeInvalid(w, r, OperationBatch, &ValidationError{
+			Reason: "the batch is empty",
+			Fields: []FieldError{{Pointer: "", Code: CodeMinItems, Message: "minimum of one receipt is required"}},
+		})
+		return
+	}
+	if len(entries) > h.maxBatchSize {
+		h.writeInvalid(w, r, OperationBatch, &apperr.TooLargeError{
+			Reason: "the batch has too many receipts",
+			Fields: []FieldError{{
+				Pointer: "",
+				Code:    CodeBatchTooLarge,
+				Message: fmt.Sprintf("batch must not contain more than %d receipts, but has %d", h.maxBatchSize, len(entries)),
+			}},
+		})
+		return
+	}
+
+	response := BatchResponse{Results: make([]BatchResult, len(entries))}
+	for i, entry := range entries {
+		result := h.processBatchEntry(r.Context(), entry)
+		result.Index = i
+		if result.Error == nil {
+			response.Succeeded++
+		} else {
+			response.Failed++
+		}
+		response.Results[i] = result
+	}
+
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// splitBatch separates the receipts of a batch. A body starting with [ is a
+// JSON array; anything else is NDJSON, one receipt per line, where blank lines
+// are skipped. A malformed NDJSON line is left for its own result to report.
+func splitBatch(body []byte) ([]json.RawMessage, error) {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 {
+		return nil, nil
+	}
+
+	var entries []json.RawMessage
+	if body[0] == '[' {
+		if err := json.Unmarshal(body, &entries); err != nil {
+			return nil, err
+		}
+		return entries, nil
+	}
+
+	for _, line := range bytes.Split(body, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) > 0 {
+			entries = append(entries, line)
+		}
+	}
+	return entries, nil
+}
+
+func (h *handlerImpl) processBatchEntry(ctx context.Context, entry json.RawMessage) BatchResult {
+	var dto ProcessRequest
+	decoder := json.NewDecoder(bytes.NewReader(entry))
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(&dto); err != nil {
+		return h.invalidEntry(newValidationError(decodeFieldError(err, entry)))
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return h.invalidEntry(newValidationError(FieldError{Pointer: "", Code: CodeMalformedJSON, Message: "receipt is followed by unexpected data"}))
+	}
+
+	if err := dto.Validate(); err != nil {
+		return h.invalidEntry(validationError(err))
+	}
+	receipt, err := dto.ToReceipt()
+	if err != nil {
+		return h.invalidEntry(validationError(err))
+	}
+
+	id, err := h.service.Process(ctx, receipt)
+	if err != nil {
+		return failedEntry(ctx, err)
+	}
+	points, err := h.service.Points(ctx, id)
+	if err != nil {
+		result := failedEntry(ctx, err)
+		result.Error.Detail = "The receipt was saved, but its points could not be read."
+		result.ID = id
+		return result
+	}
+
+	return BatchResult{Status: http.StatusAccepted, ID: id, Points: &points}
+}
+
+// invalidEntry rejects one receipt of a batch.
+func (h *handlerImpl) invalidEntry(err error) BatchResult {
+	p := problem.FromError(err)
+	h.observeViolations(OperationBatch, p)
+	return batchError(p)
+}
+
+// failedEntry reports why the service could not process one receipt of a
+// batch, logging failures that are not the client's. Failures after the
+// request was abandoned are not logged.
+func failedEntry(ctx context.Context, err error) BatchResult {
+	p := problem.FromError(err)
+	if p.Status >= http.StatusInternalServerError && ctx.Err() == nil {
+		slog.ErrorContext(ctx, "Failed to process receipt of batch", "status", p.Status, "error", err)
+	}
+	return batchError(p)
+}
+
+func batchError(p *problem.Problem) BatchResult {
+	return BatchResult{Status: p.Status, Error: p}
+}
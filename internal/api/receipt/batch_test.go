@@ -0,0 +1,168 @@
+package receipt
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/lzchong/receipt-processor/internal/api/problem"
+)
+
+const (
+	batchReceipt    = `{"retailer":"Target","purchaseDate":"2022-01-01","purchaseTime":"13:01","items":[{"shortDescription":"Mountain Dew 12PK","price":"6.49"}],"total":"6.49"}`
+	batchDuplicate  = `{"retailer":"Duplicate","purchaseDate":"2022-01-01","purchaseTime":"13:01","items":[{"shortDescription":"Mountain Dew 12PK","price":"6.49"}],"total":"6.49"}`
+	batchNoRetailer = `{"purchaseDate":"2022-01-01","purchaseTime":"13:01","items":[{"shortDescription":"Mountain Dew 12PK","price":"6.49"}],"total":"6.49"}`
+)
+
+func TestReceiptHandler_ProcessBatch(t *testing.T) {
+	handler := NewHandler(&stubService{}, WithMaxBatchSize(3), WithMaxBatchBodySize(1<<10))
+
+	points := int64(32)
+	accepted := BatchResult{Status: http.StatusAccepted, ID: "7fb1377b-b223-49d9-a31a-5a02701dd310", Points: &points}
+
+	tests := map[string]struct {
+		body      string
+		succeeded int
+		statuses  []int
+	}{
+		"array": {
+			body:      "[" + batchReceipt + "," + batchReceipt + "]",
+			succeeded: 2,
+			statuses:  []int{http.StatusAccepted, http.StatusAccepted},
+		},
+		"NDJSON": {
+			body:      batchReceipt + "\n\n" + batchReceipt + "\n",
+			succeeded: 2,
+			statuses:  []int{http.StatusAccepted, http.StatusAccepted},
+		},
+		"mixed results": {
+			body:      "[" + batchReceipt + "," + batchNoRetailer + "," + batchDuplicate + "]",
+			succeeded: 1,
+			statuses:  []int{http.StatusAccepted, http.StatusBadRequest, http.StatusConflict},
+		},
+		"malformed NDJSON line": {
+			body:      batchReceipt + "\n{retailer\n" + batchReceipt,
+			succeeded: 2,
+			statuses:  []int{http.StatusAccepted, http.StatusBadRequest, http.StatusAccepted},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			request, err := http.NewRequest("POST", "/receipts/batch", strings.NewReader(test.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			response := httptest.NewRecorder()
+			handler.ProcessBatch(response, request)
+
+			assertStatus(t, response, http.StatusOK)
+			assertContentType(t, response, "application/json")
+
+			var got BatchResponse
+			if err := json.NewDecoder(response.Body).Decode(&got); err != nil {
+				t.Fatalf("failed to parse response %q, '%v'", response.Body, err)
+			}
+			if got.Succeeded != test.succeeded || got.Failed != len(test.statuses)-test.succeeded {
+				t.Errorf("expected %d succeeded and %d failed, but got %d and %d", test.succeeded, len(test.statuses)-test.succeeded, got.Succeeded, got.Failed)
+			}
+			if len(got.Results) != len(test.statuses) {
+				t.Fatalf("expected %d results, but got %+v", len(test.statuses), got.Results)
+			}
+			for i, result := range got.Results {
+				if result.Index != i || result.Status != test.statuses[i] {
+					t.Errorf("expected result %d with status %d, but got result %d with status %d", i, test.statuses[i], result.Index, result.Status)
+				}
+				if result.Status == http.StatusAccepted {
+					if result.ID != accepted.ID || result.Points == nil || *result.Points != points {
+						t.Errorf("expected ID %s with %d points, but got %+v", accepted.ID, points, result)
+					}
+				} else if result.Error == nil || result.Error.Status != result.Status {
+					t.Errorf("expected error with status %d, but got %+v", result.Status, result.Error)
+				}
+			}
+		})
+	}
+}
+
+func TestReceiptHandler_ProcessBatch_Errors(t *testing.T) {
+	handler := NewHandler(&stubService{}, WithMaxBatchSize(2), WithMaxBatchBodySize(1<<10))
+
+	tests := map[string]struct {
+		body     string
+		status   int
+		expected problem.Violation
+	}{
+		"empty":          {"[]", http.StatusBadRequest, problem.Violation{Pointer: "", Code: CodeMinItems}},
+		"blank":          {"\n\n", http.StatusBadRequest, problem.Violation{Pointer: "", Code: CodeMinItems}},
+		"malformed":      {"[" + batchReceipt + ",", http.StatusBadRequest, problem.Violation{Pointer: "", Code: CodeMalformedJSON}},
+		"too many":       {"[" + strings.Repeat(batchReceipt+",", 2) + batchReceipt + "]", http.StatusRequestEntityTooLarge, problem.Violation{Pointer: "", Code: CodeBatchTooLarge}},
+		"body too large": {strings.Repeat(" ", 1<<10) + "[" + batchReceipt + "]", http.StatusRequestEntityTooLarge, problem.Violation{Pointer: "", Code: CodeBodyTooLarge}},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			request, err := http.NewRequest("POST", "/receipts/batch", strings.NewReader(test.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			response := httptest.NewRecorder()
+			handler.ProcessBatch(response, request)
+
+			assertStatus(t, response, test.status)
+			assertContentType(t, response, problem.ContentType)
+			assertViolations(t, response, test.expected)
+		})
+	}
+
+	t.Run("no request body", func(t *testing.T) {
+		request, err := http.NewRequest("POST", "/receipts/batch", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		response := httptest.NewRecorder()
+		handler.ProcessBatch(response, request)
+
+		assertStatus(t, response, http.StatusBadRequest)
+		assertHasError(t, response)
+	})
+}
+
+func TestReceiptHandler_ProcessBatch_ItemViolations(t *testing.T) {
+	handler := NewHandler(&stubService{})
+
+	body := batchNoRetailer + "\n" + `{"retailer":"Target","extra":true}`
+	request, err := http.NewRequest("POST", "/receipts/batch", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response := httptest.NewRecorder()
+	handler.ProcessBatch(response, request)
+
+	var got BatchResponse
+	if err := json.NewDecoder(response.Body).Decode(&got); err != nil {
+		t.Fatalf("failed to parse response %q, '%v'", response.Body, err)
+	}
+
+	want := []problem.Violation{
+		{Pointer: "/retailer", Code: CodeRequired},
+		{Pointer: "/extra", Code: CodeUnknownField},
+	}
+	for i, result := range got.Results {
+		if result.Error == nil || len(result.Error.Errors) != 1 {
+			t.Fatalf("expected result %d to have one violation, but got %+v", i, result.Error)
+		}
+		violation := result.Error.Errors[0]
+		if violation.Pointer != want[i].Pointer || violation.Code != want[i].Code {
+			t.Errorf("expected violation %s %s, but got %s %s", want[i].Pointer, want[i].Code, violation.Pointer, violation.Code)
+		}
+	}
+}
@@ -0,0 +1,399 @@
+package receipt
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/lzchong/receipt-processor/internal/apperr"
+)
+
+// SyncPolicy controls how often the write-ahead log is flushed to stable storage.
+type SyncPolicy int
+
+const (
+	// SyncAlways calls fsync after every write.
+	SyncAlways SyncPolicy = iota
+	// SyncBatch calls fsync periodically for all writes since the last sync.
+	SyncBatch
+	// SyncNever leaves flushing to the operating system.
+	SyncNever
+)
+
+func (p SyncPolicy) String() string {
+	switch p {
+	case SyncAlways:
+		return "always"
+	case SyncBatch:
+		return "batch"
+	case SyncNever:
+		return "never"
+	default:
+		return fmt.Sprintf("SyncPolicy(%d)", int(p))
+	}
+}
+
+func ParseSyncPolicy(s string) (SyncPolicy, error) {
+	switch s {
+	case "always":
+		return SyncAlways, nil
+	case "batch":
+		return SyncBatch, nil
+	case "never", "off":
+		return SyncNever, nil
+	default:
+		return 0, fmt.Errorf("unknown sync policy %q, must be one of always, batch, or never", s)
+	}
+}
+
+const defaultSyncInterval = 100 * time.Millisecond
+
+type FileRepositoryOption func(*FileRepository)
+
+func WithSyncPolicy(policy SyncPolicy) FileRepositoryOption {
+	return func(s *FileRepository) {
+		s.policy = policy
+	}
+}
+
+// WithSyncInterval sets how often the log is synced under SyncBatch.
+func WithSyncInterval(interval time.Duration) FileRepositoryOption {
+	return func(s *FileRepository) {
+		s.interval = interval
+	}
+}
+
+const (
+	walOpCreate   = "create"
+	walOpUpdate   = "update"
+	walOpActivate = "activate"
+)
+
+type walRecord struct {
+	Op          string   `json:"op"`
+	ID          string   `json:"id"`
+	Points      int64    `json:"points"`
+	RuleVersion string   `json:"ruleVersion,omitempty"`
+	Receipt     *Receipt `json:"receipt,omitempty"`
+}
+
+// FileRepository keeps every receipt in memory and appends each write to a
+// write-ahead log, which is replayed when the repository is opened.
+type FileRepository struct {
+	*inMemoryRepository
+
+	file     *os.File
+	size     int64
+	policy   SyncPolicy
+	interval time.Duration
+	dirty    bool
+	closed   bool
+
+	// activeVersion is the rule version last saved to the log.
+	activeVersion string
+	// writeErr is why the last write or sync of the log failed. It is kept
+	// until a write succeeds, so the repository is reported as unavailable.
+	writeErr error
+
+	closeOnce sync.Once
+	done      chan struct{}
+	wg        sync.WaitGroup
+}
+
+func NewFileRepository(path string, options ...FileRepositoryOption) (*FileRepository, error) {
+	s := &FileRepository{
+		inMemoryRepository: newInMemoryRepository(),
+		policy:             SyncAlways,
+		interval:           defaultSyncInterval,
+		done:               make(chan struct{}),
+	}
+	for _, option := range options {
+		option(s)
+	}
+	if s.policy == SyncBatch && s.interval <= 0 {
+		return nil, fmt.Errorf("sync interval must be positive, got %v", s.interval)
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open write-ahead log, %w", err)
+	}
+	if err := s.replay(file); err != nil {
+		file.Close()
+		return nil, err
+	}
+	s.file = file
+
+	if s.policy == SyncBatch {
+		s.wg.Add(1)
+		go s.syncLoop()
+	}
+	return s, nil
+}
+
+// replay rebuilds the in-memory state from the log. A partially written final
+// record, left behind by a crash mid-append, is truncated; corruption anywhere
+// else is reported as an error.
+func (s *FileRepository) replay(file *os.File) error {
+	reader := bufio.NewReader(file)
+	offset := int64(0)
+	for line := 1; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(bytes.TrimSpace(data)) > 0 {
+				slog.Warn("Truncating incomplete record of write-ahead log", "line", line)
+				if err := file.Truncate(offset); err != nil {
+					return fmt.Errorf("failed to truncate write-ahead log, %w", err)
+				}
+			}
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read write-ahead log, %w", err)
+		}
+
+		var record walRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			return fmt.Errorf("write-ahead log is corrupt at line %d, %w", line, err)
+		}
+		if err := s.apply(record); err != nil {
+			return fmt.Errorf("write-ahead log is corrupt at line %d, %w", line, err)
+		}
+		offset += int64(len(data))
+	}
+
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek write-ahead log, %w", err)
+	}
+	s.size = offset
+	return nil
+}
+
+func (s *FileRepository) apply(record walRecord) error {
+	switch record.Op {
+	case walOpCreate:
+		if record.ID == "" {
+			return fmt.Errorf("record has no ID")
+		}
+		// Records written before receipts were stored only carry points.
+		if record.Receipt == nil {
+			s.putLegacy(record.ID, record.Points, record.RuleVersion)
+			return nil
+		}
+		s.put(record.ID, record.Receipt, record.Points, record.RuleVersion)
+		return nil
+	case walOpUpdate:
+		if !s.update(record.ID, record.Points, record.RuleVersion) {
+			return fmt.Errorf("update for unknown receipt %q", record.ID)
+		}
+		return nil
+	case walOpActivate:
+		if record.RuleVersion == "" {
+			return fmt.Errorf("activation has no rule version")
+		}
+		s.activeVersion = record.RuleVersion
+		return nil
+	default:
+		return fmt.Errorf("unknown operation %q", record.Op)
+	}
+}
+
+func (s *FileRepository) CreatePoints(ctx context.Context, receipt *Receipt, points int64, ruleVersion string) (string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if err := s.writable(ctx); err != nil {
+		return "", err
+	}
+	id := s.generateID()
+	record := walRecord{Op: walOpCreate, ID: id, Points: points, RuleVersion: ruleVersion, Receipt: receipt}
+	if err := s.append(record); err != nil {
+		return "", &apperr.StorageUnavailableError{Err: fmt.Errorf("failed to write receipt %s to write-ahead log, %w", id, err)}
+	}
+	s.put(id, receipt, points, ruleVersion)
+	slog.DebugContext(ctx, "Wrote receipt to write-ahead log", "receipt_id", id)
+	return id, nil
+}
+
+func (s *FileRepository) UpdatePoints(ctx context.Context, id string, points int64, ruleVersion string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if err := s.writable(ctx); err != nil {
+		return err
+	}
+	if _, ok := s.receipts[id]; !ok {
+		return ErrReceiptNotFound
+	}
+	record := walRecord{Op: walOpUpdate, ID: id, Points: points, RuleVersion: ruleVersion}
+	if err := s.append(record); err != nil {
+		return &apperr.StorageUnavailableError{Err: fmt.Errorf("failed to write points update of receipt %s to write-ahead log, %w", id, err)}
+	}
+	s.update(id, points, ruleVersion)
+	return nil
+}
+
+// SaveActiveVersion writes the rule version to the log, so it is active again
+// when the repository is reopened.
+func (s *FileRepository) SaveActiveVersion(ctx context.Context, version string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if err := s.writable(ctx); err != nil {
+		return err
+	}
+	if err := s.append(walRecord{Op: walOpActivate, RuleVersion: version}); err != nil {
+		return &apperr.StorageUnavailableError{Err: fmt.Errorf("failed to write active rule version to write-ahead log, %w", err)}
+	}
+	s.activeVersion = version
+	return nil
+}
+
+// ActiveVersion returns the rule version last saved, or "" if none was.
+func (s *FileRepository) ActiveVersion() string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.activeVersion
+}
+
+// writable reports why a write should not be made: the log is closed, or the
+// caller gave up while waiting for the lock. The caller must hold the write
+// lock.
+func (s *FileRepository) writable(ctx context.Context) error {
+	if s.closed {
+		return &apperr.StorageUnavailableError{Err: ErrRepositoryClosed}
+	}
+	return ctx.Err()
+}
+
+// append writes a record to the log, remembering whether it failed. The caller
+// must hold the write lock.
+func (s *FileRepository) append(record walRecord) error {
+	err := s.write(record)
+	s.writeErr = err
+	return err
+}
+
+// write writes a record to the log. A failed write is rolled back so the next
+// record does not follow a partial one. The caller must hold the write lock.
+func (s *FileRepository) write(record walRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if _, err := s.file.Write(data); err != nil {
+		return errors.Join(err, s.rollback())
+	}
+	s.size += int64(len(data))
+
+	switch s.policy {
+	case SyncAlways:
+		if err := s.file.Sync(); err != nil {
+			s.size -= int64(len(data))
+			return errors.Join(err, s.rollback())
+		}
+	case SyncBatch:
+		s.dirty = true
+	}
+	return nil
+}
+
+func (s *FileRepository) rollback() error {
+	if err := s.file.Truncate(s.size); err != nil {
+		return err
+	}
+	_, err := s.file.Seek(s.size, io.SeekStart)
+	return err
+}
+
+func (s *FileRepository) syncLoop() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.lock.Lock()
+			if err := s.sync(); err != nil {
+				slog.Error("Failed to sync write-ahead log", "error", err)
+			}
+			s.lock.Unlock()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// sync flushes pending writes. The caller must hold the write lock.
+func (s *FileRepository) sync() error {
+	if !s.dirty {
+		return nil
+	}
+	if err := s.file.Sync(); err != nil {
+		s.writeErr = err
+		return err
+	}
+	s.dirty = false
+	return nil
+}
+
+// Close flushes any pending writes and closes the log.
+func (s *FileRepository) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.wg.Wait()
+
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		s.closed = true
+		err = errors.Join(s.sync(), s.file.Close())
+	})
+	return err
+}
+
+var ErrRepositoryClosed = errors.New("repository is closed")
+
+// Ping checks that the log is still open and can be read, and that the last
+// write to it did not fail. Flushing writes is
+// left to the sync policy, so a check neither blocks writers for an fsync nor
+// syncs more often than configured. Ping gives up once ctx is done.
+func (s *FileRepository) Ping(ctx context.Context) error {
+	result := make(chan error, 1)
+	go func() {
+		s.lock.RLock()
+		defer s.lock.RUnlock()
+		result <- s.check()
+	}()
+
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// check reports whether the log can be used. The caller must hold the lock.
+func (s *FileRepository) check() error {
+	if s.closed {
+		return &apperr.StorageUnavailableError{Err: ErrRepositoryClosed}
+	}
+	if s.writeErr != nil {
+		return &apperr.StorageUnavailableError{Err: fmt.Errorf("last write to write-ahead log failed, %w", s.writeErr)}
+	}
+	if _, err := s.file.Stat(); err != nil {
+		return &apperr.StorageUnavailableError{Err: fmt.Errorf("write-ahead log cannot be read, %w", err)}
+	}
+	return nil
+}
@@ -0,0 +1,318 @@
+package receipt
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lzchong/receipt-processor/internal/apperr"
+)
+
+func TestFileRepository(t *testing.T) {
+	t.Run("create and get points", func(t *testing.T) {
+		repo := openFileRepository(t, filepath.Join(t.TempDir(), "receipts.wal"))
+
+		id := createPoints(t, repo, testReceipt(), 100, "v1")
+
+		got, err := repo.Points(context.Background(), id)
+		assertPoints(t, got, err, 100)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		repo := openFileRepository(t, filepath.Join(t.TempDir(), "receipts.wal"))
+
+		got, err := repo.Points(context.Background(), "non-existent-id")
+		assertNoPoints(t, got, err)
+	})
+
+	t.Run("replay after reopen", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "receipts.wal")
+		repo := openFileRepository(t, path)
+		first := createPoints(t, repo, testReceipt(), 28, "v1")
+		second := createPoints(t, repo, testReceipt(), 109, "v1")
+		if err := repo.Close(); err != nil {
+			t.Fatalf("expected no error, but got %v", err)
+		}
+
+		reopened := openFileRepository(t, path)
+		got, err := reopened.Points(context.Background(), first)
+		assertPoints(t, got, err, 28)
+		got, err = reopened.Points(context.Background(), second)
+		assertPoints(t, got, err, 109)
+	})
+
+	t.Run("truncate incomplete final record", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "receipts.wal")
+		repo := openFileRepository(t, path)
+		id := createPoints(t, repo, testReceipt(), 28, "v1")
+		repo.Close()
+
+		appendToFile(t, path, `{"op":"create","id":"torn`)
+
+		reopened := openFileRepository(t, path)
+		got, err := reopened.Points(context.Background(), id)
+		assertPoints(t, got, err, 28)
+
+		next := createPoints(t, reopened, testReceipt(), 5, "v1")
+		reopened.Close()
+
+		again := openFileRepository(t, path)
+		got, err = again.Points(context.Background(), next)
+		assertPoints(t, got, err, 5)
+	})
+
+	t.Run("corrupt record", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "receipts.wal")
+		appendToFile(t, path, "not json\n{\"op\":\"create\",\"id\":\"a\",\"points\":1}\n")
+
+		repo, err := NewFileRepository(path)
+		if err == nil {
+			repo.Close()
+			t.Fatal("expected has error, but got nothing")
+		}
+	})
+
+	t.Run("batch sync", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "receipts.wal")
+		repo := openFileRepository(t, path, WithSyncPolicy(SyncBatch), WithSyncInterval(time.Millisecond))
+		id := createPoints(t, repo, testReceipt(), 42, "v1")
+		if err := repo.Close(); err != nil {
+			t.Fatalf("expected no error, but got %v", err)
+		}
+
+		reopened := openFileRepository(t, path)
+		got, err := reopened.Points(context.Background(), id)
+		assertPoints(t, got, err, 42)
+	})
+
+	t.Run("invalid batch interval", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "receipts.wal")
+		repo, err := NewFileRepository(path, WithSyncPolicy(SyncBatch), WithSyncInterval(0))
+		if err == nil {
+			repo.Close()
+			t.Fatal("expected has error, but got nothing")
+		}
+	})
+
+	t.Run("stores receipt", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "receipts.wal")
+		repo := openFileRepository(t, path)
+		id := createPoints(t, repo, testReceipt(), 28, "v1")
+		repo.Close()
+
+		reopened := openFileRepository(t, path)
+		got, err := reopened.Receipt(context.Background(), id)
+		assertReceipt(t, got, err, testReceipt())
+	})
+
+	t.Run("replay active version", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "receipts.wal")
+		repo := openFileRepository(t, path)
+		if got := repo.ActiveVersion(); got != "" {
+			t.Errorf("expected no active version, but got %q", got)
+		}
+		for _, version := range []string{"v2", "v3"} {
+			if err := repo.SaveActiveVersion(context.Background(), version); err != nil {
+				t.Fatalf("expected no error, but got %v", err)
+			}
+		}
+		repo.Close()
+
+		reopened := openFileRepository(t, path)
+		if got := reopened.ActiveVersion(); got != "v3" {
+			t.Errorf("expected active version v3, but got %q", got)
+		}
+	})
+
+	t.Run("replay points update", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "receipts.wal")
+		repo := openFileRepository(t, path)
+		id := createPoints(t, repo, testReceipt(), 28, "v1")
+		if err := repo.UpdatePoints(context.Background(), id, 40, "v2"); err != nil {
+			t.Fatalf("expected no error, but got %v", err)
+		}
+		repo.Close()
+
+		reopened := openFileRepository(t, path)
+		got, err := reopened.Record(context.Background(), id)
+		if err != nil {
+			t.Fatalf("expected record, but got %v", err)
+		}
+		if got.Points != 40 || got.RuleVersion != "v2" {
+			t.Errorf("expected points 40 with version v2, but got %d with version %s", got.Points, got.RuleVersion)
+		}
+	})
+
+	t.Run("replay points only record", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "receipts.wal")
+		appendToFile(t, path, `{"op":"create","id":"legacy","points":7}`+"\n")
+
+		repo := openFileRepository(t, path)
+		got, err := repo.Points(context.Background(), "legacy")
+		assertPoints(t, got, err, 7)
+
+		record, err := repo.Record(context.Background(), "legacy")
+		if err != nil {
+			t.Fatalf("expected record, but got %v", err)
+		}
+		if !record.Legacy {
+			t.Error("expected record to be marked as legacy")
+		}
+		if _, err := repo.Receipt(context.Background(), "legacy"); !errors.Is(err, ErrReceiptNotStored) {
+			t.Errorf("expected error %v, but got %v", ErrReceiptNotStored, err)
+		}
+	})
+	t.Run("update unknown receipt", func(t *testing.T) {
+		repo := openFileRepository(t, filepath.Join(t.TempDir(), "receipts.wal"))
+		if err := repo.UpdatePoints(context.Background(), "non-existent-id", 40, "v2"); !errors.Is(err, ErrReceiptNotFound) {
+			t.Errorf("expected error %v, but got %v", ErrReceiptNotFound, err)
+		}
+	})
+
+	t.Run("write after cancel", func(t *testing.T) {
+		repo := openFileRepository(t, filepath.Join(t.TempDir(), "receipts.wal"))
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		if _, err := repo.CreatePoints(ctx, testReceipt(), 28, "v1"); !errors.Is(err, context.Canceled) {
+			t.Errorf("expected error %v, but got %v", context.Canceled, err)
+		}
+		if got, _ := repo.Count(context.Background()); got != 0 {
+			t.Errorf("expected nothing stored, but got %d receipts", got)
+		}
+	})
+
+	t.Run("write after close", func(t *testing.T) {
+		repo := openFileRepository(t, filepath.Join(t.TempDir(), "receipts.wal"))
+		repo.Close()
+
+		if _, err := repo.CreatePoints(context.Background(), testReceipt(), 28, "v1"); !errors.Is(err, ErrRepositoryClosed) {
+			t.Errorf("expected error %v, but got %v", ErrRepositoryClosed, err)
+		}
+	})
+
+	t.Run("ping", func(t *testing.T) {
+		repo := openFileRepository(t, filepath.Join(t.TempDir(), "receipts.wal"))
+		if err := repo.Ping(context.Background()); err != nil {
+			t.Fatalf("expected no error, but got %v", err)
+		}
+
+		repo.Close()
+		if err := repo.Ping(context.Background()); !errors.Is(err, ErrRepositoryClosed) {
+			t.Errorf("expected error %v, but got %v", ErrRepositoryClosed, err)
+		}
+	})
+
+	t.Run("ping leaves syncing to the policy", func(t *testing.T) {
+		repo := openFileRepository(t, filepath.Join(t.TempDir(), "receipts.wal"), WithSyncPolicy(SyncBatch), WithSyncInterval(time.Hour))
+		createPoints(t, repo, testReceipt(), 28, "v1")
+
+		if err := repo.Ping(context.Background()); err != nil {
+			t.Fatalf("expected no error, but got %v", err)
+		}
+		repo.lock.RLock()
+		defer repo.lock.RUnlock()
+		if !repo.dirty {
+			t.Error("expected the pending write to be left for the batch sync")
+		}
+	})
+
+	t.Run("ping reports a failed write until one succeeds", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "receipts.wal")
+		repo := openFileRepository(t, path)
+
+		// A log opened only for reading can still be inspected, but every
+		// write to it fails, as on a full disk or a read-only mount.
+		readOnly, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer readOnly.Close()
+		writable := repo.file
+		repo.lock.Lock()
+		repo.file = readOnly
+		repo.lock.Unlock()
+
+		if _, err := repo.CreatePoints(context.Background(), testReceipt(), 28, "v1"); err == nil {
+			t.Fatal("expected has error, but got nothing")
+		}
+		var unavailable *apperr.StorageUnavailableError
+		if err := repo.Ping(context.Background()); !errors.As(err, &unavailable) {
+			t.Errorf("expected the repository to be unavailable, but got %v", err)
+		}
+
+		repo.lock.Lock()
+		repo.file = writable
+		repo.lock.Unlock()
+		createPoints(t, repo, testReceipt(), 28, "v1")
+		if err := repo.Ping(context.Background()); err != nil {
+			t.Errorf("expected no error, but got %v", err)
+		}
+	})
+
+	t.Run("ping gives up while a write holds the lock", func(t *testing.T) {
+		repo := openFileRepository(t, filepath.Join(t.TempDir(), "receipts.wal"))
+		repo.lock.Lock()
+		defer repo.lock.Unlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		if err := repo.Ping(ctx); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected error %v, but got %v", context.DeadlineExceeded, err)
+		}
+	})
+}
+
+func TestParseSyncPolicy(t *testing.T) {
+	tests := map[string]struct {
+		input    string
+		expected SyncPolicy
+		wantErr  bool
+	}{
+		"always":  {"always", SyncAlways, false},
+		"batch":   {"batch", SyncBatch, false},
+		"never":   {"never", SyncNever, false},
+		"off":     {"off", SyncNever, false},
+		"unknown": {"sometimes", 0, true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			got, err := ParseSyncPolicy(test.input)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("expected error %t, but got %v", test.wantErr, err)
+			}
+			if got != test.expected {
+				t.Errorf("expected policy %v, but got %v", test.expected, got)
+			}
+		})
+	}
+}
+
+func openFileRepository(t *testing.T, path string, options ...FileRepositoryOption) *FileRepository {
+	t.Helper()
+	repo, err := NewFileRepository(path, options...)
+	if err != nil {
+		t.Fatalf("failed to open file repository, %v", err)
+	}
+	t.Cleanup(func() {
+		repo.Close()
+	})
+	return repo
+}
+
+func appendToFile(t *testing.T, path, data string) {
+	t.Helper()
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := file.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+}
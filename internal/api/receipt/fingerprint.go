@@ -0,0 +1,74 @@
+package receipt
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Fingerprint identifies a receipt by its content, so the same receipt
+// submitted again has the same fingerprint. Text is compared case-insensitively
+// with surrounding and repeated whitespace ignored, and items are compared
+// regardless of their order.
+func (r *Receipt) Fingerprint() string {
+	items := make([]string, len(r.Items))
+	for i, item := range r.Items {
+		items[i] = fmt.Sprintf("%s\x1f%d", normalizeText(item.ShortDescription), item.Price.Cents())
+	}
+	sort.Strings(items)
+
+	canonical := strings.Join([]string{
+		normalizeText(r.Retailer),
+		r.PurchaseTime.Format("2006-01-02T15:04"),
+		fmt.Sprint(r.Total.Cents()),
+		strings.Join(items, "\x1e"),
+	}, "\x1d")
+
+	sum := sha256.Sum256([]byte(canonical))
+	return hex.EncodeToString(sum[:])
+}
+
+func normalizeText(s string) string {
+	return strings.ToLower(strings.Join(strings.Fields(s), " "))
+}
+
+// DuplicatePolicy decides what happens to a receipt whose fingerprint matches
+// one already stored.
+type DuplicatePolicy int
+
+const (
+	// DuplicateFlag stores the duplicate and marks it for review.
+	DuplicateFlag DuplicatePolicy = iota
+	// DuplicateReject refuses to store the duplicate.
+	DuplicateReject
+	// DuplicateReturnExisting stores nothing and returns the original ID.
+	DuplicateReturnExisting
+)
+
+func (p DuplicatePolicy) String() string {
+	switch p {
+	case DuplicateFlag:
+		return "flag"
+	case DuplicateReject:
+		return "reject"
+	case DuplicateReturnExisting:
+		return "return-existing"
+	default:
+		return fmt.Sprintf("DuplicatePolicy(%d)", int(p))
+	}
+}
+
+func ParseDuplicatePolicy(s string) (DuplicatePolicy, error) {
+	switch s {
+	case "flag":
+		return DuplicateFlag, nil
+	case "reject":
+		return DuplicateReject, nil
+	case "return-existing":
+		return DuplicateReturnExisting, nil
+	default:
+		return 0, fmt.Errorf("unknown duplicate policy %q, must be one of flag, reject, or return-existing", s)
+	}
+}
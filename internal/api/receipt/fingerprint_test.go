@@ -0,0 +1,79 @@
+package receipt
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReceiptFingerprint(t *testing.T) {
+	base := func() *Receipt {
+		return &Receipt{
+			Retailer:     "M&M Corner Market",
+			PurchaseTime: time.Date(2022, time.March, 20, 14, 33, 0, 0, time.UTC),
+			Items:        []ReceiptItem{{"Gatorade", 225}, {"Emils Cheese Pizza", 1225}},
+			Total:        1450,
+		}
+	}
+
+	same := map[string]func(r *Receipt){
+		"identical":         func(r *Receipt) {},
+		"retailer case":     func(r *Receipt) { r.Retailer = "m&m corner market" },
+		"extra whitespace":  func(r *Receipt) { r.Retailer = "  M&M   Corner Market " },
+		"item order":        func(r *Receipt) { r.Items[0], r.Items[1] = r.Items[1], r.Items[0] },
+		"description space": func(r *Receipt) { r.Items[1].ShortDescription = " Emils  Cheese Pizza " },
+	}
+	for name, change := range same {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			receipt := base()
+			change(receipt)
+			if got, want := receipt.Fingerprint(), base().Fingerprint(); got != want {
+				t.Errorf("expected fingerprint %s, but got %s", want, got)
+			}
+		})
+	}
+
+	different := map[string]func(r *Receipt){
+		"retailer":      func(r *Receipt) { r.Retailer = "Target" },
+		"purchase time": func(r *Receipt) { r.PurchaseTime = r.PurchaseTime.Add(time.Minute) },
+		"total":         func(r *Receipt) { r.Total = 1451 },
+		"item price":    func(r *Receipt) { r.Items[0].Price = 226 },
+		"extra item":    func(r *Receipt) { r.Items = append(r.Items, ReceiptItem{"Gatorade", 225}) },
+	}
+	for name, change := range different {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			receipt := base()
+			change(receipt)
+			if got := receipt.Fingerprint(); got == base().Fingerprint() {
+				t.Errorf("expected a different fingerprint, but got %s", got)
+			}
+		})
+	}
+}
+
+func TestParseDuplicatePolicy(t *testing.T) {
+	tests := map[string]struct {
+		input    string
+		expected DuplicatePolicy
+		wantErr  bool
+	}{
+		"flag":            {"flag", DuplicateFlag, false},
+		"reject":          {"reject", DuplicateReject, false},
+		"return existing": {"return-existing", DuplicateReturnExisting, false},
+		"unknown":         {"ignore", 0, true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			got, err := ParseDuplicatePolicy(test.input)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("expected error %t, but got %v", test.wantErr, err)
+			}
+			if got != test.expected {
+				t.Errorf("expected policy %v, but got %v", test.expected, got)
+			}
+		})
+	}
+}
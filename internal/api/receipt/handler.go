@@ -1,26 +1,83 @@
 package receipt
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"regexp"
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/lzchong/receipt-processor/internal/api/problem"
+	"github.com/lzchong/receipt-processor/internal/apperr"
+	"github.com/lzchong/receipt-processor/internal/auth"
+	"github.com/lzchong/receipt-processor/internal/logging"
 )
 
 type Handler interface {
 	Points(w http.ResponseWriter, r *http.Request)
+	PointsBreakdown(w http.ResponseWriter, r *http.Request)
 	Process(w http.ResponseWriter, r *http.Request)
+	Score(w http.ResponseWriter, r *http.Request)
+	Receipt(w http.ResponseWriter, r *http.Request)
+	ProcessBatch(w http.ResponseWriter, r *http.Request)
+	Recalculate(w http.ResponseWriter, r *http.Request)
+	FlaggedReceipts(w http.ResponseWriter, r *http.Request)
 }
 
 type handlerImpl struct {
-	service Service
+	service          Service
+	idempotency      *IdempotencyCache
+	maxBodySize      int64
+	maxBatchSize     int
+	maxBatchBodySize int64
+	observer         Observer
 }
 
-func NewHandler(service Service) Handler {
-	return &handlerImpl{service}
+type HandlerOption func(*handlerImpl)
+
+// WithIdempotencyWindow sets how long an Idempotency-Key is remembered.
+func WithIdempotencyWindow(window time.Duration) HandlerOption {
+	return func(h *handlerImpl) {
+		h.idempotency = NewIdempotencyCache(window)
+	}
+}
+
+// DefaultMaxBodySize is the largest receipt request body in bytes.
+const DefaultMaxBodySize = 1 << 20 // 1MB
+
+// WithMaxBodySize sets the largest receipt request body in bytes.
+func WithMaxBodySize(size int64) HandlerOption {
+	return func(h *handlerImpl) {
+		h.maxBodySize = size
+	}
+}
+
+// WithValidationObserver reports the code of every violation in a rejected
+// request to the observer.
+func WithValidationObserver(observer Observer) HandlerOption {
+	return func(h *handlerImpl) {
+		h.observer = observer
+	}
+}
+
+func NewHandler(service Service, options ...HandlerOption) Handler {
+	h := &handlerImpl{
+		service:          service,
+		idempotency:      NewIdempotencyCache(DefaultIdempotencyWindow),
+		maxBodySize:      DefaultMaxBodySize,
+		maxBatchSize:     DefaultMaxBatchSize,
+		maxBatchBodySize: DefaultMaxBatchBodySize,
+		observer:         noopObserver{},
+	}
+	for _, option := range options {
+		option(h)
+	}
+	return h
 }
 
 type PointsResponse struct {
@@ -30,31 +87,76 @@ type PointsResponse struct {
 var noWhitespaceRegex = regexp.MustCompile("^\\S+$")
 
 func (h *handlerImpl) Points(w http.ResponseWriter, r *http.Request) {
-	id := strings.TrimSpace(r.PathValue("id"))
+	id, ok := receiptID(w, r)
+	if !ok {
+		return
+	}
 
-	if id == "" {
-		http.Error(w, "Receipt ID cannot be empty.", http.StatusBadRequest)
+	points, err := h.service.Points(r.Context(), id)
+	if err != nil {
+		problem.WriteError(w, r, err)
 		return
 	}
 
-	matched := noWhitespaceRegex.MatchString(id)
-	if !matched {
-		http.Error(w, "Receipt ID is invalid.", http.StatusBadRequest)
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(PointsResponse{points})
+}
+
+func (h *handlerImpl) PointsBreakdown(w http.ResponseWriter, r *http.Request) {
+	id, ok := receiptID(w, r)
+	if !ok {
 		return
 	}
 
-	points, err := h.service.Points(id)
+	breakdown, err := h.service.PointsBreakdown(r.Context(), id)
 	if err != nil {
-		http.Error(w, "No receipt found for that ID.", http.StatusNotFound)
+		problem.WriteError(w, r, err)
 		return
 	}
 
 	w.Header().Set("content-type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(PointsResponse{points})
+	json.NewEncoder(w).Encode(breakdown)
+}
+
+func (h *handlerImpl) Receipt(w http.ResponseWriter, r *http.Request) {
+	id, ok := receiptID(w, r)
+	if !ok {
+		return
+	}
+
+	receipt, err := h.service.Receipt(r.Context(), id)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(NewProcessRequest(receipt))
+}
+
+// receiptID reads the receipt ID from the request path, writing an error
+// response if it is missing or malformed.
+func receiptID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	id := strings.TrimSpace(r.PathValue("id"))
+
+	if id == "" {
+		problem.WriteError(w, r, &ValidationError{Reason: "receipt ID cannot be empty"})
+		return "", false
+	}
+
+	matched := noWhitespaceRegex.MatchString(id)
+	if !matched {
+		problem.WriteError(w, r, &ValidationError{Reason: "receipt ID is invalid"})
+		return "", false
+	}
+
+	logging.SetReceiptID(r.Context(), id)
+	return id, true
 }
 
-var priceRegex = regexp.MustCompile(`^\d+\.\d{2}$`)
 var descriptionRegex = regexp.MustCompile(`^[\w\s\-]+$`)
 var retailerRegex = regexp.MustCompile(`^[\w\s\-&]+$`)
 
@@ -63,23 +165,27 @@ type ItemRequest struct {
 	Price            string `json:"price"`
 }
 
+// Validate reports every invalid field of the item.
 func (r *ItemRequest) Validate() error {
+	errs := newValidationError()
+	r.validate("", errs)
+	return errs.Err()
+}
+
+func (r *ItemRequest) validate(pointer string, errs *ValidationError) {
 	if r.ShortDescription == "" {
-		return fmt.Errorf("short description is required")
-	}
-	if matched := descriptionRegex.MatchString(r.ShortDescription); !matched {
-		return fmt.Errorf("short description must contain only alphanumeric characters, spaces, and hyphens")
+		errs.Add(pointer+"/shortDescription", CodeRequired, "short description is required")
+	} else if matched := descriptionRegex.MatchString(r.ShortDescription); !matched {
+		errs.Add(pointer+"/shortDescription", CodeInvalidFormat, "short description must contain only alphanumeric characters, spaces, and hyphens")
 	}
 
-	if !priceRegex.MatchString(r.Price) {
-		return fmt.Errorf("price must be a decimal number with two decimal places")
+	if _, err := ParseMoney(r.Price); err != nil {
+		errs.Add(pointer+"/price", CodeInvalidAmount, "price must be a decimal number with two decimal places")
 	}
-
-	return nil
 }
 
 func (r *ItemRequest) ToReceiptItem() (*ReceiptItem, error) {
-	price, err := strconv.ParseFloat(r.Price, 64)
+	price, err := ParseMoney(r.Price)
 	if err != nil {
 		return nil, err
 	}
@@ -98,42 +204,40 @@ type ProcessRequest struct {
 	Total        string        `json:"total"`
 }
 
+// Validate reports every invalid field of the receipt.
 func (r *ProcessRequest) Validate() error {
+	errs := newValidationError()
+
 	if r.Retailer == "" {
-		return fmt.Errorf("retailer is required")
-	}
-	if matched := retailerRegex.MatchString(r.Retailer); !matched {
-		return fmt.Errorf("retailer must contain only alphanumeric characters, spaces, hyphens, and ampersands")
+		errs.Add("/retailer", CodeRequired, "retailer is required")
+	} else if matched := retailerRegex.MatchString(r.Retailer); !matched {
+		errs.Add("/retailer", CodeInvalidFormat, "retailer must contain only alphanumeric characters, spaces, hyphens, and ampersands")
 	}
 
 	if r.PurchaseDate == "" {
-		return fmt.Errorf("purchase date is required")
-	}
-	if _, err := time.Parse(time.DateOnly, r.PurchaseDate); err != nil {
-		return fmt.Errorf("purchase date is not a valid date, %v", err)
+		errs.Add("/purchaseDate", CodeRequired, "purchase date is required")
+	} else if _, err := time.Parse(time.DateOnly, r.PurchaseDate); err != nil {
+		errs.Add("/purchaseDate", CodeInvalidDate, "purchase date is not a valid date, %v", err)
 	}
 
 	if r.PurchaseTime == "" {
-		return fmt.Errorf("purchase time is required")
-	}
-	if _, err := time.Parse("15:04", r.PurchaseTime); err != nil {
-		return fmt.Errorf("purchase time is not a valid time, %v", err)
+		errs.Add("/purchaseTime", CodeRequired, "purchase time is required")
+	} else if _, err := time.Parse(clockLayout, r.PurchaseTime); err != nil {
+		errs.Add("/purchaseTime", CodeInvalidTime, "purchase time is not a valid time, %v", err)
 	}
 
 	if len(r.Items) == 0 {
-		return fmt.Errorf("minimum of one item is required")
+		errs.Add("/items", CodeMinItems, "minimum of one item is required")
 	}
-	for _, item := range r.Items {
-		if err := item.Validate(); err != nil {
-			return fmt.Errorf("item %s is invalid: %v", item.ShortDescription, err)
-		}
+	for i, item := range r.Items {
+		item.validate(fmt.Sprintf("/items/%d", i), errs)
 	}
 
-	if !priceRegex.MatchString(r.Total) {
-		return fmt.Errorf("total must be a decimal number with two decimal places")
+	if _, err := ParseMoney(r.Total); err != nil {
+		errs.Add("/total", CodeInvalidAmount, "total must be a decimal number with two decimal places")
 	}
 
-	return nil
+	return errs.Err()
 }
 
 func (r *ProcessRequest) ToReceipt() (*Receipt, error) {
@@ -151,7 +255,7 @@ func (r *ProcessRequest) ToReceipt() (*Receipt, error) {
 		items[i] = *item
 	}
 
-	total, err := strconv.ParseFloat(r.Total, 64)
+	total, err := ParseMoney(r.Total)
 	if err != nil {
 		return nil, err
 	}
@@ -165,42 +269,322 @@ func (r *ProcessRequest) ToReceipt() (*Receipt, error) {
 	return receipt, nil
 }
 
+// NewProcessRequest converts a receipt back into the form it was submitted in.
+func NewProcessRequest(receipt *Receipt) ProcessRequest {
+	items := make([]ItemRequest, len(receipt.Items))
+	for i, item := range receipt.Items {
+		items[i] = ItemRequest{
+			ShortDescription: item.ShortDescription,
+			Price:            item.Price.String(),
+		}
+	}
+	return ProcessRequest{
+		Retailer:     receipt.Retailer,
+		PurchaseDate: receipt.PurchaseTime.Format(time.DateOnly),
+		PurchaseTime: receipt.PurchaseTime.Format("15:04"),
+		Items:        items,
+		Total:        receipt.Total.String(),
+	}
+}
+
 type ProcessResponse struct {
 	ID string `json:"id"`
 }
 
 func (h *handlerImpl) Process(w http.ResponseWriter, r *http.Request) {
+	dto, receipt, ok := h.decodeReceipt(w, r, OperationProcess)
+	if !ok {
+		return
+	}
+
+	key := r.Header.Get("idempotency-key")
+	if key == "" {
+		h.process(w, r, receipt)
+		return
+	}
+	if len(key) > maxIdempotencyKeyLength {
+		problem.WriteError(w, r, &ValidationError{Reason: fmt.Sprintf("Idempotency-Key must not be longer than %d characters", maxIdempotencyKeyLength)})
+		return
+	}
+
+	// Keys are scoped to the API key of the request, so clients cannot replay
+	// or block each other's requests by guessing their keys. Without
+	// authentication there is nothing that identifies a client across networks
+	// and proxies, so keys are shared. A header cannot hold a newline, so a
+	// scoped key never equals a shared one.
+	if name := auth.KeyName(r.Context()); name != "" {
+		key = name + "\n" + key
+	}
+
+	// The decoded request is compared rather than the raw body, so a retry
+	// that only differs in whitespace or field order still matches.
+	body, err := json.Marshal(dto)
+	if err != nil {
+		problem.WriteError(w, r, fmt.Errorf("failed to encode receipt for its Idempotency-Key, %w", err))
+		return
+	}
+
+	state, result := h.idempotency.Begin(key, body)
+	switch state {
+	case IdempotencyReplay:
+		w.Header().Set("idempotent-replayed", "true")
+		writeProcessResponse(w, result.Status, result.ID)
+	case IdempotencyMismatch:
+		problem.WriteError(w, r, &apperr.MismatchError{Reason: "Idempotency-Key was already used with a different receipt"})
+	case IdempotencyInProgress:
+		problem.WriteError(w, r, &apperr.ConflictError{Reason: "a request with this Idempotency-Key is still being processed", InProgress: true})
+	default:
+		// The key is released however processing ends, even by a panic, so a
+		// retry is not refused as in progress until the key expires.
+		completed := false
+		defer func() {
+			if !completed {
+				h.idempotency.Release(key)
+			}
+		}()
+		result, ok := h.process(w, r, receipt)
+		if ok {
+			h.idempotency.Complete(key, result)
+			completed = true
+		}
+	}
+}
+
+const maxIdempotencyKeyLength = 255
+
+// Score explains the points the receipt in the body would be awarded, without
+// storing it.
+func (h *handlerImpl) Score(w http.ResponseWriter, r *http.Request) {
+	_, receipt, ok := h.decodeReceipt(w, r, OperationScore)
+	if !ok {
+		return
+	}
+
+	breakdown, err := h.service.Score(r.Context(), receipt)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(breakdown)
+}
+
+// decodeReceipt reads and validates the receipt in the request body, writing
+// an error response if it is missing or invalid. Violations are observed for
+// the operation the receipt was sent to.
+func (h *handlerImpl) decodeReceipt(w http.ResponseWriter, r *http.Request, operation string) (ProcessRequest, *Receipt, bool) {
+	var dto ProcessRequest
 	if r.Body == nil {
-		http.Error(w, "Missing request body. Please provide a JSON object representing a receipt.", http.StatusBadRequest)
+		problem.WriteError(w, r, &ValidationError{Reason: "missing request body, please provide a JSON object representing a receipt"})
+		return dto, nil, false
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, h.maxBodySize)
+	// The body is kept to locate the item a decoding error is in.
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		h.writeInvalid(w, r, operation, decodeError(err, nil))
+		return dto, nil, false
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(&dto); err != nil {
+		h.writeInvalid(w, r, operation, decodeError(err, body))
+		return dto, nil, false
+	}
+
+	if err := dto.Validate(); err != nil {
+		h.writeInvalid(w, r, operation, validationError(err))
+		return dto, nil, false
+	}
+
+	receipt, err := dto.ToReceipt()
+	if err != nil {
+		h.writeInvalid(w, r, operation, validationError(err))
+		return dto, nil, false
+	}
+	return dto, receipt, true
+}
+
+// process stores the receipt and writes the response, returning it so it can
+// be replayed for the same idempotency key.
+func (h *handlerImpl) process(w http.ResponseWriter, r *http.Request, receipt *Receipt) (IdempotentResult, bool) {
+	id, err := h.service.Process(r.Context(), receipt)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return IdempotentResult{}, false
+	}
+
+	logging.SetReceiptID(r.Context(), id)
+	result := IdempotentResult{Status: http.StatusAccepted, ID: id}
+	writeProcessResponse(w, result.Status, result.ID)
+	return result, true
+}
+
+func writeProcessResponse(w http.ResponseWriter, status int, id string) {
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ProcessResponse{id})
+}
+
+// writeInvalid rejects a request whose body is invalid.
+func (h *handlerImpl) writeInvalid(w http.ResponseWriter, r *http.Request, operation string, err error) {
+	h.observeViolations(operation, problem.FromError(err))
+	problem.WriteError(w, r, err)
+}
+
+func (h *handlerImpl) observeViolations(operation string, p *problem.Problem) {
+	for _, violation := range p.Errors {
+		h.observer.ValidationFailed(operation, violation.Code)
+	}
+}
+
+// validationError lists every invalid field of the request. Errors that are
+// not a ValidationError are reported against the whole body.
+func validationError(err error) error {
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		validationErr = newValidationError(FieldError{Pointer: "", Code: CodeInvalidFormat, Message: err.Error()})
+	}
+	return validationErr
+}
+
+// decodeError explains why the body could not be decoded as JSON.
+func decodeError(err error, body []byte) error {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return bodyTooLargeError("receipt", maxBytesErr.Limit)
+	}
+	return newValidationError(decodeFieldError(err, body))
+}
+
+func bodyTooLargeError(subject string, limit int64) error {
+	return &apperr.TooLargeError{
+		Reason: fmt.Sprintf("the %s is too large", subject),
+		Fields: []FieldError{{
+			Pointer: "",
+			Code:    CodeBodyTooLarge,
+			Message: fmt.Sprintf("request body must not be larger than %d bytes", limit),
+		}},
+	}
+}
+
+// decodeFieldError locates the error in the receipt decoded from body. The body
+// may be nil if the error is not from decoding a receipt.
+func decodeFieldError(err error, body []byte) FieldError {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.Is(err, io.EOF):
+		return FieldError{Pointer: "", Code: CodeRequired, Message: "request body is empty"}
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return FieldError{Pointer: "", Code: CodeMalformedJSON, Message: "request body ends unexpectedly"}
+	case errors.As(err, &syntaxErr):
+		return FieldError{Pointer: "", Code: CodeMalformedJSON, Message: fmt.Sprintf("request body is not valid JSON at offset %d", syntaxErr.Offset)}
+	case errors.As(err, &typeErr):
+		fields := strings.Split(typeErr.Field, ".")
+		pointer := "/" + strings.Join(fields, "/")
+		// Before Go 1.24, encoding/json leaves array indices out of the path.
+		if len(fields) > 1 && fields[0] == "items" && !isIndex(fields[1]) {
+			pointer = "/items"
+			if item, ok := invalidItem(body); ok {
+				pointer = item + "/" + strings.Join(fields[1:], "/")
+			}
+		}
+		return FieldError{Pointer: pointer, Code: CodeInvalidType, Message: fmt.Sprintf("must be a %s, not a JSON %s", typeErr.Type, typeErr.Value)}
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// encoding/json has no error type for unknown fields, nor does it say
+		// where the field is.
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		pointer := "/" + field
+		if item, ok := invalidItem(body); ok {
+			pointer = item + "/" + field
+		}
+		return FieldError{Pointer: pointer, Code: CodeUnknownField, Message: fmt.Sprintf("unknown field %q", field)}
+	default:
+		return FieldError{Pointer: "", Code: CodeMalformedJSON, Message: err.Error()}
+	}
+}
+
+func isIndex(field string) bool {
+	_, err := strconv.Atoi(field)
+	return err == nil
+}
+
+// invalidItem returns the pointer to the first item of the receipt that cannot
+// be decoded. It reports false if the rest of the receipt cannot be decoded
+// either, as the error is then not in an item.
+func invalidItem(body []byte) (string, bool) {
+	var request struct {
+		ProcessRequest
+		Items []json.RawMessage `json:"items"`
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&request); err != nil {
+		return "", false
+	}
+	for i, data := range request.Items {
+		var item ItemRequest
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&item); err != nil {
+			return fmt.Sprintf("/items/%d", i), true
+		}
+	}
+	return "", false
+}
+
+type RecalculateRequest struct {
+	Version string `json:"version"`
+	Commit  bool   `json:"commit"`
+}
+
+func (h *handlerImpl) Recalculate(w http.ResponseWriter, r *http.Request) {
+	if r.Body == nil {
+		problem.WriteError(w, r, &ValidationError{Reason: "missing request body, please provide the rule version to recalculate with"})
 		return
 	}
 
-	maxBodySize := int64(1 << 20) // 1MB limit
+	maxBodySize := int64(1 << 10) // 1KB limit
 	r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
 
-	var dto ProcessRequest
+	var dto RecalculateRequest
 	decoder := json.NewDecoder(r.Body)
 	decoder.DisallowUnknownFields()
 
 	if err := decoder.Decode(&dto); err != nil {
-		http.Error(w, "The receipt is invalid.", http.StatusBadRequest)
+		problem.WriteError(w, r, &ValidationError{Subject: "recalculation request"})
 		return
 	}
-
-	if err := dto.Validate(); err != nil {
-		http.Error(w, "The receipt is invalid.", http.StatusBadRequest)
+	if strings.TrimSpace(dto.Version) == "" {
+		problem.WriteError(w, r, &ValidationError{Reason: "rule version cannot be empty"})
 		return
 	}
 
-	receipt, err := dto.ToReceipt()
+	report, err := h.service.Recalculate(r.Context(), dto.Version, dto.Commit)
 	if err != nil {
-		http.Error(w, "The receipt is invalid.", http.StatusBadRequest)
+		problem.WriteError(w, r, err)
 		return
 	}
 
-	id := h.service.Process(receipt)
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(report)
+}
+
+func (h *handlerImpl) FlaggedReceipts(w http.ResponseWriter, r *http.Request) {
+	flagged, err := h.service.FlaggedReceipts(r.Context())
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
 
 	w.Header().Set("content-type", "application/json")
-	w.WriteHeader(http.StatusAccepted)
-	json.NewEncoder(w).Encode(ProcessResponse{id})
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(flagged)
 }
@@ -2,26 +2,77 @@ package receipt
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/lzchong/receipt-processor/internal/api/problem"
+	"github.com/lzchong/receipt-processor/internal/auth"
 )
 
 type stubService struct{}
 
-func (m *stubService) Points(id string) (int64, error) {
+func (m *stubService) Points(ctx context.Context, id string) (int64, error) {
 	if id == "7fb1377b-b223-49d9-a31a-5a02701dd310" {
 		return 32, nil
 	}
 	return 0, ErrReceiptNotFound
 }
 
-func (m *stubService) Process(receipt *Receipt) string {
-	return "7fb1377b-b223-49d9-a31a-5a02701dd310"
+func (m *stubService) PointsBreakdown(ctx context.Context, id string) (*PointsBreakdown, error) {
+	if id == "7fb1377b-b223-49d9-a31a-5a02701dd310" {
+		breakdown := &PointsBreakdown{
+			Points: 6,
+			Rules:  []RulePoints{{Name: "retailerAlphanumeric", Points: 6, Inputs: map[string]any{"retailer": "Target"}}},
+		}
+		return breakdown, nil
+	}
+	return nil, ErrReceiptNotFound
+}
+
+func (m *stubService) Receipt(ctx context.Context, id string) (*Receipt, error) {
+	if id == "7fb1377b-b223-49d9-a31a-5a02701dd310" {
+		receipt := &Receipt{
+			Retailer:     "Target",
+			PurchaseTime: time.Date(2022, time.January, 1, 13, 1, 0, 0, time.UTC),
+			Items:        []ReceiptItem{{"Mountain Dew 12PK", 649}},
+			Total:        649,
+		}
+		return receipt, nil
+	}
+	if id == "legacy" {
+		return nil, ErrReceiptNotStored
+	}
+	return nil, ErrReceiptNotFound
+}
+
+func (m *stubService) Recalculate(ctx context.Context, version string, commit bool) (*Recalculation, error) {
+	if version == "v2" {
+		return &Recalculation{Version: version, Committed: commit, Receipts: 1, Changes: []PointsChange{}}, nil
+	}
+	return nil, ErrRuleVersionNotFound
+}
+
+func (m *stubService) Process(ctx context.Context, receipt *Receipt) (string, error) {
+	if receipt.Retailer == "Duplicate" {
+		return "", ErrDuplicateReceipt
+	}
+	return "7fb1377b-b223-49d9-a31a-5a02701dd310", nil
+}
+
+func (m *stubService) Score(ctx context.Context, receipt *Receipt) (*PointsBreakdown, error) {
+	return DefaultRuleSet().Evaluate(receipt), nil
+}
+
+func (m *stubService) FlaggedReceipts(ctx context.Context) ([]FlaggedReceipt, error) {
+	return []FlaggedReceipt{{ID: "second", DuplicateOf: "first", Fingerprint: "abc", Points: 6}}, nil
 }
 
 func TestReceiptHandler_Points(t *testing.T) {
@@ -104,6 +155,109 @@ func TestReceiptHandler_Points(t *testing.T) {
 	})
 }
 
+func TestReceiptHandler_PointsBreakdown(t *testing.T) {
+	service := &stubService{}
+	handler := NewHandler(service)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/receipts/{id}/points/breakdown", handler.PointsBreakdown)
+
+	t.Run("success", func(t *testing.T) {
+		request, err := http.NewRequest("GET", "/receipts/7fb1377b-b223-49d9-a31a-5a02701dd310/points/breakdown", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response := httptest.NewRecorder()
+		mux.ServeHTTP(response, request)
+
+		assertStatus(t, response, http.StatusOK)
+		assertContentType(t, response, "application/json")
+		assertJSONResponse(t, response, PointsBreakdown{
+			Points: 6,
+			Rules:  []RulePoints{{Name: "retailerAlphanumeric", Points: 6, Inputs: map[string]any{"retailer": "Target"}}},
+		})
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		request, err := http.NewRequest("GET", "/receipts/non-existent-id/points/breakdown", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response := httptest.NewRecorder()
+		mux.ServeHTTP(response, request)
+
+		assertStatus(t, response, http.StatusNotFound)
+		assertHasError(t, response)
+	})
+}
+
+func TestReceiptHandler_Receipt(t *testing.T) {
+	service := &stubService{}
+	handler := NewHandler(service)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/receipts/{id}", handler.Receipt)
+
+	t.Run("success", func(t *testing.T) {
+		request, err := http.NewRequest("GET", "/receipts/7fb1377b-b223-49d9-a31a-5a02701dd310", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response := httptest.NewRecorder()
+		mux.ServeHTTP(response, request)
+
+		assertStatus(t, response, http.StatusOK)
+		assertContentType(t, response, "application/json")
+		assertJSONResponse(t, response, ProcessRequest{
+			Retailer:     "Target",
+			PurchaseDate: "2022-01-01",
+			PurchaseTime: "13:01",
+			Items:        []ItemRequest{{"Mountain Dew 12PK", "6.49"}},
+			Total:        "6.49",
+		})
+	})
+
+	t.Run("invalid ID", func(t *testing.T) {
+		request, err := http.NewRequest("GET", "/receipts/7fb1377b-b223-49d9%20a31a-5a02701dd310", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response := httptest.NewRecorder()
+		mux.ServeHTTP(response, request)
+
+		assertStatus(t, response, http.StatusBadRequest)
+		assertHasError(t, response)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		request, err := http.NewRequest("GET", "/receipts/non-existent-id", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response := httptest.NewRecorder()
+		mux.ServeHTTP(response, request)
+
+		assertStatus(t, response, http.StatusNotFound)
+		assertHasError(t, response)
+	})
+
+	t.Run("legacy", func(t *testing.T) {
+		request, err := http.NewRequest("GET", "/receipts/legacy", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response := httptest.NewRecorder()
+		mux.ServeHTTP(response, request)
+
+		assertStatus(t, response, http.StatusNotFound)
+		var got problem.Problem
+		if err := json.NewDecoder(response.Body).Decode(&got); err != nil {
+			t.Fatalf("failed to parse response, %v", err)
+		}
+		if want := "The receipt was submitted before receipts were stored, so only its points are available."; got.Detail != want {
+			t.Errorf("expected detail %q, but got %q", want, got.Detail)
+		}
+	})
+}
+
 func TestItemRequestValidate(t *testing.T) {
 	t.Run("success", func(t *testing.T) {
 		receiptItem := &ItemRequest{"Mountain Dew 12PK", "6.49"}
@@ -164,8 +318,8 @@ func TestItemRequestToReceiptItem(t *testing.T) {
 		if got, want := item.ShortDescription, dto.ShortDescription; got != want {
 			t.Errorf("expected short description %s, but got %s", want, got)
 		}
-		if got, want := item.Price, 6.49; got != want {
-			t.Errorf("expected total %f, but got %f", want, got)
+		if got, want := item.Price, Money(649); got != want {
+			t.Errorf("expected total %v, but got %v", want, got)
 		}
 	})
 
@@ -351,6 +505,32 @@ func TestProcessRequestValidate(t *testing.T) {
 	})
 }
 
+func TestProcessRequestValidate_CollectsErrors(t *testing.T) {
+	receipt := &ProcessRequest{
+		Retailer:     "",
+		PurchaseDate: "01-01-2022",
+		PurchaseTime: "13:01",
+		Items:        []ItemRequest{{"Mountain Dew 12PK", "6.49"}, {"Mountain&Dew", "price"}},
+		Total:        "",
+	}
+
+	err := receipt.Validate()
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("expected validation error, but got %v", err)
+	}
+
+	want := []string{"/retailer", "/purchaseDate", "/items/1/shortDescription", "/items/1/price", "/total"}
+	if got := len(validationErr.Fields); got != len(want) {
+		t.Fatalf("expected %d errors, but got %v", len(want), validationErr.Fields)
+	}
+	for i, field := range validationErr.Fields {
+		if field.Pointer != want[i] {
+			t.Errorf("expected error %d at %s, but got %s", i, want[i], field.Pointer)
+		}
+	}
+}
+
 func TestProcessRequestToReceipt(t *testing.T) {
 	t.Run("success", func(t *testing.T) {
 		dto := &ProcessRequest{
@@ -370,8 +550,8 @@ func TestProcessRequestToReceipt(t *testing.T) {
 		if got, want := receipt.PurchaseTime, time.Date(2022, time.December, 31, 13, 51, 0, 0, time.UTC); got != want {
 			t.Errorf("expected purchase time %v, but got %v", want, got)
 		}
-		if got, want := receipt.Total, 35.35; got != want {
-			t.Errorf("expected total %f, but got %f", want, got)
+		if got, want := receipt.Total, Money(3535); got != want {
+			t.Errorf("expected total %v, but got %v", want, got)
 		}
 		if got, want := len(receipt.Items), len(dto.Items); got != want {
 			t.Fatalf("expected %d items, but got %d", want, got)
@@ -572,6 +752,459 @@ func TestReceiptHandler_Process(t *testing.T) {
 		assertStatus(t, response, http.StatusBadRequest)
 		assertHasError(t, response)
 	})
+
+	t.Run("duplicate", func(t *testing.T) {
+		body := `{"retailer":"Duplicate","purchaseDate":"2022-01-01","purchaseTime":"13:01","items":[{"shortDescription":"Mountain Dew 12PK","price":"6.49"}],"total":"6.49"}`
+		request, err := http.NewRequest("POST", "/receipts/process", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		response := httptest.NewRecorder()
+		handler.Process(response, request)
+
+		assertStatus(t, response, http.StatusConflict)
+		assertContentType(t, response, problem.ContentType)
+	})
+
+	t.Run("lists every violation", func(t *testing.T) {
+		body := `{"retailer":"T@rget","purchaseDate":"2022-01-01","purchaseTime":"25:01","items":[{"shortDescription":"Mountain Dew 12PK","price":"6.49"},{"shortDescription":"","price":"6.5"}],"total":"35.35"}`
+		request, err := http.NewRequest("POST", "/receipts/process", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		response := httptest.NewRecorder()
+		handler.Process(response, request)
+
+		assertStatus(t, response, http.StatusBadRequest)
+		assertContentType(t, response, problem.ContentType)
+		assertViolations(t, response,
+			problem.Violation{Pointer: "/retailer", Code: CodeInvalidFormat},
+			problem.Violation{Pointer: "/purchaseTime", Code: CodeInvalidTime},
+			problem.Violation{Pointer: "/items/1/shortDescription", Code: CodeRequired},
+			problem.Violation{Pointer: "/items/1/price", Code: CodeInvalidAmount},
+		)
+	})
+
+	t.Run("decode violations", func(t *testing.T) {
+		tests := map[string]struct {
+			body     string
+			expected problem.Violation
+		}{
+			"malformed JSON": {`{retailer":"Target"}`, problem.Violation{Pointer: "", Code: CodeMalformedJSON}},
+			"truncated JSON": {`{"retailer":"Target"`, problem.Violation{Pointer: "", Code: CodeMalformedJSON}},
+			"empty body":     {``, problem.Violation{Pointer: "", Code: CodeRequired}},
+			"unknown field":  {`{"extra":"value"}`, problem.Violation{Pointer: "/extra", Code: CodeUnknownField}},
+			"wrong type":     {`{"total":35.35}`, problem.Violation{Pointer: "/total", Code: CodeInvalidType}},
+			"wrong item type": {
+				`{"items":[{"price":"1.00"},{"price":"2.00"},{"price":3}]}`,
+				problem.Violation{Pointer: "/items/2/price", Code: CodeInvalidType},
+			},
+			"unknown item field": {
+				`{"items":[{"price":"1.00"},{"price":"2.00","extra":1}]}`,
+				problem.Violation{Pointer: "/items/1/extra", Code: CodeUnknownField},
+			},
+		}
+
+		for name, test := range tests {
+			t.Run(name, func(t *testing.T) {
+				request, err := http.NewRequest("POST", "/receipts/process", strings.NewReader(test.body))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				response := httptest.NewRecorder()
+				handler.Process(response, request)
+
+				assertStatus(t, response, http.StatusBadRequest)
+				assertViolations(t, response, test.expected)
+			})
+		}
+	})
+
+	t.Run("body too large", func(t *testing.T) {
+		body := `{"retailer":"` + strings.Repeat("a", 1<<20) + `"}`
+		request, err := http.NewRequest("POST", "/receipts/process", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		response := httptest.NewRecorder()
+		handler.Process(response, request)
+
+		assertStatus(t, response, http.StatusRequestEntityTooLarge)
+		assertViolations(t, response, problem.Violation{Pointer: "", Code: CodeBodyTooLarge})
+	})
+
+	t.Run("configured body limit", func(t *testing.T) {
+		handler := NewHandler(service, WithMaxBodySize(64))
+		body := `{"retailer":"Target","purchaseDate":"2022-01-01","purchaseTime":"13:01","items":[{"shortDescription":"Mountain Dew 12PK","price":"6.49"}],"total":"6.49"}`
+		request, err := http.NewRequest("POST", "/receipts/process", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		response := httptest.NewRecorder()
+		handler.Process(response, request)
+
+		assertStatus(t, response, http.StatusRequestEntityTooLarge)
+		assertViolations(t, response, problem.Violation{Pointer: "", Code: CodeBodyTooLarge})
+	})
+}
+
+type countingService struct {
+	stubService
+	processed int
+}
+
+func (m *countingService) Process(ctx context.Context, receipt *Receipt) (string, error) {
+	m.processed++
+	return fmt.Sprintf("receipt-%d", m.processed), nil
+}
+
+// panickingService panics while processing its first receipt.
+type panickingService struct {
+	countingService
+	panicked bool
+}
+
+func (m *panickingService) Process(ctx context.Context, receipt *Receipt) (string, error) {
+	if !m.panicked {
+		m.panicked = true
+		panic("storage exploded")
+	}
+	return m.countingService.Process(ctx, receipt)
+}
+
+func TestReceiptHandler_Process_IdempotencyKey(t *testing.T) {
+	body := `{"retailer":"Target","purchaseDate":"2022-01-01","purchaseTime":"13:01","items":[{"shortDescription":"Mountain Dew 12PK","price":"6.49"}],"total":"6.49"}`
+	reordered := `{ "total":"6.49", "retailer":"Target","purchaseDate":"2022-01-01","purchaseTime":"13:01","items":[{"shortDescription":"Mountain Dew 12PK","price":"6.49"}]}`
+	different := `{"retailer":"Target","purchaseDate":"2022-01-01","purchaseTime":"13:01","items":[{"shortDescription":"Mountain Dew 12PK","price":"6.49"}],"total":"7.49"}`
+
+	post := func(handler Handler, key, body string) *httptest.ResponseRecorder {
+		request, err := http.NewRequest("POST", "/receipts/process", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key != "" {
+			request.Header.Set("Idempotency-Key", key)
+		}
+		response := httptest.NewRecorder()
+		handler.Process(response, request)
+		return response
+	}
+
+	t.Run("repeat returns original ID", func(t *testing.T) {
+		service := &countingService{}
+		handler := NewHandler(service)
+
+		first := post(handler, "key-1", body)
+		assertStatus(t, first, http.StatusAccepted)
+		assertJSONResponse(t, first, ProcessResponse{"receipt-1"})
+
+		second := post(handler, "key-1", reordered)
+		assertStatus(t, second, http.StatusAccepted)
+		assertJSONResponse(t, second, ProcessResponse{"receipt-1"})
+		if got := second.Header().Get("idempotent-replayed"); got != "true" {
+			t.Errorf("expected replayed header, but got %q", got)
+		}
+		if service.processed != 1 {
+			t.Errorf("expected 1 receipt processed, but got %d", service.processed)
+		}
+	})
+
+	t.Run("different body", func(t *testing.T) {
+		service := &countingService{}
+		handler := NewHandler(service)
+
+		post(handler, "key-1", body)
+		response := post(handler, "key-1", different)
+		assertStatus(t, response, http.StatusUnprocessableEntity)
+		assertContentType(t, response, problem.ContentType)
+	})
+
+	t.Run("different keys", func(t *testing.T) {
+		service := &countingService{}
+		handler := NewHandler(service)
+
+		post(handler, "key-1", body)
+		response := post(handler, "key-2", body)
+		assertJSONResponse(t, response, ProcessResponse{"receipt-2"})
+	})
+
+	t.Run("different clients", func(t *testing.T) {
+		keys, err := auth.New(auth.WithKeys(
+			auth.Key{Name: "first", Hash: auth.HashKey("first-secret"), Scopes: []string{auth.ScopeReceiptsWrite}},
+			auth.Key{Name: "second", Hash: auth.HashKey("second-secret"), Scopes: []string{auth.ScopeReceiptsWrite}},
+		))
+		if err != nil {
+			t.Fatal(err)
+		}
+		service := &countingService{}
+		handler := NewHandler(service)
+		protected := keys.Require(auth.ScopeReceiptsWrite, http.HandlerFunc(handler.Process))
+
+		tests := []struct {
+			apiKey     string
+			remoteAddr string
+			body       string
+			want       ProcessResponse
+		}{
+			{"first-secret", "192.0.2.1:1234", body, ProcessResponse{"receipt-1"}},
+			{"second-secret", "192.0.2.1:1234", different, ProcessResponse{"receipt-2"}},
+			{"first-secret", "192.0.2.2:1234", body, ProcessResponse{"receipt-1"}},
+			{"", "192.0.2.1:1234", different, ProcessResponse{"receipt-3"}},
+			// Unauthenticated requests share keys, wherever they come from.
+			{"", "192.0.2.2:1234", different, ProcessResponse{"receipt-3"}},
+		}
+		for _, tc := range tests {
+			request, err := http.NewRequest("POST", "/receipts/process", strings.NewReader(tc.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			request.Header.Set("Idempotency-Key", "key-1")
+			request.RemoteAddr = tc.remoteAddr
+			response := httptest.NewRecorder()
+			if tc.apiKey != "" {
+				request.Header.Set("Authorization", "Bearer "+tc.apiKey)
+				protected.ServeHTTP(response, request)
+			} else {
+				handler.Process(response, request)
+			}
+			assertJSONResponse(t, response, tc.want)
+		}
+	})
+
+	t.Run("released after panic", func(t *testing.T) {
+		handler := NewHandler(&panickingService{})
+
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Error("expected the panic to reach the caller, but got nothing")
+				}
+			}()
+			post(handler, "key-1", body)
+		}()
+
+		response := post(handler, "key-1", body)
+		assertStatus(t, response, http.StatusAccepted)
+		assertJSONResponse(t, response, ProcessResponse{"receipt-1"})
+	})
+
+	t.Run("no key", func(t *testing.T) {
+		service := &countingService{}
+		handler := NewHandler(service)
+
+		post(handler, "", body)
+		response := post(handler, "", body)
+		assertJSONResponse(t, response, ProcessResponse{"receipt-2"})
+	})
+
+	t.Run("invalid receipt is not remembered", func(t *testing.T) {
+		service := &countingService{}
+		handler := NewHandler(service)
+
+		invalid := post(handler, "key-1", `{"retailer":"Target"}`)
+		assertStatus(t, invalid, http.StatusBadRequest)
+
+		response := post(handler, "key-1", body)
+		assertStatus(t, response, http.StatusAccepted)
+	})
+
+	t.Run("key too long", func(t *testing.T) {
+		handler := NewHandler(&countingService{})
+
+		response := post(handler, strings.Repeat("k", 256), body)
+		assertStatus(t, response, http.StatusBadRequest)
+	})
+
+	t.Run("window elapsed", func(t *testing.T) {
+		service := &countingService{}
+		handler := NewHandler(service, WithIdempotencyWindow(0))
+
+		post(handler, "key-1", body)
+		time.Sleep(time.Millisecond)
+		response := post(handler, "key-1", different)
+		assertJSONResponse(t, response, ProcessResponse{"receipt-2"})
+	})
+}
+
+func TestReceiptHandler_Score(t *testing.T) {
+	handler := NewHandler(&stubService{})
+
+	t.Run("success", func(t *testing.T) {
+		body := `{"retailer":"Target","purchaseDate":"2022-01-01","purchaseTime":"13:01","items":[{"shortDescription":"Mountain Dew 12PK","price":"6.49"}],"total":"6.49"}`
+		request, err := http.NewRequest("POST", "/receipts/score", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		response := httptest.NewRecorder()
+		handler.Score(response, request)
+
+		assertStatus(t, response, http.StatusOK)
+		assertContentType(t, response, "application/json")
+		var got PointsBreakdown
+		if err := json.NewDecoder(response.Body).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Points != 12 || got.RuleVersion != DefaultRuleVersion {
+			t.Errorf("expected 12 points with version %s, but got %d with version %s", DefaultRuleVersion, got.Points, got.RuleVersion)
+		}
+		assertRuleNames(t, &got, "retailerAlphanumeric", "roundDollarTotal", "quarterMultipleTotal", "itemPairs",
+			"itemDescriptionLength", "oddPurchaseDay", "afternoonPurchaseTime")
+	})
+
+	tests := map[string]struct {
+		body     string
+		expected int
+	}{
+		"empty body":     {"", http.StatusBadRequest},
+		"invalid json":   {`{"retailer": `, http.StatusBadRequest},
+		"invalid fields": {`{"retailer": "Target"}`, http.StatusBadRequest},
+		"too large":      {`{"retailer": "` + strings.Repeat("a", DefaultMaxBodySize) + `"}`, http.StatusRequestEntityTooLarge},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			request, err := http.NewRequest("POST", "/receipts/score", strings.NewReader(test.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			response := httptest.NewRecorder()
+			handler.Score(response, request)
+
+			assertStatus(t, response, test.expected)
+			assertHasError(t, response)
+		})
+	}
+}
+
+func TestReceiptHandler_FlaggedReceipts(t *testing.T) {
+	handler := NewHandler(&stubService{})
+
+	request, err := http.NewRequest("GET", "/admin/receipts/flagged", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := httptest.NewRecorder()
+	handler.FlaggedReceipts(response, request)
+
+	assertStatus(t, response, http.StatusOK)
+	assertContentType(t, response, "application/json")
+	assertJSONResponse(t, response, []FlaggedReceipt{{ID: "second", DuplicateOf: "first", Fingerprint: "abc", Points: 6}})
+}
+
+func TestDecodeFieldError_ItemIndex(t *testing.T) {
+	// encoding/json before Go 1.24 reports the field without the index.
+	err := &json.UnmarshalTypeError{Value: "number", Type: reflect.TypeOf(""), Field: "items.price"}
+	body := []byte(`{"retailer":"Target","items":[{"price":"1.00"},{"price":2}]}`)
+
+	if got := decodeFieldError(err, body).Pointer; got != "/items/1/price" {
+		t.Errorf("expected pointer /items/1/price, but got %s", got)
+	}
+	if got := decodeFieldError(err, nil).Pointer; got != "/items" {
+		t.Errorf("expected pointer /items without the body, but got %s", got)
+	}
+}
+
+func TestReceiptHandler_StorageErrors(t *testing.T) {
+	handler := NewHandler(NewService(&failingRepository{}))
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /receipts/{id}/points", handler.Points)
+	mux.HandleFunc("POST /receipts/process", handler.Process)
+	mux.HandleFunc("GET /admin/receipts/flagged", handler.FlaggedReceipts)
+
+	tests := map[string]struct {
+		method string
+		path   string
+		body   string
+	}{
+		"points":           {"GET", "/receipts/7fb1377b-b223-49d9-a31a-5a02701dd310/points", ""},
+		"process":          {"POST", "/receipts/process", `{"retailer":"Target","purchaseDate":"2022-01-01","purchaseTime":"13:01","items":[{"shortDescription":"Mountain Dew 12PK","price":"6.49"}],"total":"6.49"}`},
+		"flagged receipts": {"GET", "/admin/receipts/flagged", ""},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			request, err := http.NewRequest(test.method, test.path, strings.NewReader(test.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			response := httptest.NewRecorder()
+			mux.ServeHTTP(response, request)
+
+			assertStatus(t, response, http.StatusServiceUnavailable)
+			assertHasError(t, response)
+		})
+	}
+}
+
+func TestReceiptHandler_Recalculate(t *testing.T) {
+	service := &stubService{}
+	handler := NewHandler(service)
+
+	tests := map[string]struct {
+		body     string
+		expected int
+	}{
+		"preview":        {`{"version":"v2"}`, http.StatusOK},
+		"commit":         {`{"version":"v2","commit":true}`, http.StatusOK},
+		"unknown":        {`{"version":"v3"}`, http.StatusNotFound},
+		"empty version":  {`{"version":" "}`, http.StatusBadRequest},
+		"unknown fields": {`{"version":"v2","dryRun":true}`, http.StatusBadRequest},
+		"malformed JSON": {`{"version":`, http.StatusBadRequest},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			request, err := http.NewRequest("POST", "/admin/recalculate", strings.NewReader(test.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			response := httptest.NewRecorder()
+			handler.Recalculate(response, request)
+
+			assertStatus(t, response, test.expected)
+		})
+	}
+
+	t.Run("report", func(t *testing.T) {
+		request, err := http.NewRequest("POST", "/admin/recalculate", strings.NewReader(`{"version":"v2","commit":true}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		response := httptest.NewRecorder()
+		handler.Recalculate(response, request)
+
+		assertContentType(t, response, "application/json")
+		assertJSONResponse(t, response, Recalculation{Version: "v2", Committed: true, Receipts: 1, Changes: []PointsChange{}})
+	})
+}
+
+// assertViolations compares the pointer and code of each violation, ignoring
+// the human-readable detail.
+func assertViolations(t *testing.T, response *httptest.ResponseRecorder, want ...problem.Violation) {
+	t.Helper()
+
+	var got problem.Problem
+	if err := json.NewDecoder(response.Body).Decode(&got); err != nil {
+		t.Fatalf("failed to parse problem %q, '%v'", response.Body, err)
+	}
+	if len(got.Errors) != len(want) {
+		t.Fatalf("expected %d violations, but got %+v", len(want), got.Errors)
+	}
+	for i, violation := range got.Errors {
+		if violation.Pointer != want[i].Pointer || violation.Code != want[i].Code {
+			t.Errorf("expected violation %s %s, but got %s %s", want[i].Pointer, want[i].Code, violation.Pointer, violation.Code)
+		}
+		if violation.Detail == "" {
+			t.Errorf("expected violation %s to have detail, but got nothing", violation.Pointer)
+		}
+	}
 }
 
 func assertStatus(t *testing.T, response *httptest.ResponseRecorder, want int) {
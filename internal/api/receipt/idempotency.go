@@ -0,0 +1,122 @@
+package receipt
+
+import (
+	"crypto/sha256"
+	"sync"
+	"time"
+)
+
+const DefaultIdempotencyWindow = 24 * time.Hour
+
+// IdempotencyState is the outcome of claiming an idempotency key.
+type IdempotencyState int
+
+const (
+	// IdempotencyNew means the key was unused and is now held by the caller,
+	// who must Complete or Release it.
+	IdempotencyNew IdempotencyState = iota
+	// IdempotencyReplay means the key already completed with the same request.
+	IdempotencyReplay
+	// IdempotencyMismatch means the key was used with a different request.
+	IdempotencyMismatch
+	// IdempotencyInProgress means another request holding the key has not
+	// finished yet.
+	IdempotencyInProgress
+)
+
+// IdempotentResult is the response remembered for a completed key.
+type IdempotentResult struct {
+	Status int
+	ID     string
+}
+
+type idempotencyEntry struct {
+	fingerprint [sha256.Size]byte
+	result      *IdempotentResult
+	expires     time.Time
+}
+
+// IdempotencyCache remembers the result of each request by its idempotency key
+// for a fixed window, so a retried request gets the original response instead
+// of being processed again.
+type IdempotencyCache struct {
+	lock      sync.Mutex
+	window    time.Duration
+	entries   map[string]*idempotencyEntry
+	nextSweep time.Time
+	now       func() time.Time
+}
+
+func NewIdempotencyCache(window time.Duration) *IdempotencyCache {
+	return &IdempotencyCache{
+		window:  window,
+		entries: make(map[string]*idempotencyEntry),
+		now:     time.Now,
+	}
+}
+
+// Begin claims the key for a request body. For a replay the remembered result
+// is returned.
+func (c *IdempotencyCache) Begin(key string, body []byte) (IdempotencyState, *IdempotentResult) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := c.now()
+	c.sweep(now)
+
+	fingerprint := sha256.Sum256(body)
+	entry, ok := c.entries[key]
+	if ok && now.After(entry.expires) {
+		delete(c.entries, key)
+		ok = false
+	}
+	if !ok {
+		c.entries[key] = &idempotencyEntry{fingerprint: fingerprint, expires: now.Add(c.window)}
+		return IdempotencyNew, nil
+	}
+
+	switch {
+	case entry.fingerprint != fingerprint:
+		return IdempotencyMismatch, nil
+	case entry.result == nil:
+		return IdempotencyInProgress, nil
+	default:
+		result := *entry.result
+		return IdempotencyReplay, &result
+	}
+}
+
+// Complete remembers the result for a key claimed by Begin.
+func (c *IdempotencyCache) Complete(key string, result IdempotentResult) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if entry, ok := c.entries[key]; ok {
+		entry.result = &result
+	}
+}
+
+// Release gives up a key claimed by Begin without remembering a result, so
+// the request can be retried.
+func (c *IdempotencyCache) Release(key string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if entry, ok := c.entries[key]; ok && entry.result == nil {
+		delete(c.entries, key)
+	}
+}
+
+// sweep drops expired entries at most once per window. The caller must hold
+// the lock.
+func (c *IdempotencyCache) sweep(now time.Time) {
+	if now.Before(c.nextSweep) {
+		return
+	}
+	for key, entry := range c.entries {
+		if now.After(entry.expires) {
+			delete(c.entries, key)
+		}
+	}
+	c.nextSweep = now.Add(c.window)
+}
@@ -0,0 +1,84 @@
+package receipt
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestIdempotencyCache(t *testing.T) {
+	t.Run("new then replay", func(t *testing.T) {
+		cache := NewIdempotencyCache(time.Hour)
+
+		state, _ := cache.Begin("key", []byte("body"))
+		assertIdempotencyState(t, state, IdempotencyNew)
+
+		cache.Complete("key", IdempotentResult{http.StatusAccepted, "id"})
+
+		state, result := cache.Begin("key", []byte("body"))
+		assertIdempotencyState(t, state, IdempotencyReplay)
+		if result == nil || result.ID != "id" || result.Status != http.StatusAccepted {
+			t.Errorf("expected result id with status 202, but got %v", result)
+		}
+	})
+
+	t.Run("different body", func(t *testing.T) {
+		cache := NewIdempotencyCache(time.Hour)
+		cache.Begin("key", []byte("body"))
+		cache.Complete("key", IdempotentResult{http.StatusAccepted, "id"})
+
+		state, _ := cache.Begin("key", []byte("other body"))
+		assertIdempotencyState(t, state, IdempotencyMismatch)
+	})
+
+	t.Run("in progress", func(t *testing.T) {
+		cache := NewIdempotencyCache(time.Hour)
+		cache.Begin("key", []byte("body"))
+
+		state, _ := cache.Begin("key", []byte("body"))
+		assertIdempotencyState(t, state, IdempotencyInProgress)
+	})
+
+	t.Run("release", func(t *testing.T) {
+		cache := NewIdempotencyCache(time.Hour)
+		cache.Begin("key", []byte("body"))
+		cache.Release("key")
+
+		state, _ := cache.Begin("key", []byte("other body"))
+		assertIdempotencyState(t, state, IdempotencyNew)
+	})
+
+	t.Run("expires after window", func(t *testing.T) {
+		now := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+		cache := NewIdempotencyCache(time.Hour)
+		cache.now = func() time.Time { return now }
+
+		cache.Begin("key", []byte("body"))
+		cache.Complete("key", IdempotentResult{http.StatusAccepted, "id"})
+
+		now = now.Add(time.Hour + time.Second)
+		state, _ := cache.Begin("key", []byte("other body"))
+		assertIdempotencyState(t, state, IdempotencyNew)
+	})
+
+	t.Run("sweeps expired keys", func(t *testing.T) {
+		now := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+		cache := NewIdempotencyCache(time.Hour)
+		cache.now = func() time.Time { return now }
+
+		cache.Begin("first", []byte("body"))
+		now = now.Add(2 * time.Hour)
+		cache.Begin("second", []byte("body"))
+
+		if got := len(cache.entries); got != 1 {
+			t.Errorf("expected 1 entry, but got %d", got)
+		}
+	})
+}
+
+func assertIdempotencyState(t *testing.T, got, want IdempotencyState) {
+	t.Helper()
+	if got != want {
+		t.Errorf("expected state %d, but got %d", want, got)
+	}
+}
@@ -1,7 +1,6 @@
 package receipt
 
 import (
-	"math"
 	"strings"
 	"time"
 	"unicode"
@@ -9,56 +8,30 @@ import (
 )
 
 type ReceiptItem struct {
-	ShortDescription string
-	Price            float64
+	ShortDescription string `json:"shortDescription"`
+	Price            Money  `json:"price"`
 }
 
 type Receipt struct {
-	Retailer     string
-	PurchaseTime time.Time
-	Items        []ReceiptItem
-	Total        float64
+	Retailer     string        `json:"retailer"`
+	PurchaseTime time.Time     `json:"purchaseTime"`
+	Items        []ReceiptItem `json:"items"`
+	Total        Money         `json:"total"`
 }
 
-func (r *Receipt) CalculatePoints() int64 {
-	points := int64(0)
-
-	// One point for every alphanumeric character in the retailer
-	points += 1 * countByAlphanumericCharacter(r.Retailer)
-
-	// 50 points if the total is a round dollar amount with no cents
-	if isRoundDollarAmount(r.Total) {
-		points += 50
-	}
-
-	// 25 points if the total is a multiple of 0.25
-	if isMultipleOfQuarter(r.Total) {
-		points += 25
-	}
-
-	// 5 points for every two items on the receipt
-	points += 5 * countEveryTwoItems(r.Items)
-
-	// If the trimmed length of the item description is a multiple of 3,
-	// multiply the price by 0.2 and round up to the nearest integer.
-	// The result is the number of points earned.
-	for _, item := range r.Items {
-		if isStringLengthMultipleOfThree(item.ShortDescription) {
-			points += int64(math.Ceil(item.Price * 0.2))
-		}
-	}
-
-	// 6 points if the day in the purchase date is odd
-	if isOddDay(r.PurchaseTime) {
-		points += 6
-	}
+func (r *Receipt) clone() *Receipt {
+	receipt := *r
+	receipt.Items = append([]ReceiptItem(nil), r.Items...)
+	return &receipt
+}
 
-	// 10 points if the time of purchase is after 2:00pm and before 4:00pm
-	if isTimeBetweenTwoPMAndFourPM(r.PurchaseTime) {
-		points += 10
-	}
+func (r *Receipt) CalculatePoints() int64 {
+	return r.CalculatePointsBreakdown().Points
+}
 
-	return points
+// CalculatePointsBreakdown scores the receipt with the default rule set.
+func (r *Receipt) CalculatePointsBreakdown() *PointsBreakdown {
+	return DefaultRuleSet().Evaluate(r)
 }
 
 func countByAlphanumericCharacter(s string) int64 {
@@ -71,28 +44,33 @@ func countByAlphanumericCharacter(s string) int64 {
 	return count
 }
 
-func isRoundDollarAmount(total float64) bool {
-	return total == math.Round(total)
+func isRoundDollarAmount(total Money) bool {
+	return total.IsMultipleOf(100)
+}
+
+func isMultipleOfQuarter(total Money) bool {
+	return total.IsMultipleOf(25)
 }
 
-func isMultipleOfQuarter(total float64) bool {
-	return total == math.Round(total*4)/4
+func countItemGroups(items []ReceiptItem, size int) int64 {
+	return int64(len(items) / size)
 }
 
-func countEveryTwoItems(items []ReceiptItem) int64 {
-	return int64(len(items) / 2)
+func trimmedLength(s string) int {
+	return utf8.RuneCountInString(strings.TrimSpace(s))
 }
 
-func isStringLengthMultipleOfThree(s string) bool {
-	return utf8.RuneCountInString(strings.TrimSpace(s))%3 == 0
+func isStringLengthMultipleOf(s string, divisor int) bool {
+	return trimmedLength(s)%divisor == 0
 }
 
 func isOddDay(t time.Time) bool {
 	return t.Day()%2 == 1
 }
 
-func isTimeBetweenTwoPMAndFourPM(t time.Time) bool {
-	twoPM := time.Date(t.Year(), t.Month(), t.Day(), 14, 0, 0, 0, t.Location())
-	fourPM := time.Date(t.Year(), t.Month(), t.Day(), 16, 0, 0, 0, t.Location())
-	return t.After(twoPM) && t.Before(fourPM)
+// isTimeBetween reports whether the time of day is strictly between after and
+// before, both given as offsets from midnight.
+func isTimeBetween(t time.Time, after, before time.Duration) bool {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	return t.After(midnight.Add(after)) && t.Before(midnight.Add(before))
 }
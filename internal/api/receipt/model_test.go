@@ -1,6 +1,7 @@
 package receipt
 
 import (
+	"reflect"
 	"testing"
 	"time"
 )
@@ -11,13 +12,13 @@ func TestCalculatePoints(t *testing.T) {
 			Retailer:     "Target",
 			PurchaseTime: time.Date(2022, time.January, 1, 14, 01, 0, 0, time.UTC),
 			Items: []ReceiptItem{
-				{"Mountain Dew 12PK", 6.49},
-				{"Emils Cheese Pizza", 12.25},
-				{"Knorr Creamy Chicken", 1.26},
-				{"Doritos Nacho Cheese", 3.35},
-				{"   Klarbrunn 12-PK 12 FL OZ  ", 12.00},
+				{"Mountain Dew 12PK", 649},
+				{"Emils Cheese Pizza", 1225},
+				{"Knorr Creamy Chicken", 126},
+				{"Doritos Nacho Cheese", 335},
+				{"   Klarbrunn 12-PK 12 FL OZ  ", 1200},
 			},
-			Total: 35.35,
+			Total: 3535,
 		}
 
 		if got, want := receipt.CalculatePoints(), int64(38); got != want {
@@ -26,6 +27,70 @@ func TestCalculatePoints(t *testing.T) {
 	})
 }
 
+func TestCalculatePointsBreakdown(t *testing.T) {
+	receipt := &Receipt{
+		Retailer:     "M&M Corner Market",
+		PurchaseTime: time.Date(2022, time.March, 20, 14, 33, 0, 0, time.UTC),
+		Items: []ReceiptItem{
+			{"Gatorade", 225},
+			{"Gatorade", 225},
+			{"Gatorade", 225},
+			{"Gatorade", 225},
+		},
+		Total: 900,
+	}
+
+	breakdown := receipt.CalculatePointsBreakdown()
+	if got, want := breakdown.Points, int64(109); got != want {
+		t.Errorf("expected points %v, but got %v", want, got)
+	}
+
+	want := map[string]int64{
+		"retailerAlphanumeric":  14,
+		"roundDollarTotal":      50,
+		"quarterMultipleTotal":  25,
+		"itemPairs":             10,
+		"itemDescriptionLength": 0,
+		"oddPurchaseDay":        0,
+		"afternoonPurchaseTime": 10,
+	}
+	if got := len(breakdown.Rules); got != len(want) {
+		t.Fatalf("expected %d rules, but got %d", len(want), got)
+	}
+	for _, rule := range breakdown.Rules {
+		if got, want := rule.Points, want[rule.Name]; got != want {
+			t.Errorf("expected rule %s points %v, but got %v", rule.Name, want, got)
+		}
+	}
+}
+
+func TestCalculatePointsBreakdown_Items(t *testing.T) {
+	receipt := &Receipt{
+		Retailer:     "Target",
+		PurchaseTime: time.Date(2022, time.January, 1, 13, 1, 0, 0, time.UTC),
+		Items: []ReceiptItem{
+			{"Mountain Dew 12PK", 649},
+			{"Emils Cheese Pizza", 1225},
+		},
+		Total: 1874,
+	}
+
+	var items []ItemPoints
+	for _, rule := range receipt.CalculatePointsBreakdown().Rules {
+		if rule.Name == "itemDescriptionLength" {
+			items = rule.Items
+		}
+	}
+
+	want := []ItemPoints{
+		{Index: 0, ShortDescription: "Mountain Dew 12PK", TrimmedLength: 17, Price: 649, Points: 0},
+		{Index: 1, ShortDescription: "Emils Cheese Pizza", TrimmedLength: 18, Price: 1225, Points: 3},
+	}
+	if !reflect.DeepEqual(items, want) {
+		t.Errorf("expected items %v, but got %v", want, items)
+	}
+}
+
 func TestCountByAlphanumericCharacter(t *testing.T) {
 	tests := map[string]struct {
 		input    string
@@ -47,13 +112,13 @@ func TestCountByAlphanumericCharacter(t *testing.T) {
 
 func TestIsRoundDollarAmount(t *testing.T) {
 	tests := map[string]struct {
-		input    float64
+		input    Money
 		expected bool
 	}{
-		"0.00": {0.00, true},
-		"0.01": {0.01, false},
-		"0.99": {0.99, false},
-		"1.00": {1.00, true},
+		"0.00": {0, true},
+		"0.01": {1, false},
+		"0.99": {99, false},
+		"1.00": {100, true},
 	}
 
 	for name, test := range tests {
@@ -68,12 +133,12 @@ func TestIsRoundDollarAmount(t *testing.T) {
 
 func TestIsMultipleOfQuarter(t *testing.T) {
 	tests := map[string]struct {
-		input    float64
+		input    Money
 		expected bool
 	}{
-		"0.00":  {0.00, true},
-		"5.75":  {5.75, true},
-		"25.01": {25.01, false},
+		"0.00":  {0, true},
+		"5.75":  {575, true},
+		"25.01": {2501, false},
 	}
 
 	for name, test := range tests {
@@ -86,7 +151,7 @@ func TestIsMultipleOfQuarter(t *testing.T) {
 	}
 }
 
-func TestCountEveryTwoItems(t *testing.T) {
+func TestCountItemGroups(t *testing.T) {
 	tests := map[string]struct {
 		input    []ReceiptItem
 		expected int64
@@ -99,14 +164,14 @@ func TestCountEveryTwoItems(t *testing.T) {
 	for name, test := range tests {
 		t.Run(name, func(t *testing.T) {
 			t.Parallel()
-			if got, want := countEveryTwoItems(test.input), test.expected; got != want {
+			if got, want := countItemGroups(test.input, 2), test.expected; got != want {
 				t.Errorf("expected count %d, but got %d", want, got)
 			}
 		})
 	}
 }
 
-func TestIsStringLengthMultipleOfThree(t *testing.T) {
+func TestIsStringLengthMultipleOf(t *testing.T) {
 	tests := map[string]struct {
 		input    string
 		expected bool
@@ -119,7 +184,7 @@ func TestIsStringLengthMultipleOfThree(t *testing.T) {
 	for name, test := range tests {
 		t.Run(name, func(t *testing.T) {
 			t.Parallel()
-			if got, want := isStringLengthMultipleOfThree(test.input), test.expected; got != want {
+			if got, want := isStringLengthMultipleOf(test.input, 3), test.expected; got != want {
 				t.Errorf("expected %t, but got %t", want, got)
 			}
 		})
@@ -146,7 +211,7 @@ func TestIsOddDay(t *testing.T) {
 	}
 }
 
-func TestIsTimeBetweenTwoPMAndFourPM(t *testing.T) {
+func TestIsTimeBetween(t *testing.T) {
 	tests := map[string]struct {
 		hour     int
 		min      int
@@ -162,7 +227,7 @@ func TestIsTimeBetweenTwoPMAndFourPM(t *testing.T) {
 		t.Run(name, func(t *testing.T) {
 			t.Parallel()
 			inputTime := time.Date(2024, time.January, 1, test.hour, test.min, 0, 0, time.UTC)
-			if got, want := isTimeBetweenTwoPMAndFourPM(inputTime), test.expected; got != want {
+			if got, want := isTimeBetween(inputTime, 14*time.Hour, 16*time.Hour), test.expected; got != want {
 				t.Errorf("expected %t, but got %t", want, got)
 			}
 		})
@@ -0,0 +1,113 @@
+package receipt
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"regexp"
+	"strconv"
+)
+
+// Money is an exact amount in cents.
+type Money int64
+
+var moneyRegex = regexp.MustCompile(`^\d+\.\d{2}$`)
+
+// ParseMoney parses a non-negative amount with exactly two decimal places,
+// such as "6.49".
+func ParseMoney(s string) (Money, error) {
+	if !moneyRegex.MatchString(s) {
+		return 0, fmt.Errorf("amount %q must be a decimal number with two decimal places", s)
+	}
+	cents, err := strconv.ParseInt(s[:len(s)-3]+s[len(s)-2:], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("amount %q is out of range", s)
+	}
+	return Money(cents), nil
+}
+
+func (m Money) Cents() int64 {
+	return int64(m)
+}
+
+func (m Money) String() string {
+	sign := ""
+	cents := int64(m)
+	if cents < 0 {
+		sign = "-"
+		cents = -cents
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, cents/100, cents%100)
+}
+
+// IsMultipleOf reports whether the amount is an exact multiple of unit.
+func (m Money) IsMultipleOf(unit Money) bool {
+	return m%unit == 0
+}
+
+// MultiplyCeil multiplies the amount in dollars by an exact factor and rounds
+// the result up to the nearest integer.
+func (m Money) MultiplyCeil(factor *big.Rat) int64 {
+	product := new(big.Rat).SetFrac64(int64(m), 100)
+	product.Mul(product, factor)
+
+	// QuoRem truncates toward zero, so only a positive remainder needs rounding up.
+	quotient, remainder := new(big.Int).QuoRem(product.Num(), product.Denom(), new(big.Int))
+	if remainder.Sign() > 0 {
+		quotient.Add(quotient, big.NewInt(1))
+	}
+	return quotient.Int64()
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.String())
+}
+
+// UnmarshalJSON accepts the string form written by MarshalJSON, and also
+// dollar amounts stored as JSON numbers before amounts were kept in cents.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		money, err := ParseMoney(s)
+		if err != nil {
+			return err
+		}
+		*m = money
+		return nil
+	}
+
+	var dollars float64
+	if err := json.Unmarshal(data, &dollars); err != nil {
+		return fmt.Errorf("amount must be a string or number, got %s", data)
+	}
+	*m = Money(math.Round(dollars * 100))
+	return nil
+}
+
+// decimalFactor is an exact decimal multiplier that keeps the text it was
+// parsed from for display.
+type decimalFactor struct {
+	text  string
+	value *big.Rat
+}
+
+func parseDecimalFactor(s string) (decimalFactor, error) {
+	value, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return decimalFactor{}, fmt.Errorf("%q is not a decimal number", s)
+	}
+	return decimalFactor{s, value}, nil
+}
+
+func mustParseDecimalFactor(s string) decimalFactor {
+	factor, err := parseDecimalFactor(s)
+	if err != nil {
+		panic(err)
+	}
+	return factor
+}
+
+func (f decimalFactor) String() string {
+	return f.text
+}
@@ -0,0 +1,121 @@
+package receipt
+
+import (
+	"encoding/json"
+	"math/big"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := map[string]struct {
+		input    string
+		expected Money
+		wantErr  bool
+	}{
+		"cents":                {"6.49", 649, false},
+		"zero":                 {"0.00", 0, false},
+		"large":                {"92233720368547758.07", 9223372036854775807, false},
+		"overflow":             {"92233720368547758.08", 0, true},
+		"one decimal place":    {"6.5", 0, true},
+		"no decimal places":    {"6", 0, true},
+		"negative":             {"-6.49", 0, true},
+		"not a number":         {"price", 0, true},
+		"three decimal places": {"6.495", 0, true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			got, err := ParseMoney(test.input)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("expected error %t, but got %v", test.wantErr, err)
+			}
+			if got != test.expected {
+				t.Errorf("expected amount %d, but got %d", test.expected, got)
+			}
+		})
+	}
+}
+
+func TestMoney_String(t *testing.T) {
+	tests := map[string]struct {
+		input    Money
+		expected string
+	}{
+		"cents":    {649, "6.49"},
+		"zero":     {0, "0.00"},
+		"dollars":  {1200, "12.00"},
+		"negative": {-5, "-0.05"},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			if got := test.input.String(); got != test.expected {
+				t.Errorf("expected %s, but got %s", test.expected, got)
+			}
+		})
+	}
+}
+
+func TestMoney_MultiplyCeil(t *testing.T) {
+	tests := map[string]struct {
+		amount   Money
+		factor   string
+		expected int64
+	}{
+		"rounds up":               {1225, "0.2", 3},
+		"exact product":           {1500, "0.2", 3},
+		"float rounding boundary": {3500, "0.2", 7},
+		"just over":               {1501, "0.2", 4},
+		"zero":                    {0, "0.2", 0},
+		"whole factor":            {649, "2", 13},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			factor, _ := new(big.Rat).SetString(test.factor)
+			if got := test.amount.MultiplyCeil(factor); got != test.expected {
+				t.Errorf("expected %d, but got %d", test.expected, got)
+			}
+		})
+	}
+}
+
+func TestMoney_JSON(t *testing.T) {
+	t.Run("round trip", func(t *testing.T) {
+		data, err := json.Marshal(Money(649))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := string(data), `"6.49"`; got != want {
+			t.Errorf("expected %s, but got %s", want, got)
+		}
+
+		var got Money
+		if err := json.Unmarshal(data, &got); err != nil {
+			t.Fatal(err)
+		}
+		if got != 649 {
+			t.Errorf("expected amount 649, but got %d", got)
+		}
+	})
+
+	t.Run("legacy number", func(t *testing.T) {
+		var got Money
+		if err := json.Unmarshal([]byte("35.35"), &got); err != nil {
+			t.Fatal(err)
+		}
+		if got != 3535 {
+			t.Errorf("expected amount 3535, but got %d", got)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		var got Money
+		if err := json.Unmarshal([]byte(`"6.5"`), &got); err == nil {
+			t.Error("expected has error, but got nothing")
+		}
+	})
+}
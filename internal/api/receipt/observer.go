@@ -0,0 +1,107 @@
+package receipt
+
+import (
+	"context"
+	"time"
+)
+
+// Observer is told what the service and handler did, so it can be measured.
+// Its methods must be safe to call concurrently.
+type Observer interface {
+	// ReceiptProcessed is called once per submitted receipt with one of the
+	// Outcome values.
+	ReceiptProcessed(outcome string)
+	// ReceiptScored is called once per receipt scored without being stored.
+	ReceiptScored()
+	// ValidationFailed is called once per violation with its code and the
+	// Operation of the rejected request.
+	ValidationFailed(operation, code string)
+	// PointsAwarded is called with the breakdown of every stored receipt.
+	PointsAwarded(breakdown *PointsBreakdown)
+	// RepositoryOperation is called after every repository call.
+	RepositoryOperation(operation string, duration time.Duration)
+}
+
+// Outcomes of processing a receipt.
+const (
+	OutcomeStored            = "stored"
+	OutcomeFlagged           = "flagged"
+	OutcomeDuplicateRejected = "duplicate_rejected"
+	OutcomeDuplicateReturned = "duplicate_returned"
+	OutcomeFailed            = "failed"
+)
+
+// Operations whose requests are validated.
+const (
+	OperationProcess = "process"
+	OperationScore   = "score"
+	OperationBatch   = "batch"
+)
+
+type noopObserver struct{}
+
+func (noopObserver) ReceiptProcessed(string)                   {}
+func (noopObserver) ReceiptScored()                            {}
+func (noopObserver) ValidationFailed(string, string)           {}
+func (noopObserver) PointsAwarded(*PointsBreakdown)            {}
+func (noopObserver) RepositoryOperation(string, time.Duration) {}
+
+// observedRepository times every call to the repository it wraps.
+type observedRepository struct {
+	repository Repository
+	observer   Observer
+}
+
+func (r *observedRepository) observe(operation string, start time.Time) {
+	r.observer.RepositoryOperation(operation, time.Since(start))
+}
+
+func (r *observedRepository) Points(ctx context.Context, id string) (int64, error) {
+	defer r.observe("points", time.Now())
+	return r.repository.Points(ctx, id)
+}
+
+func (r *observedRepository) Receipt(ctx context.Context, id string) (*Receipt, error) {
+	defer r.observe("receipt", time.Now())
+	return r.repository.Receipt(ctx, id)
+}
+
+func (r *observedRepository) Record(ctx context.Context, id string) (*Record, error) {
+	defer r.observe("record", time.Now())
+	return r.repository.Record(ctx, id)
+}
+
+func (r *observedRepository) Records(ctx context.Context) ([]Record, error) {
+	defer r.observe("records", time.Now())
+	return r.repository.Records(ctx)
+}
+
+func (r *observedRepository) Count(ctx context.Context) (int, error) {
+	defer r.observe("count", time.Now())
+	return r.repository.Count(ctx)
+}
+
+func (r *observedRepository) FindByFingerprint(ctx context.Context, fingerprint string) (string, error) {
+	defer r.observe("find_by_fingerprint", time.Now())
+	return r.repository.FindByFingerprint(ctx, fingerprint)
+}
+
+func (r *observedRepository) CreatePoints(ctx context.Context, receipt *Receipt, points int64, ruleVersion string) (string, error) {
+	defer r.observe("create_points", time.Now())
+	return r.repository.CreatePoints(ctx, receipt, points, ruleVersion)
+}
+
+func (r *observedRepository) UpdatePoints(ctx context.Context, id string, points int64, ruleVersion string) error {
+	defer r.observe("update_points", time.Now())
+	return r.repository.UpdatePoints(ctx, id, points, ruleVersion)
+}
+
+func (r *observedRepository) Ping(ctx context.Context) error {
+	defer r.observe("ping", time.Now())
+	return r.repository.Ping(ctx)
+}
+
+func (r *observedRepository) Close() error {
+	defer r.observe("close", time.Now())
+	return r.repository.Close()
+}
@@ -0,0 +1,104 @@
+package receipt
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordingObserver struct {
+	lock       sync.Mutex
+	outcomes   []string
+	scored     int
+	violations []string
+	points     map[string]int64
+	operations []string
+}
+
+func (o *recordingObserver) ReceiptProcessed(outcome string) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.outcomes = append(o.outcomes, outcome)
+}
+
+func (o *recordingObserver) ReceiptScored() {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.scored++
+}
+
+func (o *recordingObserver) ValidationFailed(operation, code string) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.violations = append(o.violations, operation+":"+code)
+}
+
+func (o *recordingObserver) PointsAwarded(breakdown *PointsBreakdown) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if o.points == nil {
+		o.points = make(map[string]int64)
+	}
+	for _, rule := range breakdown.Rules {
+		o.points[rule.Name] += rule.Points
+	}
+}
+
+func (o *recordingObserver) RepositoryOperation(operation string, duration time.Duration) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.operations = append(o.operations, operation)
+}
+
+func TestReceiptService_Observer(t *testing.T) {
+	observer := &recordingObserver{}
+	service := NewService(NewRepository(), WithObserver(observer), WithDuplicatePolicy(DuplicateReject))
+
+	mustProcess(t, service, testReceipt())
+	if _, err := service.Process(context.Background(), testReceipt()); err == nil {
+		t.Fatal("expected has error, but got nothing")
+	}
+
+	if want := []string{OutcomeStored, OutcomeDuplicateRejected}; !reflect.DeepEqual(observer.outcomes, want) {
+		t.Errorf("expected outcomes %v, but got %v", want, observer.outcomes)
+	}
+	if got, want := observer.points["retailerAlphanumeric"], int64(6); got != want {
+		t.Errorf("expected %d retailer points, but got %d", want, got)
+	}
+	if want := []string{"find_by_fingerprint", "create_points", "find_by_fingerprint"}; !reflect.DeepEqual(observer.operations, want) {
+		t.Errorf("expected operations %v, but got %v", want, observer.operations)
+	}
+}
+
+func TestReceiptHandler_ValidationObserver(t *testing.T) {
+	observer := &recordingObserver{}
+	handler := NewHandler(&stubService{}, WithValidationObserver(observer))
+
+	body := `{"purchaseDate":"2022-01-01","purchaseTime":"13:01","items":[{"shortDescription":"Mountain Dew 12PK","price":"6.4"}],"total":"6.49"}`
+	for path, serve := range map[string]http.HandlerFunc{"/receipts/process": handler.Process, "/receipts/score": handler.Score} {
+		request, err := http.NewRequest("POST", path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		response := httptest.NewRecorder()
+		serve(response, request)
+		assertStatus(t, response, http.StatusBadRequest)
+	}
+
+	sort.Strings(observer.violations)
+	want := []string{
+		OperationProcess + ":" + CodeInvalidAmount,
+		OperationProcess + ":" + CodeRequired,
+		OperationScore + ":" + CodeInvalidAmount,
+		OperationScore + ":" + CodeRequired,
+	}
+	if !reflect.DeepEqual(observer.violations, want) {
+		t.Errorf("expected violations %v, but got %v", want, observer.violations)
+	}
+}
@@ -1,50 +1,242 @@
 package receipt
 
 import (
+	"context"
+	"sort"
 	"sync"
 
 	"github.com/google/uuid"
 )
 
+// Repository stores receipts and their points. Lookups of an unknown receipt
+// fail with ErrReceiptNotFound; any other error means the storage could not
+// be used. A call fails with the context's error if it is already done.
 type Repository interface {
-	Points(id string) (int64, bool)
-	CreatePoints(points int64) string
+	Points(ctx context.Context, id string) (int64, error)
+	Receipt(ctx context.Context, id string) (*Receipt, error)
+	Record(ctx context.Context, id string) (*Record, error)
+	Records(ctx context.Context) ([]Record, error)
+	Count(ctx context.Context) (int, error)
+	// FindByFingerprint returns the ID of the first receipt stored with the
+	// fingerprint, or ErrReceiptNotFound if there is none.
+	FindByFingerprint(ctx context.Context, fingerprint string) (string, error)
+	CreatePoints(ctx context.Context, receipt *Receipt, points int64, ruleVersion string) (string, error)
+	UpdatePoints(ctx context.Context, id string, points int64, ruleVersion string) error
+	// Ping reports whether the storage can currently be read and written.
+	Ping(ctx context.Context) error
+	// Close flushes pending writes and releases the storage. The repository
+	// must not be used afterwards.
+	Close() error
+}
+
+// ActiveVersionStore is implemented by a Repository that keeps the active rule
+// version, so the version a recalculation commits to outlives a restart.
+type ActiveVersionStore interface {
+	SaveActiveVersion(ctx context.Context, version string) error
+	// ActiveVersion returns the version last saved, or "" if none was.
+	ActiveVersion() string
+}
+
+// Record is a stored receipt together with the points it was awarded and the
+// version of the rule set that calculated them. DuplicateOf is the ID of the
+// first stored receipt with the same fingerprint, if any.
+type Record struct {
+	ID          string
+	Receipt     Receipt
+	Points      int64
+	RuleVersion string
+	Fingerprint string
+	DuplicateOf string
+	// Legacy marks a record written before receipts were stored. It only has
+	// points, so its receipt cannot be read or scored again.
+	Legacy bool
 }
 
 type inMemoryRepository struct {
-	lock   sync.RWMutex
-	points map[string]int64
+	lock         sync.RWMutex
+	receipts     map[string]*Record
+	fingerprints map[string]string
 }
 
 func NewRepository() Repository {
+	return newInMemoryRepository()
+}
+
+func (s *inMemoryRepository) Count(ctx context.Context) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return len(s.receipts), nil
+}
+
+// Ping always succeeds, as memory is always available.
+func (s *inMemoryRepository) Ping(ctx context.Context) error {
+	return nil
+}
+
+// Close does nothing, as there is nothing to flush.
+func (s *inMemoryRepository) Close() error {
+	return nil
+}
+
+func newInMemoryRepository() *inMemoryRepository {
 	return &inMemoryRepository{
-		points: make(map[string]int64),
+		receipts:     make(map[string]*Record),
+		fingerprints: make(map[string]string),
 	}
 }
 
-func (s *inMemoryRepository) Points(id string) (int64, bool) {
+func (s *inMemoryRepository) Points(ctx context.Context, id string) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
 	s.lock.RLock()
 	defer s.lock.RUnlock()
 
-	points, ok := s.points[id]
-	return points, ok
+	record, ok := s.receipts[id]
+	if !ok {
+		return 0, ErrReceiptNotFound
+	}
+	return record.Points, nil
 }
 
-func (s *inMemoryRepository) CreatePoints(points int64) string {
+func (s *inMemoryRepository) Receipt(ctx context.Context, id string) (*Receipt, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	record, ok := s.receipts[id]
+	if !ok {
+		return nil, ErrReceiptNotFound
+	}
+	if record.Legacy {
+		return nil, ErrReceiptNotStored
+	}
+	return record.Receipt.clone(), nil
+}
+
+func (s *inMemoryRepository) Record(ctx context.Context, id string) (*Record, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	record, ok := s.receipts[id]
+	if !ok {
+		return nil, ErrReceiptNotFound
+	}
+	return record.clone(), nil
+}
+
+// Records returns a copy of every record, ordered by ID.
+func (s *inMemoryRepository) Records(ctx context.Context) ([]Record, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	records := make([]Record, 0, len(s.receipts))
+	for _, record := range s.receipts {
+		records = append(records, *record.clone())
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].ID < records[j].ID
+	})
+	return records, nil
+}
+
+func (s *inMemoryRepository) FindByFingerprint(ctx context.Context, fingerprint string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	id, ok := s.fingerprints[fingerprint]
+	if !ok {
+		return "", ErrReceiptNotFound
+	}
+	return id, nil
+}
+
+func (s *inMemoryRepository) CreatePoints(ctx context.Context, receipt *Receipt, points int64, ruleVersion string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
 	id := s.generateID()
-	s.points[id] = points
-	return id
+	s.put(id, receipt, points, ruleVersion)
+	return id, nil
+}
+
+func (s *inMemoryRepository) UpdatePoints(ctx context.Context, id string, points int64, ruleVersion string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if !s.update(id, points, ruleVersion) {
+		return ErrReceiptNotFound
+	}
+	return nil
+}
+
+// put stores a copy of the receipt. The caller must hold the write lock.
+func (s *inMemoryRepository) put(id string, receipt *Receipt, points int64, ruleVersion string) {
+	record := &Record{
+		ID:          id,
+		Receipt:     *receipt.clone(),
+		Points:      points,
+		RuleVersion: ruleVersion,
+	}
+	record.Fingerprint = receipt.Fingerprint()
+	if original, ok := s.fingerprints[record.Fingerprint]; ok {
+		record.DuplicateOf = original
+	} else {
+		s.fingerprints[record.Fingerprint] = id
+	}
+	s.receipts[id] = record
+}
+
+// putLegacy stores a record that has points but no receipt. The caller must
+// hold the write lock.
+func (s *inMemoryRepository) putLegacy(id string, points int64, ruleVersion string) {
+	s.receipts[id] = &Record{ID: id, Points: points, RuleVersion: ruleVersion, Legacy: true}
+}
+
+// update changes the points of an existing record. The caller must hold the
+// write lock.
+func (s *inMemoryRepository) update(id string, points int64, ruleVersion string) bool {
+	record, ok := s.receipts[id]
+	if !ok {
+		return false
+	}
+	record.Points = points
+	record.RuleVersion = ruleVersion
+	return true
 }
 
 func (s *inMemoryRepository) generateID() string {
 	for {
 		id := uuid.New().String()
-		_, ok := s.points[id]
+		_, ok := s.receipts[id]
 		if !ok {
 			return id
 		}
 	}
 }
+
+func (r *Record) clone() *Record {
+	record := *r
+	record.Receipt = *r.Receipt.clone()
+	return &record
+}
@@ -1,7 +1,11 @@
 package receipt
 
 import (
+	"context"
+	"errors"
+	"reflect"
 	"testing"
+	"time"
 )
 
 func TestReceiptRepository(t *testing.T) {
@@ -11,48 +15,187 @@ func TestReceiptRepository(t *testing.T) {
 		id := "test-id"
 		points := int64(100)
 
-		repo.(*inMemoryRepository).points[id] = points
+		repo.(*inMemoryRepository).receipts[id] = &Record{ID: id, Receipt: *testReceipt(), Points: points, RuleVersion: "v1"}
 
-		got, ok := repo.Points(id)
-		assertPoints(t, got, ok, points)
+		got, err := repo.Points(context.Background(), id)
+		assertPoints(t, got, err, points)
 	})
 
 	t.Run("not found", func(t *testing.T) {
 		id := "non-existent-id"
 
-		got, ok := repo.Points(id)
-		assertNoPoints(t, got, ok)
+		got, err := repo.Points(context.Background(), id)
+		assertNoPoints(t, got, err)
 	})
 
 	t.Run("create points", func(t *testing.T) {
 		points := int64(100)
 
-		id := repo.CreatePoints(points)
-		if id == "" {
-			t.Fatal("expected ID, but got nothing")
+		id := createPoints(t, repo, testReceipt(), points, "v1")
+
+		got := repo.(*inMemoryRepository).receipts[id].Points
+		assertPoints(t, got, nil, points)
+	})
+
+	t.Run("get receipt", func(t *testing.T) {
+		id := createPoints(t, repo, testReceipt(), 28, "v1")
+
+		got, err := repo.Receipt(context.Background(), id)
+		assertReceipt(t, got, err, testReceipt())
+	})
+
+	t.Run("receipt not found", func(t *testing.T) {
+		got, err := repo.Receipt(context.Background(), "non-existent-id")
+		if !errors.Is(err, ErrReceiptNotFound) || got != nil {
+			t.Errorf("expected %v, but got %v with %v", ErrReceiptNotFound, got, err)
 		}
+	})
 
-		got := repo.(*inMemoryRepository).points[id]
-		assertPoints(t, got, true, points)
+	t.Run("stored receipt is a copy", func(t *testing.T) {
+		receipt := testReceipt()
+		id := createPoints(t, repo, receipt, 28, "v1")
+		receipt.Items[0].ShortDescription = "changed"
+
+		got, err := repo.Receipt(context.Background(), id)
+		assertReceipt(t, got, err, testReceipt())
 	})
 }
 
-func assertPoints(t *testing.T, got int64, ok bool, want int64) {
+func TestReceiptRepository_Records(t *testing.T) {
+	repo := NewRepository()
+	first := createPoints(t, repo, testReceipt(), 28, "v1")
+	second := createPoints(t, repo, testReceipt(), 109, "v1")
+
+	t.Run("get record", func(t *testing.T) {
+		got, err := repo.Record(context.Background(), first)
+		if err != nil {
+			t.Fatalf("expected record, but got %v", err)
+		}
+		want := &Record{ID: first, Receipt: *testReceipt(), Points: 28, RuleVersion: "v1", Fingerprint: testReceipt().Fingerprint()}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("expected record %v, but got %v", want, got)
+		}
+	})
+
+	t.Run("duplicate of first receipt", func(t *testing.T) {
+		got, _ := repo.Record(context.Background(), second)
+		if got.DuplicateOf != first {
+			t.Errorf("expected duplicate of %s, but got %q", first, got.DuplicateOf)
+		}
+		if id, err := repo.FindByFingerprint(context.Background(), testReceipt().Fingerprint()); err != nil || id != first {
+			t.Errorf("expected fingerprint to find %s, but got %q with %v", first, id, err)
+		}
+		if _, err := repo.FindByFingerprint(context.Background(), "unknown"); !errors.Is(err, ErrReceiptNotFound) {
+			t.Errorf("expected %v, but got %v", ErrReceiptNotFound, err)
+		}
+	})
+
+	t.Run("list records", func(t *testing.T) {
+		records, err := repo.Records(context.Background())
+		if err != nil {
+			t.Fatalf("expected no error, but got %v", err)
+		}
+		if got, want := len(records), 2; got != want {
+			t.Fatalf("expected %d records, but got %d", want, got)
+		}
+		if records[0].ID > records[1].ID {
+			t.Errorf("expected records ordered by ID, but got %s before %s", records[0].ID, records[1].ID)
+		}
+	})
+
+	t.Run("update points", func(t *testing.T) {
+		if err := repo.UpdatePoints(context.Background(), second, 50, "v2"); err != nil {
+			t.Fatalf("expected no error, but got %v", err)
+		}
+		got, _ := repo.Record(context.Background(), second)
+		if got.Points != 50 || got.RuleVersion != "v2" {
+			t.Errorf("expected points 50 with version v2, but got %d with version %s", got.Points, got.RuleVersion)
+		}
+	})
+
+	t.Run("update unknown receipt", func(t *testing.T) {
+		if err := repo.UpdatePoints(context.Background(), "non-existent-id", 50, "v2"); !errors.Is(err, ErrReceiptNotFound) {
+			t.Errorf("expected %v, but got %v", ErrReceiptNotFound, err)
+		}
+	})
+}
+
+func TestReceiptRepository_Canceled(t *testing.T) {
+	repo := NewRepository()
+	id := createPoints(t, repo, testReceipt(), 28, "v1")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	calls := map[string]func() error{
+		"points":              func() error { _, err := repo.Points(ctx, id); return err },
+		"receipt":             func() error { _, err := repo.Receipt(ctx, id); return err },
+		"record":              func() error { _, err := repo.Record(ctx, id); return err },
+		"records":             func() error { _, err := repo.Records(ctx); return err },
+		"count":               func() error { _, err := repo.Count(ctx); return err },
+		"find by fingerprint": func() error { _, err := repo.FindByFingerprint(ctx, testReceipt().Fingerprint()); return err },
+		"create points":       func() error { _, err := repo.CreatePoints(ctx, testReceipt(), 28, "v1"); return err },
+		"update points":       func() error { return repo.UpdatePoints(ctx, id, 50, "v2") },
+	}
+
+	for name, call := range calls {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			if err := call(); !errors.Is(err, context.Canceled) {
+				t.Errorf("expected error %v, but got %v", context.Canceled, err)
+			}
+		})
+	}
+}
+
+func testReceipt() *Receipt {
+	return &Receipt{
+		Retailer:     "Target",
+		PurchaseTime: time.Date(2022, time.January, 1, 13, 1, 0, 0, time.UTC),
+		Items:        []ReceiptItem{{"Mountain Dew 12PK", 649}},
+		Total:        649,
+	}
+}
+
+// createPoints stores the receipt, failing the test if it cannot.
+func createPoints(t *testing.T, repo Repository, receipt *Receipt, points int64, ruleVersion string) string {
 	t.Helper()
-	if !ok {
-		t.Fatalf("expected points %d, but got nil", want)
+	id, err := repo.CreatePoints(context.Background(), receipt, points, ruleVersion)
+	if err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+	if id == "" {
+		t.Fatal("expected ID, but got nothing")
+	}
+	return id
+}
+
+func assertPoints(t *testing.T, got int64, err error, want int64) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("expected points %d, but got %v", want, err)
 	}
 	if got != want {
 		t.Errorf("expected points %d, but got %d", want, got)
 	}
 }
 
-func assertNoPoints(t *testing.T, got int64, ok bool) {
+func assertNoPoints(t *testing.T, got int64, err error) {
 	t.Helper()
-	if ok {
-		t.Errorf("expected no points, but got %d", got)
+	if !errors.Is(err, ErrReceiptNotFound) {
+		t.Errorf("expected %v, but got %v", ErrReceiptNotFound, err)
 	}
 	if got != 0 {
 		t.Errorf("expected points 0, but got %d", got)
 	}
 }
+
+func assertReceipt(t *testing.T, got *Receipt, err error, want *Receipt) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("expected receipt %v, but got %v", want, err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected receipt %v, but got %v", want, got)
+	}
+}
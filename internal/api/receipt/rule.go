@@ -0,0 +1,245 @@
+package receipt
+
+import (
+	"time"
+)
+
+// Rule awards points for one property of a receipt.
+type Rule interface {
+	Name() string
+	Evaluate(receipt *Receipt) RulePoints
+}
+
+// PointsBreakdown explains a points total by listing what each rule awarded.
+type PointsBreakdown struct {
+	Points      int64        `json:"points"`
+	RuleVersion string       `json:"ruleVersion,omitempty"`
+	Rules       []RulePoints `json:"rules"`
+}
+
+// RulePoints is the contribution of a single rule and the inputs it used.
+type RulePoints struct {
+	Name   string         `json:"name"`
+	Points int64          `json:"points"`
+	Inputs map[string]any `json:"inputs"`
+	Items  []ItemPoints   `json:"items,omitempty"`
+}
+
+// ItemPoints is the contribution of a single item to a per-item rule.
+type ItemPoints struct {
+	Index            int    `json:"index"`
+	ShortDescription string `json:"shortDescription"`
+	TrimmedLength    int    `json:"trimmedLength"`
+	Price            Money  `json:"price"`
+	Points           int64  `json:"points"`
+}
+
+// RuleSet is an ordered list of rules whose points are added together. The
+// version is recorded with every receipt the rule set scores.
+type RuleSet struct {
+	version string
+	rules   []Rule
+}
+
+func NewRuleSet(rules ...Rule) *RuleSet {
+	return &RuleSet{rules: append([]Rule(nil), rules...)}
+}
+
+const DefaultRuleVersion = "builtin"
+
+// DefaultRuleSet returns the built-in rules in their standard order.
+func DefaultRuleSet() *RuleSet {
+	return NewVersionedRuleSet(DefaultRuleVersion,
+		retailerAlphanumericRule{pointsPerCharacter: 1},
+		roundDollarTotalRule{points: 50},
+		quarterMultipleTotalRule{points: 25},
+		itemPairsRule{pairSize: 2, points: 5},
+		itemDescriptionLengthRule{divisor: 3, multiplier: mustParseDecimalFactor("0.2")},
+		oddPurchaseDayRule{points: 6},
+		afternoonPurchaseTimeRule{after: 14 * time.Hour, before: 16 * time.Hour, points: 10},
+	)
+}
+
+func NewVersionedRuleSet(version string, rules ...Rule) *RuleSet {
+	return &RuleSet{version: version, rules: append([]Rule(nil), rules...)}
+}
+
+func (s *RuleSet) Version() string {
+	return s.version
+}
+
+// Rules returns a copy of the rules in evaluation order, which can be edited
+// and passed to NewRuleSet to add, remove, or reorder rules.
+func (s *RuleSet) Rules() []Rule {
+	return append([]Rule(nil), s.rules...)
+}
+
+func (s *RuleSet) Evaluate(receipt *Receipt) *PointsBreakdown {
+	breakdown := &PointsBreakdown{
+		RuleVersion: s.version,
+		Rules:       make([]RulePoints, 0, len(s.rules)),
+	}
+	for _, rule := range s.rules {
+		result := rule.Evaluate(receipt)
+		result.Name = rule.Name()
+		breakdown.Points += result.Points
+		breakdown.Rules = append(breakdown.Rules, result)
+	}
+	return breakdown
+}
+
+// namedRule gives a rule a different name, so the same kind of rule can appear
+// more than once in a rule set.
+type namedRule struct {
+	Rule
+	name string
+}
+
+func (r namedRule) Name() string {
+	return r.name
+}
+
+// Points for every alphanumeric character in the retailer
+type retailerAlphanumericRule struct {
+	pointsPerCharacter int64
+}
+
+func (retailerAlphanumericRule) Name() string {
+	return "retailerAlphanumeric"
+}
+
+func (r retailerAlphanumericRule) Evaluate(receipt *Receipt) RulePoints {
+	count := countByAlphanumericCharacter(receipt.Retailer)
+	return RulePoints{
+		Points: r.pointsPerCharacter * count,
+		Inputs: map[string]any{"retailer": receipt.Retailer, "alphanumericCount": count},
+	}
+}
+
+// Points if the total is a round dollar amount with no cents
+type roundDollarTotalRule struct {
+	points int64
+}
+
+func (roundDollarTotalRule) Name() string {
+	return "roundDollarTotal"
+}
+
+func (r roundDollarTotalRule) Evaluate(receipt *Receipt) RulePoints {
+	result := RulePoints{Inputs: map[string]any{"total": receipt.Total}}
+	if isRoundDollarAmount(receipt.Total) {
+		result.Points = r.points
+	}
+	return result
+}
+
+// Points if the total is a multiple of 0.25
+type quarterMultipleTotalRule struct {
+	points int64
+}
+
+func (quarterMultipleTotalRule) Name() string {
+	return "quarterMultipleTotal"
+}
+
+func (r quarterMultipleTotalRule) Evaluate(receipt *Receipt) RulePoints {
+	result := RulePoints{Inputs: map[string]any{"total": receipt.Total}}
+	if isMultipleOfQuarter(receipt.Total) {
+		result.Points = r.points
+	}
+	return result
+}
+
+// Points for every group of pairSize items on the receipt
+type itemPairsRule struct {
+	pairSize int
+	points   int64
+}
+
+func (itemPairsRule) Name() string {
+	return "itemPairs"
+}
+
+func (r itemPairsRule) Evaluate(receipt *Receipt) RulePoints {
+	pairs := countItemGroups(receipt.Items, r.pairSize)
+	return RulePoints{
+		Points: r.points * pairs,
+		Inputs: map[string]any{"itemCount": len(receipt.Items), "pairSize": r.pairSize, "pairs": pairs},
+	}
+}
+
+// If the trimmed length of the item description is a multiple of the divisor,
+// multiply the price by the multiplier and round up to the nearest integer.
+// The result is the number of points earned.
+type itemDescriptionLengthRule struct {
+	divisor    int
+	multiplier decimalFactor
+}
+
+func (itemDescriptionLengthRule) Name() string {
+	return "itemDescriptionLength"
+}
+
+func (r itemDescriptionLengthRule) Evaluate(receipt *Receipt) RulePoints {
+	result := RulePoints{
+		Inputs: map[string]any{"divisor": r.divisor, "multiplier": r.multiplier.String()},
+		Items:  make([]ItemPoints, len(receipt.Items)),
+	}
+	for i, item := range receipt.Items {
+		itemPoints := ItemPoints{
+			Index:            i,
+			ShortDescription: item.ShortDescription,
+			TrimmedLength:    trimmedLength(item.ShortDescription),
+			Price:            item.Price,
+		}
+		if isStringLengthMultipleOf(item.ShortDescription, r.divisor) {
+			itemPoints.Points = item.Price.MultiplyCeil(r.multiplier.value)
+		}
+		result.Points += itemPoints.Points
+		result.Items[i] = itemPoints
+	}
+	return result
+}
+
+// Points if the day in the purchase date is odd
+type oddPurchaseDayRule struct {
+	points int64
+}
+
+func (oddPurchaseDayRule) Name() string {
+	return "oddPurchaseDay"
+}
+
+func (r oddPurchaseDayRule) Evaluate(receipt *Receipt) RulePoints {
+	result := RulePoints{Inputs: map[string]any{"day": receipt.PurchaseTime.Day()}}
+	if isOddDay(receipt.PurchaseTime) {
+		result.Points = r.points
+	}
+	return result
+}
+
+// Points if the time of purchase is strictly inside a time window, 2:00pm to
+// 4:00pm by default
+type afternoonPurchaseTimeRule struct {
+	after  time.Duration
+	before time.Duration
+	points int64
+}
+
+func (afternoonPurchaseTimeRule) Name() string {
+	return "afternoonPurchaseTime"
+}
+
+func (r afternoonPurchaseTimeRule) Evaluate(receipt *Receipt) RulePoints {
+	result := RulePoints{
+		Inputs: map[string]any{
+			"purchaseTime": receipt.PurchaseTime.Format(clockLayout),
+			"after":        formatClock(r.after),
+			"before":       formatClock(r.before),
+		},
+	}
+	if isTimeBetween(receipt.PurchaseTime, r.after, r.before) {
+		result.Points = r.points
+	}
+	return result
+}
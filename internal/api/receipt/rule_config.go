@@ -0,0 +1,276 @@
+package receipt
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+const clockLayout = "15:04"
+
+// ruleSetConfig is the JSON form of a rule set. The version identifies the rule
+// set on every receipt it scores, so it must change whenever the rules do. Each
+// rule names a built-in rule type and its parameters; parameters left out keep
+// their default values.
+//
+//	{
+//	  "version": "2024-q3",
+//	  "rules": [
+//	    {"type": "retailerAlphanumeric", "pointsPerCharacter": 1},
+//	    {"type": "afternoonPurchaseTime", "name": "lunchRush", "after": "11:00", "before": "13:00", "points": 15}
+//	  ]
+//	}
+type ruleSetConfig struct {
+	Version string       `json:"version"`
+	Rules   []ruleConfig `json:"rules"`
+}
+
+// ruleConfig holds the type and optional name of a rule, and keeps the rest of
+// its JSON object to be decoded into the parameters for that type.
+type ruleConfig struct {
+	Type   string
+	Name   string
+	Params json.RawMessage
+}
+
+func (c *ruleConfig) UnmarshalJSON(data []byte) error {
+	var header struct {
+		Type string `json:"type"`
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return err
+	}
+	c.Type = header.Type
+	c.Name = header.Name
+	c.Params = append(json.RawMessage(nil), data...)
+	return nil
+}
+
+type pointsParams struct {
+	Points int64 `json:"points"`
+}
+
+type retailerAlphanumericParams struct {
+	PointsPerCharacter int64 `json:"pointsPerCharacter"`
+}
+
+type itemPairsParams struct {
+	PairSize int   `json:"pairSize"`
+	Points   int64 `json:"points"`
+}
+
+type itemDescriptionLengthParams struct {
+	Divisor int `json:"divisor"`
+	// Multiplier keeps the number as written so it can be applied exactly.
+	Multiplier json.Number `json:"multiplier"`
+}
+
+type timeWindowParams struct {
+	After  string `json:"after"`
+	Before string `json:"before"`
+	Points int64  `json:"points"`
+}
+
+// LoadRuleSet reads a rule set from a JSON file.
+func LoadRuleSet(path string) (*RuleSet, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open rule config, %w", err)
+	}
+	defer file.Close()
+
+	rules, err := ParseRuleSet(file)
+	if err != nil {
+		return nil, fmt.Errorf("rule config %s is invalid, %w", path, err)
+	}
+	return rules, nil
+}
+
+func ParseRuleSet(r io.Reader) (*RuleSet, error) {
+	var config ruleSetConfig
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		return nil, err
+	}
+	return config.RuleSet()
+}
+
+func (c *ruleSetConfig) RuleSet() (*RuleSet, error) {
+	if c.Version == "" {
+		return nil, fmt.Errorf("version is required")
+	}
+	if len(c.Rules) == 0 {
+		return nil, fmt.Errorf("minimum of one rule is required")
+	}
+
+	rules := make([]Rule, len(c.Rules))
+	names := make(map[string]bool, len(c.Rules))
+	for i, ruleConfig := range c.Rules {
+		rule, err := ruleConfig.Rule()
+		if err != nil {
+			return nil, fmt.Errorf("rule %d is invalid: %w", i, err)
+		}
+		if names[rule.Name()] {
+			return nil, fmt.Errorf("rule %d is invalid: name %q is already used, set a different name", i, rule.Name())
+		}
+		names[rule.Name()] = true
+		rules[i] = rule
+	}
+	return NewVersionedRuleSet(c.Version, rules...), nil
+}
+
+func (c *ruleConfig) Rule() (Rule, error) {
+	rule, err := c.builtinRule()
+	if err != nil {
+		return nil, err
+	}
+	if c.Name != "" {
+		return namedRule{rule, c.Name}, nil
+	}
+	return rule, nil
+}
+
+func (c *ruleConfig) builtinRule() (Rule, error) {
+	switch c.Type {
+	case "retailerAlphanumeric":
+		params := retailerAlphanumericParams{PointsPerCharacter: 1}
+		if err := c.decodeParams(&params); err != nil {
+			return nil, err
+		}
+		if err := checkPoints("points per character", params.PointsPerCharacter); err != nil {
+			return nil, err
+		}
+		return retailerAlphanumericRule{params.PointsPerCharacter}, nil
+
+	case "roundDollarTotal":
+		params := pointsParams{Points: 50}
+		if err := c.decodeParams(&params); err != nil {
+			return nil, err
+		}
+		if err := checkPoints("points", params.Points); err != nil {
+			return nil, err
+		}
+		return roundDollarTotalRule{params.Points}, nil
+
+	case "quarterMultipleTotal":
+		params := pointsParams{Points: 25}
+		if err := c.decodeParams(&params); err != nil {
+			return nil, err
+		}
+		if err := checkPoints("points", params.Points); err != nil {
+			return nil, err
+		}
+		return quarterMultipleTotalRule{params.Points}, nil
+
+	case "itemPairs":
+		params := itemPairsParams{PairSize: 2, Points: 5}
+		if err := c.decodeParams(&params); err != nil {
+			return nil, err
+		}
+		if params.PairSize <= 0 {
+			return nil, fmt.Errorf("pair size must be positive, got %d", params.PairSize)
+		}
+		if err := checkPoints("points", params.Points); err != nil {
+			return nil, err
+		}
+		return itemPairsRule{params.PairSize, params.Points}, nil
+
+	case "itemDescriptionLength":
+		params := itemDescriptionLengthParams{Divisor: 3, Multiplier: "0.2"}
+		if err := c.decodeParams(&params); err != nil {
+			return nil, err
+		}
+		if params.Divisor <= 0 {
+			return nil, fmt.Errorf("divisor must be positive, got %d", params.Divisor)
+		}
+		multiplier, err := parseDecimalFactor(params.Multiplier.String())
+		if err != nil {
+			return nil, fmt.Errorf("multiplier is invalid, %v", err)
+		}
+		if multiplier.value.Sign() < 0 {
+			return nil, fmt.Errorf("multiplier must not be negative, got %s", multiplier)
+		}
+		return itemDescriptionLengthRule{params.Divisor, multiplier}, nil
+
+	case "oddPurchaseDay":
+		params := pointsParams{Points: 6}
+		if err := c.decodeParams(&params); err != nil {
+			return nil, err
+		}
+		if err := checkPoints("points", params.Points); err != nil {
+			return nil, err
+		}
+		return oddPurchaseDayRule{params.Points}, nil
+
+	case "afternoonPurchaseTime":
+		params := timeWindowParams{After: "14:00", Before: "16:00", Points: 10}
+		if err := c.decodeParams(&params); err != nil {
+			return nil, err
+		}
+		after, err := parseClock(params.After)
+		if err != nil {
+			return nil, fmt.Errorf("after is not a valid time, %v", err)
+		}
+		before, err := parseClock(params.Before)
+		if err != nil {
+			return nil, fmt.Errorf("before is not a valid time, %v", err)
+		}
+		if after >= before {
+			return nil, fmt.Errorf("after %s must be earlier than before %s", params.After, params.Before)
+		}
+		if err := checkPoints("points", params.Points); err != nil {
+			return nil, err
+		}
+		return afternoonPurchaseTimeRule{after, before, params.Points}, nil
+
+	case "":
+		return nil, fmt.Errorf("type is required")
+	default:
+		return nil, fmt.Errorf("unknown rule type %q", c.Type)
+	}
+}
+
+// checkPoints rejects a negative number of points, as rules only award points.
+func checkPoints(name string, points int64) error {
+	if points < 0 {
+		return fmt.Errorf("%s must not be negative, got %d", name, points)
+	}
+	return nil
+}
+
+// decodeParams decodes the rule parameters over the defaults already set in
+// params, rejecting any field that the rule type does not understand.
+func (c *ruleConfig) decodeParams(params any) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(c.Params, &fields); err != nil {
+		return err
+	}
+	delete(fields, "type")
+	delete(fields, "name")
+
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	decoder.UseNumber()
+	return decoder.Decode(params)
+}
+
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse(clockLayout, s)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func formatClock(d time.Duration) string {
+	return time.Time{}.Add(d).Format(clockLayout)
+}
@@ -0,0 +1,117 @@
+package receipt
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadRuleSet(t *testing.T) {
+	t.Run("sample config matches default rules", func(t *testing.T) {
+		rules, err := LoadRuleSet("../../../config/rules.json")
+		if err != nil {
+			t.Fatalf("expected no error, but got %v", err)
+		}
+
+		receipt := &Receipt{
+			Retailer:     "M&M Corner Market",
+			PurchaseTime: time.Date(2022, time.March, 21, 14, 33, 0, 0, time.UTC),
+			Items:        []ReceiptItem{{"Gatorade", 225}, {"Emils Cheese Pizza", 1225}, {"Gatorade", 225}},
+			Total:        1675,
+		}
+		got, want := rules.Evaluate(receipt), DefaultRuleSet().Evaluate(receipt)
+		if !reflect.DeepEqual(got.Rules, want.Rules) {
+			t.Errorf("expected rules %v, but got %v", want.Rules, got.Rules)
+		}
+		if got.RuleVersion != "v1" {
+			t.Errorf("expected rule version v1, but got %s", got.RuleVersion)
+		}
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		if _, err := LoadRuleSet("non-existent.json"); err == nil {
+			t.Error("expected has error, but got nothing")
+		}
+	})
+}
+
+func TestParseRuleSet(t *testing.T) {
+	receipt := &Receipt{
+		Retailer:     "Target",
+		PurchaseTime: time.Date(2022, time.January, 1, 11, 30, 0, 0, time.UTC),
+		Items:        []ReceiptItem{{"abc", 1000}, {"abcd", 100}, {"abc", 500}},
+		Total:        1600,
+	}
+
+	tests := map[string]struct {
+		config   string
+		expected int64
+	}{
+		"custom points": {
+			`{"version":"test","rules":[{"type":"roundDollarTotal","points":100}]}`, 100,
+		},
+		"default parameters": {
+			`{"version":"test","rules":[{"type":"roundDollarTotal"},{"type":"oddPurchaseDay"}]}`, 56,
+		},
+		"item group size": {
+			`{"version":"test","rules":[{"type":"itemPairs","pairSize":3,"points":7}]}`, 7,
+		},
+		"description divisor and multiplier": {
+			`{"version":"test","rules":[{"type":"itemDescriptionLength","divisor":4,"multiplier":0.5}]}`, 1,
+		},
+		"time window": {
+			`{"version":"test","rules":[{"type":"afternoonPurchaseTime","name":"lateMorning","after":"11:00","before":"12:00","points":15}]}`, 15,
+		},
+		"same type with different names": {
+			`{"version":"test","rules":[{"type":"oddPurchaseDay"},{"type":"oddPurchaseDay","name":"oddDayBonus","points":1}]}`, 7,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			rules, err := ParseRuleSet(strings.NewReader(test.config))
+			if err != nil {
+				t.Fatalf("expected no error, but got %v", err)
+			}
+			if got, want := rules.Evaluate(receipt).Points, test.expected; got != want {
+				t.Errorf("expected points %d, but got %d", want, got)
+			}
+		})
+	}
+}
+
+func TestParseRuleSet_Invalid(t *testing.T) {
+	tests := map[string]string{
+		"malformed JSON":      `{"version":"test","rules":[`,
+		"no rules":            `{"version":"test","rules":[]}`,
+		"unknown top field":   `{"version":"test","rules":[{"type":"oddPurchaseDay"}],"extra":1}`,
+		"missing type":        `{"version":"test","rules":[{"points":1}]}`,
+		"unknown type":        `{"version":"test","rules":[{"type":"birthdayBonus"}]}`,
+		"unknown parameter":   `{"version":"test","rules":[{"type":"oddPurchaseDay","pointz":1}]}`,
+		"wrong type":          `{"version":"test","rules":[{"type":"oddPurchaseDay","points":"six"}]}`,
+		"zero pair size":      `{"version":"test","rules":[{"type":"itemPairs","pairSize":0}]}`,
+		"zero divisor":        `{"version":"test","rules":[{"type":"itemDescriptionLength","divisor":0}]}`,
+		"negative multiplier": `{"version":"test","rules":[{"type":"itemDescriptionLength","multiplier":-1}]}`,
+		"invalid time":        `{"version":"test","rules":[{"type":"afternoonPurchaseTime","after":"2pm"}]}`,
+		"empty time window":   `{"version":"test","rules":[{"type":"afternoonPurchaseTime","after":"16:00","before":"14:00"}]}`,
+		"zero time window":    `{"version":"test","rules":[{"type":"afternoonPurchaseTime","after":"14:00","before":"14:00"}]}`,
+		"negative per char":   `{"version":"test","rules":[{"type":"retailerAlphanumeric","pointsPerCharacter":-1}]}`,
+		"negative round":      `{"version":"test","rules":[{"type":"roundDollarTotal","points":-50}]}`,
+		"negative quarter":    `{"version":"test","rules":[{"type":"quarterMultipleTotal","points":-25}]}`,
+		"negative pairs":      `{"version":"test","rules":[{"type":"itemPairs","points":-5}]}`,
+		"negative odd day":    `{"version":"test","rules":[{"type":"oddPurchaseDay","points":-6}]}`,
+		"negative window":     `{"version":"test","rules":[{"type":"afternoonPurchaseTime","points":-10}]}`,
+		"duplicate name":      `{"version":"test","rules":[{"type":"oddPurchaseDay"},{"type":"oddPurchaseDay"}]}`,
+	}
+
+	for name, config := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			if _, err := ParseRuleSet(strings.NewReader(config)); err == nil {
+				t.Error("expected has error, but got nothing")
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package receipt
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/lzchong/receipt-processor/internal/apperr"
+)
+
+var ErrRuleVersionNotFound error = &apperr.NotFoundError{Resource: "rule set", Key: "version"}
+
+// RuleRegistry holds every known rule set by version. New receipts are scored
+// with the active version; older versions are kept so receipts they scored can
+// still be explained.
+type RuleRegistry struct {
+	lock     sync.RWMutex
+	active   *RuleSet
+	versions map[string]*RuleSet
+}
+
+// NewRuleRegistry registers the active rule set along with any others.
+func NewRuleRegistry(active *RuleSet, others ...*RuleSet) (*RuleRegistry, error) {
+	r := &RuleRegistry{
+		active:   active,
+		versions: map[string]*RuleSet{active.Version(): active},
+	}
+	for _, rules := range others {
+		if _, ok := r.versions[rules.Version()]; ok {
+			return nil, fmt.Errorf("rule version %q is registered more than once", rules.Version())
+		}
+		r.versions[rules.Version()] = rules
+	}
+	return r, nil
+}
+
+func (r *RuleRegistry) Active() *RuleSet {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.active
+}
+
+func (r *RuleRegistry) Get(version string) (*RuleSet, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	rules, ok := r.versions[version]
+	return rules, ok
+}
+
+// Activate makes a registered version the one used to score new receipts.
+func (r *RuleRegistry) Activate(version string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	rules, ok := r.versions[version]
+	if !ok {
+		return ErrRuleVersionNotFound
+	}
+	r.active = rules
+	return nil
+}
+
+// Versions returns the registered versions in sorted order.
+func (r *RuleRegistry) Versions() []string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	versions := make([]string, 0, len(r.versions))
+	for version := range r.versions {
+		versions = append(versions, version)
+	}
+	sort.Strings(versions)
+	return versions
+}
@@ -0,0 +1,50 @@
+package receipt
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestRuleRegistry(t *testing.T) {
+	v1 := NewVersionedRuleSet("v1")
+	v2 := NewVersionedRuleSet("v2")
+
+	t.Run("active and get", func(t *testing.T) {
+		registry, err := NewRuleRegistry(v1, v2)
+		if err != nil {
+			t.Fatalf("expected no error, but got %v", err)
+		}
+		if got := registry.Active(); got != v1 {
+			t.Errorf("expected active version v1, but got %s", got.Version())
+		}
+		if got, ok := registry.Get("v2"); !ok || got != v2 {
+			t.Errorf("expected version v2, but got %v", got)
+		}
+		if _, ok := registry.Get("v3"); ok {
+			t.Error("expected no version v3, but got one")
+		}
+		if got, want := registry.Versions(), []string{"v1", "v2"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("expected versions %v, but got %v", want, got)
+		}
+	})
+
+	t.Run("activate", func(t *testing.T) {
+		registry, _ := NewRuleRegistry(v1, v2)
+		if err := registry.Activate("v2"); err != nil {
+			t.Fatalf("expected no error, but got %v", err)
+		}
+		if got := registry.Active(); got != v2 {
+			t.Errorf("expected active version v2, but got %s", got.Version())
+		}
+		if err := registry.Activate("v3"); !errors.Is(err, ErrRuleVersionNotFound) {
+			t.Errorf("expected error %v, but got %v", ErrRuleVersionNotFound, err)
+		}
+	})
+
+	t.Run("duplicate version", func(t *testing.T) {
+		if _, err := NewRuleRegistry(v1, NewVersionedRuleSet("v1")); err == nil {
+			t.Error("expected has error, but got nothing")
+		}
+	})
+}
@@ -0,0 +1,84 @@
+package receipt
+
+import (
+	"testing"
+	"time"
+)
+
+type stubRule struct {
+	name   string
+	points int64
+}
+
+func (r stubRule) Name() string {
+	return r.name
+}
+
+func (r stubRule) Evaluate(receipt *Receipt) RulePoints {
+	return RulePoints{Points: r.points}
+}
+
+func TestRuleSet_Evaluate(t *testing.T) {
+	t.Run("sums rules in order", func(t *testing.T) {
+		rules := NewRuleSet(stubRule{"first", 3}, stubRule{"second", 4})
+
+		breakdown := rules.Evaluate(&Receipt{})
+		if got, want := breakdown.Points, int64(7); got != want {
+			t.Errorf("expected points %d, but got %d", want, got)
+		}
+		assertRuleNames(t, breakdown, "first", "second")
+	})
+
+	t.Run("empty rule set", func(t *testing.T) {
+		breakdown := NewRuleSet().Evaluate(&Receipt{})
+		if got := breakdown.Points; got != 0 {
+			t.Errorf("expected points 0, but got %d", got)
+		}
+	})
+
+	t.Run("default rule set", func(t *testing.T) {
+		receipt := &Receipt{
+			Retailer:     "Target",
+			PurchaseTime: time.Date(2022, time.January, 1, 14, 01, 0, 0, time.UTC),
+			Items:        []ReceiptItem{{"Mountain Dew 12PK", 649}},
+			Total:        649,
+		}
+
+		breakdown := DefaultRuleSet().Evaluate(receipt)
+		assertRuleNames(t, breakdown,
+			"retailerAlphanumeric",
+			"roundDollarTotal",
+			"quarterMultipleTotal",
+			"itemPairs",
+			"itemDescriptionLength",
+			"oddPurchaseDay",
+			"afternoonPurchaseTime",
+		)
+		if got, want := breakdown.Points, int64(22); got != want {
+			t.Errorf("expected points %d, but got %d", want, got)
+		}
+	})
+}
+
+func TestRuleSet_Rules(t *testing.T) {
+	rules := NewRuleSet(stubRule{"first", 3}, stubRule{"second", 4})
+
+	edited := rules.Rules()
+	edited[0], edited[1] = edited[1], edited[0]
+	reordered := NewRuleSet(append(edited, stubRule{"third", 5})...)
+
+	assertRuleNames(t, rules.Evaluate(&Receipt{}), "first", "second")
+	assertRuleNames(t, reordered.Evaluate(&Receipt{}), "second", "first", "third")
+}
+
+func assertRuleNames(t *testing.T, breakdown *PointsBreakdown, want ...string) {
+	t.Helper()
+	if got := len(breakdown.Rules); got != len(want) {
+		t.Fatalf("expected %d rules, but got %d", len(want), got)
+	}
+	for i, rule := range breakdown.Rules {
+		if rule.Name != want[i] {
+			t.Errorf("expected rule %d to be %s, but got %s", i, want[i], rule.Name)
+		}
+	}
+}
@@ -1,34 +1,397 @@
 package receipt
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"sync"
+
+	"github.com/lzchong/receipt-processor/internal/apperr"
 )
 
 type Service interface {
-	Points(id string) (int64, error)
-	Process(receipt *Receipt) string
+	Points(ctx context.Context, id string) (int64, error)
+	PointsBreakdown(ctx context.Context, id string) (*PointsBreakdown, error)
+	Receipt(ctx context.Context, id string) (*Receipt, error)
+	Process(ctx context.Context, receipt *Receipt) (string, error)
+	Score(ctx context.Context, receipt *Receipt) (*PointsBreakdown, error)
+	Recalculate(ctx context.Context, version string, commit bool) (*Recalculation, error)
+	FlaggedReceipts(ctx context.Context) ([]FlaggedReceipt, error)
 }
 
 type serviceImpl struct {
 	repository Repository
+	rules      *RuleRegistry
+	duplicates DuplicatePolicy
+	observer   Observer
+
+	// versions keeps the active rule version, if the repository can.
+	versions ActiveVersionStore
+
+	// processLocks make checking for a duplicate and storing the receipt
+	// atomic. Receipts are spread over them by fingerprint, so only receipts
+	// that could be duplicates of each other wait for one another. Each is a
+	// channel so that waiting for it can be given up.
+	processLocks []chan struct{}
+
+	// recalculateLock stops two recalculations from committing at once.
+	recalculateLock sync.Mutex
+
+	// activeLock keeps the active rule version from changing between Process
+	// scoring a receipt with it and storing the receipt. Process holds it for
+	// reading; a committing recalculation holds it for writing while it
+	// activates the version and moves the receipts stored meanwhile.
+	activeLock sync.RWMutex
+}
+
+type ServiceOption func(*serviceImpl)
+
+// WithRuleSet scores receipts with a single rule set, replacing DefaultRuleSet.
+func WithRuleSet(rules *RuleSet) ServiceOption {
+	return func(s *serviceImpl) {
+		s.rules, _ = NewRuleRegistry(rules)
+	}
+}
+
+// WithRuleRegistry scores receipts with the registry's active rule set and
+// allows recalculating against any of its versions.
+func WithRuleRegistry(rules *RuleRegistry) ServiceOption {
+	return func(s *serviceImpl) {
+		s.rules = rules
+	}
+}
+
+// WithDuplicatePolicy sets what happens to a receipt that was already stored.
+// The default is DuplicateFlag.
+func WithDuplicatePolicy(policy DuplicatePolicy) ServiceOption {
+	return func(s *serviceImpl) {
+		s.duplicates = policy
+	}
+}
+
+// WithObserver reports processed receipts, awarded points, and the latency of
+// every repository call to the observer.
+func WithObserver(observer Observer) ServiceOption {
+	return func(s *serviceImpl) {
+		s.observer = observer
+	}
+}
+
+func NewService(repository Repository, options ...ServiceOption) Service {
+	// A registry holding a single rule set cannot fail.
+	rules, _ := NewRuleRegistry(DefaultRuleSet())
+	s := &serviceImpl{
+		repository: repository,
+		rules:      rules,
+		observer:   noopObserver{},
+	}
+	s.processLocks = make([]chan struct{}, processLockStripes)
+	for i := range s.processLocks {
+		s.processLocks[i] = make(chan struct{}, 1)
+	}
+	for _, option := range options {
+		option(s)
+	}
+	s.versions, _ = repository.(ActiveVersionStore)
+	if _, ok := s.observer.(noopObserver); !ok {
+		s.repository = &observedRepository{s.repository, s.observer}
+	}
+	return s
+}
+
+var (
+	ErrReceiptNotFound  error = &apperr.NotFoundError{Resource: "receipt", Key: "ID"}
+	ErrReceiptNotStored error = &apperr.NotFoundError{
+		Resource: "receipt",
+		Key:      "ID",
+		Reason:   "the receipt was submitted before receipts were stored, so only its points are available",
+	}
+	ErrReceiptNotSaved        = errors.New("receipt could not be saved")
+	ErrDuplicateReceipt error = &apperr.DuplicateError{Resource: "receipt"}
+
+	ErrScoringVersionGone error = &apperr.ConflictError{
+		Reason: "the receipt was scored with a rule set version that is no longer loaded, so its points cannot be explained",
+	}
+)
+
+func (s *serviceImpl) Points(ctx context.Context, id string) (int64, error) {
+	return s.repository.Points(ctx, id)
+}
+
+// PointsBreakdown explains a receipt's points with the rule set version that
+// scored it. It fails with ErrScoringVersionGone if that version is not
+// registered, as another version could explain different points.
+func (s *serviceImpl) PointsBreakdown(ctx context.Context, id string) (*PointsBreakdown, error) {
+	record, err := s.repository.Record(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if record.Legacy {
+		return nil, ErrReceiptNotStored
+	}
+	rules, ok := s.rules.Get(record.RuleVersion)
+	if !ok {
+		return nil, fmt.Errorf("rule set version %q, %w", record.RuleVersion, ErrScoringVersionGone)
+	}
+	return rules.Evaluate(&record.Receipt), nil
+}
+
+func (s *serviceImpl) Receipt(ctx context.Context, id string) (*Receipt, error) {
+	return s.repository.Receipt(ctx, id)
+}
+
+// Process scores and stores the receipt. A receipt already stored is handled
+// by the duplicate policy.
+func (s *serviceImpl) Process(ctx context.Context, receipt *Receipt) (string, error) {
+	fingerprint := receipt.Fingerprint()
+	lock := s.processLock(fingerprint)
+	select {
+	case lock <- struct{}{}:
+		defer func() { <-lock }()
+	case <-ctx.Done():
+		s.observer.ReceiptProcessed(OutcomeFailed)
+		return "", fmt.Errorf("%w, %w", ErrReceiptNotSaved, ctx.Err())
+	}
+
+	outcome := OutcomeStored
+	existing, err := s.repository.FindByFingerprint(ctx, fingerprint)
+	switch {
+	case errors.Is(err, ErrReceiptNotFound):
+		// Not a duplicate.
+	case err != nil:
+		s.observer.ReceiptProcessed(OutcomeFailed)
+		return "", fmt.Errorf("%w, %w", ErrReceiptNotSaved, err)
+	default:
+		switch s.duplicates {
+		case DuplicateReject:
+			s.observer.ReceiptProcessed(OutcomeDuplicateRejected)
+			return "", ErrDuplicateReceipt
+		case DuplicateReturnExisting:
+			s.observer.ReceiptProcessed(OutcomeDuplicateReturned)
+			return existing, nil
+		}
+		slog.InfoContext(ctx, "Flagging receipt as a duplicate for review", "duplicate_of", existing)
+		outcome = OutcomeFlagged
+	}
+
+	s.activeLock.RLock()
+	rules := s.rules.Active()
+	breakdown := rules.Evaluate(receipt)
+	id, err := s.repository.CreatePoints(ctx, receipt, breakdown.Points, rules.Version())
+	s.activeLock.RUnlock()
+	if err != nil {
+		s.observer.ReceiptProcessed(OutcomeFailed)
+		return "", fmt.Errorf("%w, %w", ErrReceiptNotSaved, err)
+	}
+	s.observer.ReceiptProcessed(outcome)
+	s.observer.PointsAwarded(breakdown)
+	return id, nil
 }
 
-func NewService(repository Repository) Service {
-	return &serviceImpl{repository}
+// Score explains the points the receipt would be awarded with the active rule
+// set, without storing it.
+func (s *serviceImpl) Score(ctx context.Context, receipt *Receipt) (*PointsBreakdown, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	breakdown := s.rules.Active().Evaluate(receipt)
+	s.observer.ReceiptScored()
+	return breakdown, nil
 }
 
-var ErrReceiptNotFound = errors.New("receipt not found")
+// processLockStripes is how many locks receipts are spread over.
+const processLockStripes = 64
 
-func (s *serviceImpl) Points(id string) (int64, error) {
-	points, ok := s.repository.Points(id)
+// processLock returns the lock of the receipts with the fingerprint.
+func (s *serviceImpl) processLock(fingerprint string) chan struct{} {
+	h := fnv.New32a()
+	h.Write([]byte(fingerprint))
+	return s.processLocks[h.Sum32()%processLockStripes]
+}
+
+// FlaggedReceipt is a stored receipt that duplicates an earlier one.
+type FlaggedReceipt struct {
+	ID          string `json:"id"`
+	DuplicateOf string `json:"duplicateOf"`
+	Fingerprint string `json:"fingerprint"`
+	Points      int64  `json:"points"`
+}
+
+// FlaggedReceipts lists the stored duplicates awaiting review.
+func (s *serviceImpl) FlaggedReceipts(ctx context.Context) ([]FlaggedReceipt, error) {
+	records, err := s.repository.Records(ctx)
+	if err != nil {
+		return nil, err
+	}
+	flagged := []FlaggedReceipt{}
+	for _, record := range records {
+		if record.DuplicateOf != "" {
+			flagged = append(flagged, FlaggedReceipt{
+				ID:          record.ID,
+				DuplicateOf: record.DuplicateOf,
+				Fingerprint: record.Fingerprint,
+				Points:      record.Points,
+			})
+		}
+	}
+	return flagged, nil
+}
+
+// Recalculation reports how stored points differ under another rule set version.
+type Recalculation struct {
+	Version     string         `json:"version"`
+	Committed   bool           `json:"committed"`
+	Receipts    int            `json:"receipts"`
+	Changed     int            `json:"changed"`
+	PointsDelta int64          `json:"pointsDelta"`
+	Changes     []PointsChange `json:"changes"`
+	// Skipped counts the legacy receipts, which keep their points as they
+	// cannot be scored again.
+	Skipped int `json:"skipped"`
+}
+
+type PointsChange struct {
+	ID          string `json:"id"`
+	FromVersion string `json:"fromVersion"`
+	Before      int64  `json:"before"`
+	After       int64  `json:"after"`
+	Delta       int64  `json:"delta"`
+}
+
+// Recalculate scores every stored receipt with the given rule set version and
+// reports the receipts whose points would change. Nothing is stored unless
+// commit is set, in which case every stored receipt is moved to the version
+// and the version is activated for new receipts. The activation is saved if
+// the repository is an ActiveVersionStore. If any receipt cannot be moved, or
+// the activation cannot be saved, the receipts already moved are restored and
+// the version is not activated.
+func (s *serviceImpl) Recalculate(ctx context.Context, version string, commit bool) (*Recalculation, error) {
+	rules, ok := s.rules.Get(version)
 	if !ok {
-		return 0, ErrReceiptNotFound
+		return nil, ErrRuleVersionNotFound
+	}
+
+	if commit {
+		s.recalculateLock.Lock()
+		defer s.recalculateLock.Unlock()
+	}
+
+	records, err := s.repository.Records(ctx)
+	if err != nil {
+		return nil, err
 	}
-	return points, nil
+	report := &Recalculation{Version: version, Committed: commit, Changes: []PointsChange{}}
+	updates := s.rescore(ctx, report, rules, records)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if !commit {
+		return report, nil
+	}
+
+	// Once receipts are being moved, the caller going away must not stop the
+	// commit halfway.
+	ctx = context.WithoutCancel(ctx)
+	if err := s.update(ctx, version, updates); err != nil {
+		return nil, err
+	}
+
+	// Receipts stored while the others were updated were scored with the
+	// previous version. No more can be stored while the version is activated
+	// and they are moved.
+	s.activeLock.Lock()
+	defer s.activeLock.Unlock()
+	if s.versions != nil {
+		if err := s.versions.SaveActiveVersion(ctx, version); err != nil {
+			err = fmt.Errorf("failed to save active rule version, %w", err)
+			return nil, errors.Join(err, s.restore(ctx, updates))
+		}
+	}
+	if err := s.rules.Activate(version); err != nil {
+		return nil, err
+	}
+	slog.InfoContext(ctx, "Activated rule version", "version", version)
+
+	records, err = s.repository.Records(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to move receipts stored during the recalculation, %w", err)
+	}
+	stragglers := records[:0]
+	for _, record := range records {
+		if record.RuleVersion != version && !record.Legacy {
+			stragglers = append(stragglers, record)
+		}
+	}
+	if err := s.update(ctx, version, s.rescore(ctx, report, rules, stragglers)); err != nil {
+		return nil, fmt.Errorf("failed to move receipts stored during the recalculation, %w", err)
+	}
+	return report, nil
 }
 
-func (s *serviceImpl) Process(receipt *Receipt) string {
-	points := receipt.CalculatePoints()
-	id := s.repository.CreatePoints(points)
-	return id
+// pointsUpdate is a record to be moved to new points.
+type pointsUpdate struct {
+	record Record
+	points int64
+}
+
+// rescore adds the records whose points change under the rules to the report,
+// and returns every record that is not yet on the rules' version. It stops
+// early if ctx is done, as scoring every receipt can take a while.
+func (s *serviceImpl) rescore(ctx context.Context, report *Recalculation, rules *RuleSet, records []Record) []pointsUpdate {
+	var updates []pointsUpdate
+	for _, record := range records {
+		if ctx.Err() != nil {
+			return nil
+		}
+		if record.Legacy {
+			report.Skipped++
+			continue
+		}
+		report.Receipts++
+		points := rules.Evaluate(&record.Receipt).Points
+
+		if points != record.Points {
+			report.Changed++
+			report.PointsDelta += points - record.Points
+			report.Changes = append(report.Changes, PointsChange{
+				ID:          record.ID,
+				FromVersion: record.RuleVersion,
+				Before:      record.Points,
+				After:       points,
+				Delta:       points - record.Points,
+			})
+		}
+		if points != record.Points || record.RuleVersion != rules.Version() {
+			updates = append(updates, pointsUpdate{record, points})
+		}
+	}
+	return updates
+}
+
+// update moves every record to its new points. If one cannot be moved, the
+// records already moved are restored, so either all are moved or none are.
+func (s *serviceImpl) update(ctx context.Context, version string, updates []pointsUpdate) error {
+	for i, update := range updates {
+		err := s.repository.UpdatePoints(ctx, update.record.ID, update.points, version)
+		if err == nil {
+			continue
+		}
+		err = fmt.Errorf("failed to update points for receipt %s, %w", update.record.ID, err)
+		return errors.Join(err, s.restore(ctx, updates[:i]))
+	}
+	return nil
+}
+
+// restore moves the records back to the points and version they had before
+// the updates.
+func (s *serviceImpl) restore(ctx context.Context, updates []pointsUpdate) error {
+	var errs []error
+	for _, done := range updates {
+		if err := s.repository.UpdatePoints(ctx, done.record.ID, done.record.Points, done.record.RuleVersion); err != nil {
+			errs = append(errs, fmt.Errorf("failed to restore points for receipt %s, %w", done.record.ID, err))
+		}
+	}
+	return errors.Join(errs...)
 }
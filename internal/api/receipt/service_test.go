@@ -1,26 +1,73 @@
 package receipt
 
 import (
+	"context"
 	"errors"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/lzchong/receipt-processor/internal/apperr"
 )
 
 type stubRepository struct{}
 
-func (m *stubRepository) Points(id string) (int64, bool) {
+func (m *stubRepository) Points(ctx context.Context, id string) (int64, error) {
 	switch id {
 	case "7fb1377b-b223-49d9-a31a-5a02701dd310":
-		return 32, true
+		return 32, nil
 	case "adb6b560-0eef-42bc-9d16-df48f30e89b2":
-		return 0, true
+		return 0, nil
 	default:
-		return 0, false
+		return 0, ErrReceiptNotFound
 	}
 }
 
-func (m *stubRepository) CreatePoints(points int64) string {
-	return "7fb1377b-b223-49d9-a31a-5a02701dd310"
+func (m *stubRepository) Receipt(ctx context.Context, id string) (*Receipt, error) {
+	if id == "7fb1377b-b223-49d9-a31a-5a02701dd310" {
+		return &Receipt{Retailer: "Target"}, nil
+	}
+	return nil, ErrReceiptNotFound
+}
+
+func (m *stubRepository) Record(ctx context.Context, id string) (*Record, error) {
+	receipt, err := m.Receipt(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return &Record{ID: id, Receipt: *receipt, Points: 6, RuleVersion: DefaultRuleVersion}, nil
+}
+
+func (m *stubRepository) Records(ctx context.Context) ([]Record, error) {
+	return nil, nil
+}
+
+func (m *stubRepository) Count(ctx context.Context) (int, error) {
+	return 1, nil
+}
+
+func (m *stubRepository) FindByFingerprint(ctx context.Context, fingerprint string) (string, error) {
+	return "", ErrReceiptNotFound
+}
+
+func (m *stubRepository) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (m *stubRepository) Close() error {
+	return nil
+}
+
+func (m *stubRepository) UpdatePoints(ctx context.Context, id string, points int64, ruleVersion string) error {
+	return ErrReceiptNotFound
+}
+
+func (m *stubRepository) CreatePoints(ctx context.Context, receipt *Receipt, points int64, ruleVersion string) (string, error) {
+	return "7fb1377b-b223-49d9-a31a-5a02701dd310", nil
 }
 
 func TestReceiptService_Points(t *testing.T) {
@@ -41,7 +88,7 @@ func TestReceiptService_Points(t *testing.T) {
 	for name, test := range tests {
 		t.Run(name, func(t *testing.T) {
 			t.Parallel()
-			got, err := service.Points(test.input)
+			got, err := service.Points(context.Background(), test.input)
 			if !errors.Is(err, test.expectedErr) {
 				t.Errorf("expected error %v, but got %v", test.expectedErr, err)
 			}
@@ -53,6 +100,53 @@ func TestReceiptService_Points(t *testing.T) {
 	}
 }
 
+func TestReceiptService_PointsBreakdown(t *testing.T) {
+	repository := &stubRepository{}
+	service := NewService(repository)
+
+	t.Run("success", func(t *testing.T) {
+		breakdown, err := service.PointsBreakdown(context.Background(), "7fb1377b-b223-49d9-a31a-5a02701dd310")
+		if err != nil {
+			t.Fatalf("expected no error, but got %v", err)
+		}
+		if got, want := breakdown.Rules[0].Points, int64(6); got != want {
+			t.Errorf("expected retailer points %d, but got %d", want, got)
+		}
+	})
+
+	t.Run("missing ID", func(t *testing.T) {
+		_, err := service.PointsBreakdown(context.Background(), "6fb1377b-b223-49d9-a31a-5a02701dd310")
+		if !errors.Is(err, ErrReceiptNotFound) {
+			t.Errorf("expected error %v, but got %v", ErrReceiptNotFound, err)
+		}
+	})
+}
+
+func TestReceiptService_Receipt(t *testing.T) {
+	repository := &stubRepository{}
+	service := NewService(repository)
+
+	t.Run("success", func(t *testing.T) {
+		receipt, err := service.Receipt(context.Background(), "7fb1377b-b223-49d9-a31a-5a02701dd310")
+		if err != nil {
+			t.Fatalf("expected no error, but got %v", err)
+		}
+		if got, want := receipt.Retailer, "Target"; got != want {
+			t.Errorf("expected retailer %s, but got %s", want, got)
+		}
+	})
+
+	t.Run("missing ID", func(t *testing.T) {
+		receipt, err := service.Receipt(context.Background(), "6fb1377b-b223-49d9-a31a-5a02701dd310")
+		if !errors.Is(err, ErrReceiptNotFound) {
+			t.Errorf("expected error %v, but got %v", ErrReceiptNotFound, err)
+		}
+		if receipt != nil {
+			t.Errorf("expected no receipt, but got %v", receipt)
+		}
+	})
+}
+
 func TestReceiptService_Process(t *testing.T) {
 	mockRepo := &stubRepository{}
 	service := NewService(mockRepo)
@@ -65,8 +159,650 @@ func TestReceiptService_Process(t *testing.T) {
 			Items:        receiptItems,
 			Total:        0,
 		}
-		if got, want := service.Process(receipt), "7fb1377b-b223-49d9-a31a-5a02701dd310"; got != want {
+		got, err := service.Process(context.Background(), receipt)
+		if err != nil {
+			t.Fatalf("expected no error, but got %v", err)
+		}
+		if want := "7fb1377b-b223-49d9-a31a-5a02701dd310"; got != want {
 			t.Errorf("expected ID %v, but got %v", want, got)
 		}
 	})
 }
+
+func TestReceiptService_WithRuleSet(t *testing.T) {
+	repository := &capturingRepository{}
+	service := NewService(repository, WithRuleSet(NewRuleSet(stubRule{"flat", 42})))
+
+	service.Process(context.Background(), &Receipt{Retailer: "Target"})
+	if got, want := repository.points, int64(42); got != want {
+		t.Errorf("expected points %d, but got %d", want, got)
+	}
+}
+
+func TestReceiptService_Score(t *testing.T) {
+	repository := NewRepository()
+	observer := &recordingObserver{}
+	service := NewService(repository, WithObserver(observer))
+
+	got, err := service.Score(context.Background(), testReceipt())
+	if err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+	if want := DefaultRuleSet().Evaluate(testReceipt()); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected breakdown %+v, but got %+v", want, got)
+	}
+	if count, _ := repository.Count(context.Background()); count != 0 {
+		t.Errorf("expected no stored receipts, but got %d", count)
+	}
+	if observer.scored != 1 || len(observer.outcomes) != 0 || len(observer.operations) != 0 {
+		t.Errorf("expected one score and nothing processed, but got %d scores, outcomes %v, and operations %v", observer.scored, observer.outcomes, observer.operations)
+	}
+
+	t.Run("storage is down", func(t *testing.T) {
+		service := NewService(&failingRepository{})
+		if _, err := service.Score(context.Background(), testReceipt()); err != nil {
+			t.Errorf("expected no error, but got %v", err)
+		}
+	})
+}
+
+type capturingRepository struct {
+	stubRepository
+	points int64
+}
+
+func (m *capturingRepository) CreatePoints(ctx context.Context, receipt *Receipt, points int64, ruleVersion string) (string, error) {
+	m.points = points
+	return "7fb1377b-b223-49d9-a31a-5a02701dd310", nil
+}
+
+func TestReceiptService_Recalculate(t *testing.T) {
+	double := NewVersionedRuleSet("double", retailerAlphanumericRule{pointsPerCharacter: 2})
+	single := NewVersionedRuleSet("single", retailerAlphanumericRule{pointsPerCharacter: 1})
+
+	newService := func(t *testing.T) (Service, Repository, []string) {
+		t.Helper()
+		registry, err := NewRuleRegistry(single, double)
+		if err != nil {
+			t.Fatal(err)
+		}
+		repository := NewRepository()
+		service := NewService(repository, WithRuleRegistry(registry))
+		ids := []string{
+			mustProcess(t, service, &Receipt{Retailer: "Target"}),
+			mustProcess(t, service, &Receipt{Retailer: "Walgreens"}),
+		}
+		return service, repository, ids
+	}
+
+	t.Run("preview", func(t *testing.T) {
+		service, repository, ids := newService(t)
+
+		report, err := service.Recalculate(context.Background(), "double", false)
+		if err != nil {
+			t.Fatalf("expected no error, but got %v", err)
+		}
+		if report.Receipts != 2 || report.Changed != 2 || report.PointsDelta != 15 || report.Committed {
+			t.Errorf("unexpected report %+v", report)
+		}
+		if got, _ := repository.Points(context.Background(), ids[0]); got != 6 {
+			t.Errorf("expected points to be unchanged at 6, but got %d", got)
+		}
+	})
+
+	t.Run("commit", func(t *testing.T) {
+		service, repository, ids := newService(t)
+
+		report, err := service.Recalculate(context.Background(), "double", true)
+		if err != nil {
+			t.Fatalf("expected no error, but got %v", err)
+		}
+		if !report.Committed {
+			t.Error("expected report to be committed")
+		}
+		record, _ := repository.Record(context.Background(), ids[0])
+		if record.Points != 12 || record.RuleVersion != "double" {
+			t.Errorf("expected points 12 with version double, but got %d with version %s", record.Points, record.RuleVersion)
+		}
+
+		id := mustProcess(t, service, &Receipt{Retailer: "Target"})
+		if got, _ := repository.Points(context.Background(), id); got != 12 {
+			t.Errorf("expected new receipt scored with double, but got %d points", got)
+		}
+	})
+
+	t.Run("commit rolls back when an update fails", func(t *testing.T) {
+		registry, err := NewRuleRegistry(single, double)
+		if err != nil {
+			t.Fatal(err)
+		}
+		repository := &flakyRepository{Repository: NewRepository()}
+		service := NewService(repository, WithRuleRegistry(registry))
+		ids := []string{
+			mustProcess(t, service, &Receipt{Retailer: "Target"}),
+			mustProcess(t, service, &Receipt{Retailer: "Walgreens"}),
+		}
+		// Records are updated in ID order, so the first is moved before the
+		// second fails.
+		sort.Strings(ids)
+		repository.failID = ids[1]
+
+		if _, err := service.Recalculate(context.Background(), "double", true); !errors.Is(err, errStorageDown) {
+			t.Fatalf("expected error %v, but got %v", errStorageDown, err)
+		}
+		for _, id := range ids {
+			record, _ := repository.Record(context.Background(), id)
+			if record.RuleVersion != "single" {
+				t.Errorf("expected receipt %s to stay on version single, but got %s", id, record.RuleVersion)
+			}
+		}
+		if got := registry.Active().Version(); got != "single" {
+			t.Errorf("expected version single to stay active, but got %s", got)
+		}
+	})
+
+	t.Run("commit moves receipts stored meanwhile", func(t *testing.T) {
+		registry, err := NewRuleRegistry(single, double)
+		if err != nil {
+			t.Fatal(err)
+		}
+		repository := &interleavedRepository{Repository: NewRepository()}
+		service := NewService(repository, WithRuleRegistry(registry))
+		mustProcess(t, service, &Receipt{Retailer: "Target"})
+		mustProcess(t, service, &Receipt{Retailer: "Walgreens"})
+
+		report, err := service.Recalculate(context.Background(), "double", true)
+		if err != nil {
+			t.Fatalf("expected no error, but got %v", err)
+		}
+		records, _ := repository.Records(context.Background())
+		for _, record := range records {
+			if record.RuleVersion != "double" {
+				t.Errorf("expected receipt %s on version double, but got %s", record.ID, record.RuleVersion)
+			}
+		}
+		if report.Receipts != 3 || report.Changed != 3 {
+			t.Errorf("expected 3 changed receipts, but got %+v", report)
+		}
+	})
+
+	t.Run("breakdown uses scoring version", func(t *testing.T) {
+		service, _, ids := newService(t)
+
+		breakdown, err := service.PointsBreakdown(context.Background(), ids[0])
+		if err != nil {
+			t.Fatalf("expected no error, but got %v", err)
+		}
+		if breakdown.RuleVersion != "single" || breakdown.Points != 6 {
+			t.Errorf("expected 6 points with version single, but got %d with version %s", breakdown.Points, breakdown.RuleVersion)
+		}
+	})
+
+	t.Run("breakdown with unloaded scoring version", func(t *testing.T) {
+		service, repository, _ := newService(t)
+		id, err := repository.CreatePoints(context.Background(), &Receipt{Retailer: "Costco"}, 6, "retired")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = service.PointsBreakdown(context.Background(), id)
+		if !errors.Is(err, ErrScoringVersionGone) {
+			t.Errorf("expected error %v, but got %v", ErrScoringVersionGone, err)
+		}
+	})
+
+	t.Run("unknown version", func(t *testing.T) {
+		service, _, _ := newService(t)
+
+		_, err := service.Recalculate(context.Background(), "missing", false)
+		if !errors.Is(err, ErrRuleVersionNotFound) {
+			t.Errorf("expected error %v, but got %v", ErrRuleVersionNotFound, err)
+		}
+	})
+}
+
+// flakyRepository fails to update one receipt.
+type flakyRepository struct {
+	Repository
+	failID string
+}
+
+func (m *flakyRepository) UpdatePoints(ctx context.Context, id string, points int64, ruleVersion string) error {
+	if id == m.failID {
+		return errStorageDown
+	}
+	return m.Repository.UpdatePoints(ctx, id, points, ruleVersion)
+}
+
+// interleavedRepository stores another receipt with the version single during
+// the first update, as a concurrent Process would.
+type interleavedRepository struct {
+	Repository
+	once sync.Once
+}
+
+func (m *interleavedRepository) UpdatePoints(ctx context.Context, id string, points int64, ruleVersion string) error {
+	m.once.Do(func() {
+		m.Repository.CreatePoints(ctx, &Receipt{Retailer: "Costco"}, 6, "single")
+	})
+	return m.Repository.UpdatePoints(ctx, id, points, ruleVersion)
+}
+
+// stallingRepository holds the first receipt stored until released, and
+// reports when a recalculation reads the records a second time to move the
+// receipts stored meanwhile.
+type stallingRepository struct {
+	Repository
+	stalled  atomic.Bool
+	entered  chan struct{}
+	release  chan struct{}
+	reads    atomic.Int32
+	sweeping chan struct{}
+}
+
+func (m *stallingRepository) CreatePoints(ctx context.Context, receipt *Receipt, points int64, ruleVersion string) (string, error) {
+	if m.stalled.CompareAndSwap(false, true) {
+		close(m.entered)
+		<-m.release
+	}
+	return m.Repository.CreatePoints(ctx, receipt, points, ruleVersion)
+}
+
+func (m *stallingRepository) Records(ctx context.Context) ([]Record, error) {
+	if m.reads.Add(1) == 2 {
+		close(m.sweeping)
+	}
+	return m.Repository.Records(ctx)
+}
+
+func TestReceiptService_Recalculate_ConcurrentProcess(t *testing.T) {
+	registry, err := NewRuleRegistry(
+		NewVersionedRuleSet("single", retailerAlphanumericRule{pointsPerCharacter: 1}),
+		NewVersionedRuleSet("double", retailerAlphanumericRule{pointsPerCharacter: 2}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repository := &stallingRepository{
+		Repository: NewRepository(),
+		entered:    make(chan struct{}),
+		release:    make(chan struct{}),
+		sweeping:   make(chan struct{}),
+	}
+	service := NewService(repository, WithRuleRegistry(registry))
+
+	// The receipt is scored with single, then stalls before it is stored.
+	processed := make(chan string, 1)
+	go func() {
+		id, err := service.Process(context.Background(), &Receipt{Retailer: "Target"})
+		if err != nil {
+			t.Errorf("expected no error, but got %v", err)
+		}
+		processed <- id
+	}()
+	<-repository.entered
+
+	recalculated := make(chan error, 1)
+	go func() {
+		_, err := service.Recalculate(context.Background(), "double", true)
+		recalculated <- err
+	}()
+
+	// The receipt is stored once the recalculation has read the records to
+	// move, or has had the time to.
+	select {
+	case <-repository.sweeping:
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(repository.release)
+
+	id := <-processed
+	if err := <-recalculated; err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+	record, _ := repository.Record(context.Background(), id)
+	if record.Points != 12 || record.RuleVersion != "double" {
+		t.Errorf("expected points 12 with version double, but got %d with version %s", record.Points, record.RuleVersion)
+	}
+}
+
+// unsavedVersionRepository cannot save the active rule version.
+type unsavedVersionRepository struct {
+	Repository
+}
+
+func (m *unsavedVersionRepository) SaveActiveVersion(ctx context.Context, version string) error {
+	return errStorageDown
+}
+
+func (m *unsavedVersionRepository) ActiveVersion() string {
+	return ""
+}
+
+func TestReceiptService_Recalculate_SavesActiveVersion(t *testing.T) {
+	newRegistry := func(t *testing.T) *RuleRegistry {
+		t.Helper()
+		registry, err := NewRuleRegistry(
+			NewVersionedRuleSet("single", retailerAlphanumericRule{pointsPerCharacter: 1}),
+			NewVersionedRuleSet("double", retailerAlphanumericRule{pointsPerCharacter: 2}),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return registry
+	}
+
+	t.Run("saved", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "receipts.wal")
+		repository := openFileRepository(t, path)
+		service := NewService(repository, WithRuleRegistry(newRegistry(t)))
+		mustProcess(t, service, &Receipt{Retailer: "Target"})
+
+		if _, err := service.Recalculate(context.Background(), "double", true); err != nil {
+			t.Fatalf("expected no error, but got %v", err)
+		}
+		repository.Close()
+
+		if got := openFileRepository(t, path).ActiveVersion(); got != "double" {
+			t.Errorf("expected saved version double, but got %q", got)
+		}
+	})
+
+	t.Run("not saved", func(t *testing.T) {
+		registry := newRegistry(t)
+		repository := &unsavedVersionRepository{NewRepository()}
+		service := NewService(repository, WithRuleRegistry(registry))
+		id := mustProcess(t, service, &Receipt{Retailer: "Target"})
+
+		if _, err := service.Recalculate(context.Background(), "double", true); !errors.Is(err, errStorageDown) {
+			t.Fatalf("expected error %v, but got %v", errStorageDown, err)
+		}
+		record, _ := repository.Record(context.Background(), id)
+		if record.Points != 6 || record.RuleVersion != "single" {
+			t.Errorf("expected points 6 with version single, but got %d with version %s", record.Points, record.RuleVersion)
+		}
+		if got := registry.Active().Version(); got != "single" {
+			t.Errorf("expected version single to stay active, but got %s", got)
+		}
+	})
+}
+
+func TestReceiptService_LegacyRecords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "receipts.wal")
+	appendToFile(t, path, `{"op":"create","id":"legacy","points":7,"ruleVersion":"single"}`+"\n")
+	repository := openFileRepository(t, path)
+
+	registry, err := NewRuleRegistry(
+		NewVersionedRuleSet("single", retailerAlphanumericRule{pointsPerCharacter: 1}),
+		NewVersionedRuleSet("double", retailerAlphanumericRule{pointsPerCharacter: 2}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := NewService(repository, WithRuleRegistry(registry))
+	id := mustProcess(t, service, &Receipt{Retailer: "Target"})
+
+	if _, err := service.PointsBreakdown(context.Background(), "legacy"); !errors.Is(err, ErrReceiptNotStored) {
+		t.Errorf("expected error %v, but got %v", ErrReceiptNotStored, err)
+	}
+
+	report, err := service.Recalculate(context.Background(), "double", true)
+	if err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+	if report.Receipts != 1 || report.Skipped != 1 || report.Changed != 1 {
+		t.Errorf("expected one receipt changed and one skipped, but got %+v", report)
+	}
+	record, _ := repository.Record(context.Background(), "legacy")
+	if record.Points != 7 || record.RuleVersion != "single" {
+		t.Errorf("expected legacy points 7 with version single, but got %d with version %s", record.Points, record.RuleVersion)
+	}
+	if got, _ := repository.Points(context.Background(), id); got != 12 {
+		t.Errorf("expected stored receipt moved to 12 points, but got %d", got)
+	}
+}
+
+func TestReceiptService_Process_Duplicates(t *testing.T) {
+	receipt := func() *Receipt {
+		return &Receipt{
+			Retailer:     "Target",
+			PurchaseTime: time.Date(2022, time.January, 1, 13, 1, 0, 0, time.UTC),
+			Items:        []ReceiptItem{{"Mountain Dew 12PK", 649}, {"Emils Cheese Pizza", 1225}},
+			Total:        1874,
+		}
+	}
+	resubmitted := func() *Receipt {
+		r := receipt()
+		r.Retailer = "  TARGET "
+		r.Items[0], r.Items[1] = r.Items[1], r.Items[0]
+		return r
+	}
+
+	t.Run("flag", func(t *testing.T) {
+		service := NewService(NewRepository(), WithDuplicatePolicy(DuplicateFlag))
+		first := mustProcess(t, service, receipt())
+		second := mustProcess(t, service, resubmitted())
+
+		if first == second {
+			t.Fatalf("expected a new ID, but got %s twice", first)
+		}
+		flagged, err := service.FlaggedReceipts(context.Background())
+		if err != nil {
+			t.Fatalf("expected no error, but got %v", err)
+		}
+		if len(flagged) != 1 || flagged[0].ID != second || flagged[0].DuplicateOf != first {
+			t.Errorf("expected %s flagged as a duplicate of %s, but got %+v", second, first, flagged)
+		}
+	})
+
+	t.Run("reject", func(t *testing.T) {
+		service := NewService(NewRepository(), WithDuplicatePolicy(DuplicateReject))
+		mustProcess(t, service, receipt())
+
+		_, err := service.Process(context.Background(), resubmitted())
+		if !errors.Is(err, ErrDuplicateReceipt) {
+			t.Errorf("expected error %v, but got %v", ErrDuplicateReceipt, err)
+		}
+	})
+
+	t.Run("return existing", func(t *testing.T) {
+		repository := NewRepository()
+		service := NewService(repository, WithDuplicatePolicy(DuplicateReturnExisting))
+		first := mustProcess(t, service, receipt())
+		second := mustProcess(t, service, resubmitted())
+
+		if first != second {
+			t.Errorf("expected existing ID %s, but got %s", first, second)
+		}
+		if got, _ := repository.Count(context.Background()); got != 1 {
+			t.Errorf("expected 1 stored receipt, but got %d", got)
+		}
+	})
+
+	t.Run("different receipt", func(t *testing.T) {
+		service := NewService(NewRepository(), WithDuplicatePolicy(DuplicateReject))
+		mustProcess(t, service, receipt())
+
+		other := receipt()
+		other.Total = 1875
+		mustProcess(t, service, other)
+	})
+}
+
+// failingRepository fails every call as if the storage were down.
+type failingRepository struct {
+	stubRepository
+}
+
+var errStorageDown error = &apperr.StorageUnavailableError{Err: errors.New("disk is full")}
+
+func (m *failingRepository) Points(ctx context.Context, id string) (int64, error) {
+	return 0, errStorageDown
+}
+
+func (m *failingRepository) Records(ctx context.Context) ([]Record, error) {
+	return nil, errStorageDown
+}
+
+func (m *failingRepository) FindByFingerprint(ctx context.Context, fingerprint string) (string, error) {
+	return "", errStorageDown
+}
+
+func (m *failingRepository) CreatePoints(ctx context.Context, receipt *Receipt, points int64, ruleVersion string) (string, error) {
+	return "", errStorageDown
+}
+
+func TestReceiptService_StorageErrors(t *testing.T) {
+	service := NewService(&failingRepository{})
+	ctx := context.Background()
+
+	tests := map[string]struct {
+		call func() error
+		want []error
+	}{
+		"points": {
+			call: func() error { _, err := service.Points(ctx, "7fb1377b-b223-49d9-a31a-5a02701dd310"); return err },
+			want: []error{errStorageDown},
+		},
+		"process": {
+			call: func() error { _, err := service.Process(ctx, testReceipt()); return err },
+			want: []error{ErrReceiptNotSaved, errStorageDown},
+		},
+		"flagged receipts": {
+			call: func() error { _, err := service.FlaggedReceipts(ctx); return err },
+			want: []error{errStorageDown},
+		},
+		"recalculate": {
+			call: func() error { _, err := service.Recalculate(ctx, DefaultRuleVersion, false); return err },
+			want: []error{errStorageDown},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			err := test.call()
+			for _, want := range test.want {
+				if !errors.Is(err, want) {
+					t.Errorf("expected error %v, but got %v", want, err)
+				}
+			}
+		})
+	}
+}
+
+func TestReceiptService_Recalculate_Canceled(t *testing.T) {
+	service := NewService(NewRepository())
+	mustProcess(t, service, testReceipt())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := service.Recalculate(ctx, DefaultRuleVersion, true); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected error %v, but got %v", context.Canceled, err)
+	}
+}
+
+func TestReceiptService_Recalculate_CanceledDuringCommit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	registry, err := NewRuleRegistry(
+		NewVersionedRuleSet("single", retailerAlphanumericRule{pointsPerCharacter: 1}),
+		NewVersionedRuleSet("double", retailerAlphanumericRule{pointsPerCharacter: 2}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repository := &cancelingRepository{Repository: NewRepository(), cancel: cancel}
+	service := NewService(repository, WithRuleRegistry(registry))
+	mustProcess(t, service, &Receipt{Retailer: "Target"})
+	mustProcess(t, service, &Receipt{Retailer: "Walgreens"})
+
+	if _, err := service.Recalculate(ctx, "double", true); err != nil {
+		t.Fatalf("expected the commit to finish, but got %v", err)
+	}
+	if repository.updates != 2 {
+		t.Errorf("expected 2 updates, but got %d", repository.updates)
+	}
+}
+
+// cancelingRepository cancels the caller's context on the first update, and
+// fails updates made with a done context.
+type cancelingRepository struct {
+	Repository
+	cancel  context.CancelFunc
+	updates int
+}
+
+func (m *cancelingRepository) UpdatePoints(ctx context.Context, id string, points int64, ruleVersion string) error {
+	m.cancel()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.updates++
+	return m.Repository.UpdatePoints(ctx, id, points, ruleVersion)
+}
+
+func TestReceiptService_Process_CanceledWhileWaiting(t *testing.T) {
+	repository := &blockingRepository{Repository: NewRepository(), release: make(chan struct{}), entered: make(chan struct{})}
+	service := NewService(repository)
+
+	done := make(chan error)
+	go func() {
+		_, err := service.Process(context.Background(), testReceipt())
+		done <- err
+	}()
+	<-repository.entered
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := service.Process(ctx, testReceipt()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected error %v, but got %v", context.DeadlineExceeded, err)
+	}
+
+	close(repository.release)
+	if err := <-done; err != nil {
+		t.Errorf("expected the first receipt to be processed, but got %v", err)
+	}
+}
+
+func TestReceiptService_Process_DifferentReceiptsDoNotWait(t *testing.T) {
+	repository := &blockingRepository{Repository: NewRepository(), release: make(chan struct{}), entered: make(chan struct{})}
+	service := NewService(repository)
+	defer close(repository.release)
+
+	go service.Process(context.Background(), testReceipt())
+	<-repository.entered
+
+	other := testReceipt()
+	other.Retailer = "Walgreens"
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := service.Process(ctx, other); err != nil {
+		t.Errorf("expected a different receipt to be processed meanwhile, but got %v", err)
+	}
+}
+
+// blockingRepository holds the first lookup by fingerprint until released.
+type blockingRepository struct {
+	Repository
+	blocked atomic.Bool
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (m *blockingRepository) FindByFingerprint(ctx context.Context, fingerprint string) (string, error) {
+	if m.blocked.CompareAndSwap(false, true) {
+		close(m.entered)
+		<-m.release
+	}
+	return m.Repository.FindByFingerprint(ctx, fingerprint)
+}
+
+func mustProcess(t *testing.T, service Service, receipt *Receipt) string {
+	t.Helper()
+	id, err := service.Process(context.Background(), receipt)
+	if err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+	return id
+}
@@ -0,0 +1,31 @@
+package receipt
+
+import (
+	"github.com/lzchong/receipt-processor/internal/apperr"
+)
+
+// Codes identifying why a field is invalid.
+const (
+	CodeRequired      = "required"
+	CodeInvalidFormat = "invalid_format"
+	CodeInvalidDate   = "invalid_date"
+	CodeInvalidTime   = "invalid_time"
+	CodeInvalidAmount = "invalid_amount"
+	CodeMinItems      = "min_items"
+	CodeMalformedJSON = "malformed_json"
+	CodeUnknownField  = "unknown_field"
+	CodeInvalidType   = "invalid_type"
+	CodeBodyTooLarge  = "body_too_large"
+	CodeBatchTooLarge = "batch_too_large"
+)
+
+// FieldError describes one invalid field of a request.
+type FieldError = apperr.FieldError
+
+// ValidationError collects every invalid field of a request.
+type ValidationError = apperr.ValidationError
+
+// newValidationError starts collecting the invalid fields of a receipt.
+func newValidationError(fields ...FieldError) *ValidationError {
+	return &ValidationError{Subject: "receipt", Fields: fields}
+}
@@ -0,0 +1,155 @@
+// Package apperr defines the kinds of error the service reports, so that the
+// HTTP layer can turn any of them into a response without knowing where they
+// came from. Each kind is a type, matched with errors.As.
+package apperr
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// NotFoundError is returned when the resource looked up does not exist.
+type NotFoundError struct {
+	// Resource is what was looked up, such as "receipt".
+	Resource string
+	// Key is what it was looked up by, such as "ID".
+	Key string
+	// Reason explains why the resource cannot be given, if it is known to
+	// have existed.
+	Reason string
+}
+
+func (e *NotFoundError) Error() string {
+	if e.Reason != "" {
+		return e.Reason
+	}
+	return e.Resource + " not found"
+}
+
+// FieldError describes one invalid field, located by a JSON pointer into the
+// request body such as /items/2/price.
+type FieldError struct {
+	Pointer string
+	Code    string
+	Message string
+}
+
+func (e FieldError) Error() string {
+	if e.Pointer == "" {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %s", e.Pointer, e.Message)
+}
+
+// ValidationError collects every invalid field of a request.
+type ValidationError struct {
+	// Subject is what was invalid, such as "receipt".
+	Subject string
+	// Reason explains what is wrong with the request as a whole, such as
+	// "the batch is empty". It is optional when Fields say enough.
+	Reason string
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	return describe(e.Reason, e.Fields)
+}
+
+// Add records an invalid field.
+func (e *ValidationError) Add(pointer, code, format string, args ...any) {
+	e.Fields = append(e.Fields, FieldError{pointer, code, fmt.Sprintf(format, args...)})
+}
+
+// Err returns nil when no fields were added, so callers never see a non-nil
+// error interface holding an empty ValidationError.
+func (e *ValidationError) Err() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+// TooLargeError is returned when a request is larger than allowed, such as a
+// body over the size limit or a batch with too many receipts.
+type TooLargeError struct {
+	// Reason explains what is too large, such as "the batch is too large".
+	Reason string
+	Fields []FieldError
+}
+
+func (e *TooLargeError) Error() string {
+	return describe(e.Reason, e.Fields)
+}
+
+// describe joins the reason with the message of every field.
+func describe(reason string, fields []FieldError) string {
+	messages := make([]string, 0, len(fields)+1)
+	if reason != "" {
+		messages = append(messages, reason)
+	}
+	for _, field := range fields {
+		messages = append(messages, field.Error())
+	}
+	return strings.Join(messages, "; ")
+}
+
+// ConflictError is returned when a request cannot be handled while another
+// one is in progress.
+type ConflictError struct {
+	// Reason explains the conflict to the client, such as "a request with
+	// this Idempotency-Key is still being processed".
+	Reason string
+	// InProgress is set if the other request is still being handled, so the
+	// same request may succeed when retried later.
+	InProgress bool
+}
+
+func (e *ConflictError) Error() string {
+	return e.Reason
+}
+
+// MismatchError is returned when a request reuses something, such as an
+// Idempotency-Key, with content other than it was first used with.
+type MismatchError struct {
+	// Reason explains the mismatch to the client, such as "Idempotency-Key was
+	// already used with a different receipt".
+	Reason string
+}
+
+func (e *MismatchError) Error() string {
+	return e.Reason
+}
+
+// DuplicateError is returned when a resource was already submitted.
+type DuplicateError struct {
+	Resource string
+}
+
+func (e *DuplicateError) Error() string {
+	return e.Resource + " was already submitted"
+}
+
+// StorageUnavailableError is returned when the storage cannot be used, such as
+// when its disk fails or it has been closed. Retrying later may succeed.
+type StorageUnavailableError struct {
+	Err error
+}
+
+func (e *StorageUnavailableError) Error() string {
+	return fmt.Sprintf("storage is unavailable, %v", e.Err)
+}
+
+func (e *StorageUnavailableError) Unwrap() error {
+	return e.Err
+}
+
+// RateLimitedError is returned when a client has made too many requests.
+type RateLimitedError struct {
+	// RetryAfter is how long the client should wait before trying again.
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitedError) Error() string {
+	return fmt.Sprintf("too many requests, retry in %v", e.RetryAfter)
+}
@@ -0,0 +1,63 @@
+package apperr
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestValidationError(t *testing.T) {
+	errs := &ValidationError{Subject: "receipt"}
+	if err := errs.Err(); err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+
+	errs.Add("/total", "invalid_amount", "total must have %d decimal places", 2)
+	errs.Add("", "required", "request body is empty")
+	err := errs.Err()
+	if err == nil {
+		t.Fatal("expected has error, but got nothing")
+	}
+	if got, want := err.Error(), "/total: total must have 2 decimal places; request body is empty"; got != want {
+		t.Errorf("expected %q, but got %q", want, got)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	closed := errors.New("repository is closed")
+
+	tests := map[string]struct {
+		err  error
+		want string
+	}{
+		"not found":           {&NotFoundError{Resource: "receipt", Key: "ID"}, "receipt not found"},
+		"not found reason":    {&NotFoundError{Resource: "receipt", Key: "ID", Reason: "receipt was not stored"}, "receipt was not stored"},
+		"validation reason":   {&ValidationError{Reason: "the batch is empty", Fields: []FieldError{{"", "min_items", "minimum of one receipt is required"}}}, "the batch is empty; minimum of one receipt is required"},
+		"too large":           {&TooLargeError{Reason: "the receipt is too large"}, "the receipt is too large"},
+		"conflict":            {&ConflictError{Reason: "request is in progress"}, "request is in progress"},
+		"mismatch":            {&MismatchError{Reason: "key was already used"}, "key was already used"},
+		"duplicate":           {&DuplicateError{Resource: "receipt"}, "receipt was already submitted"},
+		"storage unavailable": {&StorageUnavailableError{Err: closed}, "storage is unavailable, repository is closed"},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			if got := test.err.Error(); got != test.want {
+				t.Errorf("expected %q, but got %q", test.want, got)
+			}
+		})
+	}
+
+	t.Run("unwrap", func(t *testing.T) {
+		t.Parallel()
+		err := fmt.Errorf("could not save, %w", &StorageUnavailableError{Err: closed})
+		if !errors.Is(err, closed) {
+			t.Errorf("expected the cause to be found, but got %v", err)
+		}
+		var unavailable *StorageUnavailableError
+		if !errors.As(err, &unavailable) {
+			t.Errorf("expected a StorageUnavailableError, but got %v", err)
+		}
+	})
+}
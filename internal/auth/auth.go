@@ -0,0 +1,228 @@
+// Package auth authenticates requests by API key. Keys are only ever held as
+// SHA-256 hashes and each grants a set of scopes that routes require.
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"github.com/lzchong/receipt-processor/internal/api/problem"
+)
+
+// Scopes granted to keys.
+const (
+	ScopeReceiptsWrite = "receipts:write"
+	ScopeReceiptsRead  = "receipts:read"
+	ScopeAdmin         = "admin"
+)
+
+var scopes = map[string]bool{
+	ScopeReceiptsWrite: true,
+	ScopeReceiptsRead:  true,
+	ScopeAdmin:         true,
+}
+
+// KeyHeader carries the API key for clients that do not send it as a bearer
+// token.
+const KeyHeader = "X-API-Key"
+
+// Key is an API key known by the hex SHA-256 hash of its value.
+type Key struct {
+	Name   string   `json:"name"`
+	Hash   string   `json:"hash"`
+	Scopes []string `json:"scopes"`
+}
+
+// HashKey returns the hash under which the key is stored.
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// ParseKey parses a key written as name:hash:scopes, where scopes are
+// separated by |, such as ci:9f86d0…:receipts:read|receipts:write.
+func ParseKey(value string) (Key, error) {
+	parts := strings.SplitN(value, ":", 3)
+	if len(parts) != 3 {
+		return Key{}, fmt.Errorf("key %q must be written as name:hash:scopes", value)
+	}
+	key := Key{Name: parts[0], Hash: parts[1], Scopes: strings.Split(parts[2], "|")}
+	if err := key.validate(); err != nil {
+		return Key{}, err
+	}
+	return key, nil
+}
+
+func (k *Key) validate() error {
+	if k.Name == "" {
+		return errors.New("key must have a name")
+	}
+	k.Hash = strings.ToLower(k.Hash)
+	if hash, err := hex.DecodeString(k.Hash); err != nil || len(hash) != sha256.Size {
+		return fmt.Errorf("key %q must have a hash of 64 hexadecimal digits", k.Name)
+	}
+	if len(k.Scopes) == 0 {
+		return fmt.Errorf("key %q must have at least one scope", k.Name)
+	}
+	for _, scope := range k.Scopes {
+		if !scopes[scope] {
+			return fmt.Errorf("key %q has unknown scope %q, must be one of %s, %s, or %s", k.Name, scope, ScopeReceiptsWrite, ScopeReceiptsRead, ScopeAdmin)
+		}
+	}
+	return nil
+}
+
+// keyFile is the format of a key file.
+type keyFile struct {
+	Keys []Key `json:"keys"`
+}
+
+// LoadFile reads the keys of a JSON file such as
+//
+//	{"keys": [{"name": "ci", "hash": "9f86d0…", "scopes": ["receipts:read"]}]}
+func LoadFile(path string) ([]Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file keyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("key file %s: %w", path, err)
+	}
+	for i := range file.Keys {
+		if err := file.Keys[i].validate(); err != nil {
+			return nil, fmt.Errorf("key file %s: %w", path, err)
+		}
+	}
+	return file.Keys, nil
+}
+
+// keySet holds keys by hash, with their scopes as a set.
+type keySet map[string]grant
+
+type grant struct {
+	name   string
+	scopes map[string]bool
+}
+
+func newKeySet(keys []Key) (keySet, error) {
+	set := make(keySet, len(keys))
+	names := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		if names[key.Name] {
+			return nil, fmt.Errorf("key %q is defined twice", key.Name)
+		}
+		names[key.Name] = true
+		if _, ok := set[key.Hash]; ok {
+			return nil, fmt.Errorf("key %q has the same hash as another key", key.Name)
+		}
+		g := grant{name: key.Name, scopes: make(map[string]bool, len(key.Scopes))}
+		for _, scope := range key.Scopes {
+			g.scopes[scope] = true
+		}
+		set[key.Hash] = g
+	}
+	return set, nil
+}
+
+// Keys are the API keys allowed to make requests. Keys from a file can be
+// reloaded while requests are being served.
+type Keys struct {
+	static []Key
+	path   string
+	set    atomic.Pointer[keySet]
+}
+
+type Option func(*Keys)
+
+// WithKeys allows the keys. They are kept across reloads.
+func WithKeys(keys ...Key) Option {
+	return func(k *Keys) {
+		k.static = append(k.static, keys...)
+	}
+}
+
+// WithKeyFile allows the keys of the file, which is read again on Reload.
+func WithKeyFile(path string) Option {
+	return func(k *Keys) {
+		k.path = path
+	}
+}
+
+func New(options ...Option) (*Keys, error) {
+	k := &Keys{}
+	for _, option := range options {
+		option(k)
+	}
+	if err := k.Reload(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Reload reads the key file again. If it cannot be read, or its keys are
+// invalid, the keys in use are kept.
+func (k *Keys) Reload() error {
+	keys := k.static
+	if k.path != "" {
+		loaded, err := LoadFile(k.path)
+		if err != nil {
+			return err
+		}
+		keys = append(keys[:len(keys):len(keys)], loaded...)
+	}
+	set, err := newKeySet(keys)
+	if err != nil {
+		return err
+	}
+	k.set.Store(&set)
+	return nil
+}
+
+// Len returns the number of keys allowed.
+func (k *Keys) Len() int {
+	return len(*k.set.Load())
+}
+
+type contextKey struct{}
+
+// KeyName returns the name of the key that authenticated the request the
+// context belongs to, or "" if there is none.
+func KeyName(ctx context.Context) string {
+	name, _ := ctx.Value(contextKey{}).(string)
+	return name
+}
+
+// Require serves the request only if it carries a known key with the scope.
+// The key goes in an Authorization bearer token or the X-API-Key header.
+func (k *Keys) Require(scope string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := requestKey(r)
+		g, ok := (*k.set.Load())[HashKey(key)]
+		if key == "" || !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="receipt-processor"`)
+			problem.Error(w, r, "A valid API key is required.", http.StatusUnauthorized)
+			return
+		}
+		if !g.scopes[scope] {
+			problem.Error(w, r, fmt.Sprintf("The API key does not have the %s scope.", scope), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, g.name)))
+	})
+}
+
+func requestKey(r *http.Request) string {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	return r.Header.Get(KeyHeader)
+}
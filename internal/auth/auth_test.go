@@ -0,0 +1,160 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var (
+	writerHash = HashKey("writer-secret")
+	readerHash = HashKey("reader-secret")
+)
+
+func TestParseKey(t *testing.T) {
+	key, err := ParseKey("ci:" + writerHash + ":receipts:read|receipts:write")
+	if err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+	if key.Name != "ci" || key.Hash != writerHash || len(key.Scopes) != 2 || key.Scopes[1] != ScopeReceiptsWrite {
+		t.Errorf("unexpected key %+v", key)
+	}
+
+	testCases := map[string]string{
+		"missing scopes": "ci:" + writerHash,
+		"empty name":     ":" + writerHash + ":admin",
+		"short hash":     "ci:abc:admin",
+		"not hex":        "ci:" + writerHash[:63] + "z:admin",
+		"unknown scope":  "ci:" + writerHash + ":receipts:delete",
+	}
+	for name, value := range testCases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			if _, err := ParseKey(value); err == nil {
+				t.Error("expected has error, but got nothing")
+			}
+		})
+	}
+}
+
+func TestRequire(t *testing.T) {
+	keys, err := New(WithKeys(
+		Key{Name: "writer", Hash: writerHash, Scopes: []string{ScopeReceiptsWrite}},
+		Key{Name: "reader", Hash: readerHash, Scopes: []string{ScopeReceiptsRead}},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := keys.Require(ScopeReceiptsWrite, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(KeyName(r.Context())))
+	}))
+
+	testCases := map[string]struct {
+		header string
+		value  string
+		want   int
+		name   string
+	}{
+		"bearer token":   {"Authorization", "Bearer writer-secret", http.StatusOK, "writer"},
+		"key header":     {KeyHeader, "writer-secret", http.StatusOK, "writer"},
+		"missing key":    {"", "", http.StatusUnauthorized, ""},
+		"unknown key":    {KeyHeader, "guess", http.StatusUnauthorized, ""},
+		"hash as key":    {KeyHeader, writerHash, http.StatusUnauthorized, ""},
+		"missing scope":  {"Authorization", "Bearer reader-secret", http.StatusForbidden, ""},
+		"other scheme":   {"Authorization", "Basic writer-secret", http.StatusUnauthorized, ""},
+		"empty bearer":   {"Authorization", "Bearer ", http.StatusUnauthorized, ""},
+		"case of header": {"x-api-key", "writer-secret", http.StatusOK, "writer"},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			request := httptest.NewRequest("POST", "/receipts/process", nil)
+			if tc.header != "" {
+				request.Header.Set(tc.header, tc.value)
+			}
+			response := httptest.NewRecorder()
+			handler.ServeHTTP(response, request)
+
+			if got := response.Code; got != tc.want {
+				t.Errorf("expected status %d, but got %d", tc.want, got)
+			}
+			if tc.want == http.StatusOK && response.Body.String() != tc.name {
+				t.Errorf("expected key name %s, but got %s", tc.name, response.Body.String())
+			}
+			if tc.want == http.StatusUnauthorized && response.Header().Get("WWW-Authenticate") == "" {
+				t.Error("expected WWW-Authenticate header, but got nothing")
+			}
+		})
+	}
+}
+
+func TestKeys_Reload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	writeKeys(t, path, `{"keys": [{"name": "writer", "hash": "`+writerHash+`", "scopes": ["receipts:write"]}]}`)
+
+	keys, err := New(WithKeyFile(path), WithKeys(Key{Name: "reader", Hash: readerHash, Scopes: []string{ScopeReceiptsRead}}))
+	if err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+	handler := keys.Require(ScopeReceiptsWrite, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	assertKeyStatus(t, handler, "writer-secret", http.StatusOK)
+
+	// The writer's key is revoked.
+	writeKeys(t, path, `{"keys": [{"name": "other", "hash": "`+HashKey("other-secret")+`", "scopes": ["receipts:write"]}]}`)
+	if err := keys.Reload(); err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+	assertKeyStatus(t, handler, "writer-secret", http.StatusUnauthorized)
+	assertKeyStatus(t, handler, "other-secret", http.StatusOK)
+	if got := keys.Len(); got != 2 {
+		t.Errorf("expected 2 keys, but got %d", got)
+	}
+
+	// A broken file keeps the keys in use.
+	writeKeys(t, path, `{"keys": [`)
+	if err := keys.Reload(); err == nil {
+		t.Error("expected has error, but got nothing")
+	}
+	assertKeyStatus(t, handler, "other-secret", http.StatusOK)
+}
+
+func TestNew_Invalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	writeKeys(t, path, `{"keys": [{"name": "writer", "hash": "`+writerHash+`", "scopes": []}]}`)
+
+	testCases := map[string][]Option{
+		"missing file":   {WithKeyFile(filepath.Join(t.TempDir(), "missing.json"))},
+		"invalid file":   {WithKeyFile(path)},
+		"duplicate name": {WithKeys(Key{Name: "ci", Hash: writerHash, Scopes: []string{ScopeAdmin}}, Key{Name: "ci", Hash: readerHash, Scopes: []string{ScopeAdmin}})},
+		"duplicate hash": {WithKeys(Key{Name: "a", Hash: writerHash, Scopes: []string{ScopeAdmin}}, Key{Name: "b", Hash: writerHash, Scopes: []string{ScopeAdmin}})},
+	}
+	for name, options := range testCases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			if _, err := New(options...); err == nil {
+				t.Error("expected has error, but got nothing")
+			}
+		})
+	}
+}
+
+func assertKeyStatus(t *testing.T, handler http.Handler, key string, want int) {
+	t.Helper()
+	request := httptest.NewRequest("POST", "/receipts/process", nil)
+	request.Header.Set(KeyHeader, key)
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, request)
+	if got := response.Code; got != want {
+		t.Errorf("expected status %d, but got %d", want, got)
+	}
+}
+
+func writeKeys(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,528 @@
+// Package config loads the settings of the API server. Each setting can come
+// from a command-line flag, an environment variable, or a JSON config file,
+// in that order of precedence, over the built-in defaults.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lzchong/receipt-processor/internal/api/receipt"
+	"github.com/lzchong/receipt-processor/internal/auth"
+	"github.com/lzchong/receipt-processor/internal/logging"
+)
+
+// Storage backends.
+const (
+	StorageMemory = "memory"
+	StorageFile   = "file"
+)
+
+// EnvPrefix starts the name of every environment variable, such as
+// RECEIPT_ADDR for the addr setting.
+const EnvPrefix = "RECEIPT_"
+
+type Config struct {
+	Addr              string
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	ShutdownTimeout   time.Duration
+	ShutdownDelay     time.Duration
+
+	MaxBodySize      int64
+	MaxBatchSize     int
+	MaxBatchBodySize int64
+
+	Storage       string
+	DataFile      string
+	Fsync         receipt.SyncPolicy
+	FsyncInterval time.Duration
+
+	RuleFiles         []string
+	RuleVersion       string
+	Duplicates        receipt.DuplicatePolicy
+	IdempotencyWindow time.Duration
+
+	LogLevel  slog.Level
+	LogFormat string
+
+	APIKeys     []auth.Key
+	APIKeysFile string
+
+	// Rates are in requests per second per client; zero turns the limit off.
+	ReadRate   float64
+	ReadBurst  int
+	WriteRate  float64
+	WriteBurst int
+	ScoreRate  float64
+	ScoreBurst int
+	BatchRate  float64
+	BatchBurst int
+	// The IP rate limits every address before its API key is checked.
+	IPRate  float64
+	IPBurst int
+
+	OpenAPIValidation bool
+}
+
+func Default() *Config {
+	return &Config{
+		Addr:              ":8080",
+		ReadTimeout:       10 * time.Second,
+		ReadHeaderTimeout: 2 * time.Second,
+		WriteTimeout:      10 * time.Second,
+		IdleTimeout:       60 * time.Second,
+		ShutdownTimeout:   30 * time.Second,
+		MaxBodySize:       receipt.DefaultMaxBodySize,
+		MaxBatchSize:      receipt.DefaultMaxBatchSize,
+		MaxBatchBodySize:  receipt.DefaultMaxBatchBodySize,
+		Storage:           StorageMemory,
+		Fsync:             receipt.SyncAlways,
+		FsyncInterval:     100 * time.Millisecond,
+		Duplicates:        receipt.DuplicateFlag,
+		IdempotencyWindow: receipt.DefaultIdempotencyWindow,
+		LogLevel:          slog.LevelInfo,
+		LogFormat:         logging.FormatText,
+		ReadRate:          100,
+		ReadBurst:         200,
+		WriteRate:         20,
+		WriteBurst:        40,
+		ScoreRate:         50,
+		ScoreBurst:        100,
+		BatchRate:         0.2,
+		BatchBurst:        2,
+		IPRate:            200,
+		IPBurst:           400,
+	}
+}
+
+// setting is one configurable value. Its name is the flag name and the key in
+// the config file; the environment variable is derived from it.
+type setting struct {
+	name  string
+	usage string
+	list  bool
+	// boolean settings can be given as a flag without a value.
+	boolean bool
+	set     func(c *Config, value string) error
+}
+
+func (s setting) env() string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(s.name, "-", "_"))
+}
+
+var settings = []setting{
+	{name: "addr", usage: "address to listen on", set: func(c *Config, v string) error {
+		c.Addr = v
+		return nil
+	}},
+	{name: "read-timeout", usage: "maximum duration for reading a request", set: func(c *Config, v string) error {
+		return parseDuration(v, &c.ReadTimeout)
+	}},
+	{name: "read-header-timeout", usage: "maximum duration for reading request headers", set: func(c *Config, v string) error {
+		return parseDuration(v, &c.ReadHeaderTimeout)
+	}},
+	{name: "write-timeout", usage: "maximum duration for writing a response", set: func(c *Config, v string) error {
+		return parseDuration(v, &c.WriteTimeout)
+	}},
+	{name: "idle-timeout", usage: "how long an idle keep-alive connection stays open", set: func(c *Config, v string) error {
+		return parseDuration(v, &c.IdleTimeout)
+	}},
+	{name: "shutdown-timeout", usage: "how long to wait for in-flight requests to finish when shutting down", set: func(c *Config, v string) error {
+		return parseDuration(v, &c.ShutdownTimeout)
+	}},
+	{name: "shutdown-delay", usage: "how long to keep serving with readiness failing before shutting down", set: func(c *Config, v string) error {
+		return parseDuration(v, &c.ShutdownDelay)
+	}},
+	{name: "max-body-size", usage: "maximum size of a receipt request body in bytes", set: func(c *Config, v string) error {
+		return parseInt(v, &c.MaxBodySize)
+	}},
+	{name: "batch-size", usage: "maximum number of receipts in a batch", set: func(c *Config, v string) error {
+		return parseInt(v, &c.MaxBatchSize)
+	}},
+	{name: "batch-body-size", usage: "maximum size of a batch request body in bytes", set: func(c *Config, v string) error {
+		return parseInt(v, &c.MaxBatchBodySize)
+	}},
+	{name: "storage", usage: "storage backend: memory or file", set: func(c *Config, v string) error {
+		if v != StorageMemory && v != StorageFile {
+			return fmt.Errorf("unknown storage backend %q, must be one of memory or file", v)
+		}
+		c.Storage = v
+		return nil
+	}},
+	{name: "data-file", usage: "path to the write-ahead log of the file storage backend", set: func(c *Config, v string) error {
+		c.DataFile = v
+		return nil
+	}},
+	{name: "fsync", usage: "write-ahead log sync policy: always, batch, or never", set: func(c *Config, v string) (err error) {
+		c.Fsync, err = receipt.ParseSyncPolicy(v)
+		return err
+	}},
+	{name: "fsync-interval", usage: "how often to sync the write-ahead log under the batch policy", set: func(c *Config, v string) error {
+		return parseDuration(v, &c.FsyncInterval)
+	}},
+	{name: "rules", list: true, usage: "path to a JSON rule config; may be repeated, or comma-separated, to load several versions", set: func(c *Config, v string) error {
+		c.RuleFiles = nil
+		if v != "" {
+			c.RuleFiles = strings.Split(v, ",")
+		}
+		return nil
+	}},
+	{name: "rules-version", usage: "rule version used to score new receipts; defaults to the first rule file, or the built-in rules", set: func(c *Config, v string) error {
+		c.RuleVersion = v
+		return nil
+	}},
+	{name: "duplicates", usage: "what to do with a receipt already submitted: flag, reject, or return-existing", set: func(c *Config, v string) (err error) {
+		c.Duplicates, err = receipt.ParseDuplicatePolicy(v)
+		return err
+	}},
+	{name: "idempotency-window", usage: "how long an Idempotency-Key is remembered", set: func(c *Config, v string) error {
+		return parseDuration(v, &c.IdempotencyWindow)
+	}},
+	{name: "log-level", usage: "minimum level logged: debug, info, warn, or error", set: func(c *Config, v string) error {
+		if err := c.LogLevel.UnmarshalText([]byte(v)); err != nil {
+			return fmt.Errorf("unknown log level %q, must be one of debug, info, warn, or error", v)
+		}
+		return nil
+	}},
+	{name: "api-keys", list: true, usage: "API keys as name:sha256-hash:scopes, with scopes separated by |; may be repeated, or comma-separated", set: func(c *Config, v string) error {
+		c.APIKeys = nil
+		for _, value := range strings.Split(v, ",") {
+			key, err := auth.ParseKey(strings.TrimSpace(value))
+			if err != nil {
+				return err
+			}
+			c.APIKeys = append(c.APIKeys, key)
+		}
+		return nil
+	}},
+	{name: "api-keys-file", usage: "path to a JSON file of API keys, read again on SIGHUP", set: func(c *Config, v string) error {
+		c.APIKeysFile = v
+		return nil
+	}},
+	{name: "read-rate", usage: "reads allowed per second per client once the burst is used, or 0 for no limit", set: func(c *Config, v string) error {
+		return parseFloat(v, &c.ReadRate)
+	}},
+	{name: "read-burst", usage: "reads a client may make at once", set: func(c *Config, v string) error {
+		return parseInt(v, &c.ReadBurst)
+	}},
+	{name: "write-rate", usage: "writes allowed per second per client once the burst is used, or 0 for no limit", set: func(c *Config, v string) error {
+		return parseFloat(v, &c.WriteRate)
+	}},
+	{name: "write-burst", usage: "writes a client may make at once", set: func(c *Config, v string) error {
+		return parseInt(v, &c.WriteBurst)
+	}},
+	{name: "score-rate", usage: "receipts scored without being stored per second per client once the burst is used, or 0 for no limit", set: func(c *Config, v string) error {
+		return parseFloat(v, &c.ScoreRate)
+	}},
+	{name: "score-burst", usage: "receipts a client may score at once", set: func(c *Config, v string) error {
+		return parseInt(v, &c.ScoreBurst)
+	}},
+	{name: "batch-rate", usage: "batches allowed per second per client once the burst is used, or 0 for no limit", set: func(c *Config, v string) error {
+		return parseFloat(v, &c.BatchRate)
+	}},
+	{name: "batch-burst", usage: "batches a client may submit at once", set: func(c *Config, v string) error {
+		return parseInt(v, &c.BatchBurst)
+	}},
+	{name: "ip-rate", usage: "requests allowed per second per IP address before API keys are checked, or 0 for no limit", set: func(c *Config, v string) error {
+		return parseFloat(v, &c.IPRate)
+	}},
+	{name: "ip-burst", usage: "requests an IP address may make at once", set: func(c *Config, v string) error {
+		return parseInt(v, &c.IPBurst)
+	}},
+	{name: "openapi-validation", boolean: true, usage: "check every request and response against the OpenAPI document, for development and testing", set: func(c *Config, v string) error {
+		return parseBool(v, &c.OpenAPIValidation)
+	}},
+	{name: "log-format", usage: "log output format: text or json", set: func(c *Config, v string) error {
+		if v != logging.FormatText && v != logging.FormatJSON {
+			return fmt.Errorf("unknown log format %q, must be one of text or json", v)
+		}
+		c.LogFormat = v
+		return nil
+	}},
+}
+
+func parseDuration(value string, d *time.Duration) error {
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("%q is not a duration such as 10s or 1m30s", value)
+	}
+	*d = parsed
+	return nil
+}
+
+func parseInt[T int | int64](value string, n *T) error {
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%q is not a whole number", value)
+	}
+	*n = T(parsed)
+	return nil
+}
+
+func parseFloat(value string, f *float64) error {
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return fmt.Errorf("%q is not a number", value)
+	}
+	*f = parsed
+	return nil
+}
+
+func parseBool(value string, b *bool) error {
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("%q is not true or false", value)
+	}
+	*b = parsed
+	return nil
+}
+
+// Load builds the configuration from the command-line arguments, the
+// environment, and the config file named by -config or RECEIPT_CONFIG. Every
+// invalid value is reported, not just the first.
+func Load(args []string, getenv func(string) string, output io.Writer) (*Config, error) {
+	flags := flag.NewFlagSet("api", flag.ContinueOnError)
+	flags.SetOutput(output)
+
+	configFile := flags.String("config", "", "path to a JSON config file (env "+EnvPrefix+"CONFIG)")
+	flagValues := make(map[string][]string)
+	for _, s := range settings {
+		name := s.name
+		usage := fmt.Sprintf("%s (env %s)", s.usage, s.env())
+		record := func(value string) error {
+			flagValues[name] = append(flagValues[name], value)
+			return nil
+		}
+		if s.boolean {
+			flags.BoolFunc(name, usage, record)
+		} else {
+			flags.Func(name, usage, record)
+		}
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	if flags.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments %q", flags.Args())
+	}
+
+	c := Default()
+	var errs []error
+
+	path := *configFile
+	if path == "" {
+		path = getenv(EnvPrefix + "CONFIG")
+	}
+	if path != "" {
+		if err := c.loadFile(path); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	for _, s := range settings {
+		if value := getenv(s.env()); value != "" {
+			if err := s.set(c, value); err != nil {
+				errs = append(errs, fmt.Errorf("environment variable %s: %w", s.env(), err))
+			}
+		}
+	}
+
+	for _, s := range settings {
+		values, ok := flagValues[s.name]
+		if !ok {
+			continue
+		}
+		value := values[len(values)-1]
+		if s.list {
+			value = strings.Join(values, ",")
+		}
+		if err := s.set(c, value); err != nil {
+			errs = append(errs, fmt.Errorf("flag -%s: %w", s.name, err))
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// loadFile applies a JSON object keyed by setting name. Values may be strings,
+// numbers, or, for settings that take several values, arrays of strings.
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+
+	var values map[string]json.RawMessage
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&values); err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	known := make(map[string]setting, len(settings))
+	for _, s := range settings {
+		known[s.name] = s
+	}
+
+	// Keys are applied in sorted order so errors are reported consistently.
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var errs []error
+	for _, key := range keys {
+		s, ok := known[key]
+		if !ok {
+			errs = append(errs, fmt.Errorf("config file %s: unknown setting %q", path, key))
+			continue
+		}
+		value, err := fileValue(values[key], s)
+		if err == nil {
+			err = s.set(c, value)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("config file %s: %q: %w", path, key, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func fileValue(raw json.RawMessage, s setting) (string, error) {
+	if s.list && bytes.HasPrefix(bytes.TrimSpace(raw), []byte("[")) {
+		var values []string
+		if err := json.Unmarshal(raw, &values); err != nil {
+			return "", errors.New("must be a string or an array of strings")
+		}
+		return strings.Join(values, ","), nil
+	}
+
+	var value any
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return "", err
+	}
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		if s.boolean {
+			return strconv.FormatBool(v), nil
+		}
+	}
+	return "", errors.New("must be a string or a number")
+}
+
+// Validate reports every setting that is out of range or inconsistent with
+// another.
+func (c *Config) Validate() error {
+	var errs []error
+	invalid := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if _, _, err := net.SplitHostPort(c.Addr); err != nil {
+		invalid("addr %q must be a host and port such as :8080, %v", c.Addr, err)
+	}
+
+	durations := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"read-timeout", c.ReadTimeout},
+		{"read-header-timeout", c.ReadHeaderTimeout},
+		{"write-timeout", c.WriteTimeout},
+		{"idle-timeout", c.IdleTimeout},
+		{"shutdown-timeout", c.ShutdownTimeout},
+		{"fsync-interval", c.FsyncInterval},
+		{"idempotency-window", c.IdempotencyWindow},
+	}
+	for _, d := range durations {
+		if d.value <= 0 {
+			invalid("%s must be positive, but is %v", d.name, d.value)
+		}
+	}
+	if c.ShutdownDelay < 0 {
+		invalid("shutdown-delay must not be negative, but is %v", c.ShutdownDelay)
+	}
+	if c.ReadHeaderTimeout > c.ReadTimeout {
+		invalid("read-header-timeout %v must not be longer than read-timeout %v", c.ReadHeaderTimeout, c.ReadTimeout)
+	}
+
+	sizes := []struct {
+		name  string
+		value int64
+	}{
+		{"max-body-size", c.MaxBodySize},
+		{"batch-size", int64(c.MaxBatchSize)},
+		{"batch-body-size", c.MaxBatchBodySize},
+	}
+	for _, s := range sizes {
+		if s.value <= 0 {
+			invalid("%s must be positive, but is %d", s.name, s.value)
+		}
+	}
+
+	limits := []struct {
+		name  string
+		rate  float64
+		burst int
+	}{
+		{"read", c.ReadRate, c.ReadBurst},
+		{"write", c.WriteRate, c.WriteBurst},
+		{"score", c.ScoreRate, c.ScoreBurst},
+		{"batch", c.BatchRate, c.BatchBurst},
+		{"ip", c.IPRate, c.IPBurst},
+	}
+	for _, l := range limits {
+		switch {
+		case math.IsNaN(l.rate) || math.IsInf(l.rate, 0):
+			invalid("%s-rate must be a finite number, but is %v", l.name, l.rate)
+		case l.rate < 0:
+			invalid("%s-rate must not be negative, but is %v", l.name, l.rate)
+		case l.rate > 0 && l.burst <= 0:
+			invalid("%s-burst must be positive, but is %d", l.name, l.burst)
+		}
+	}
+
+	switch {
+	case c.Storage == StorageFile && c.DataFile == "":
+		invalid("data-file is required with the file storage backend")
+	case c.Storage == StorageMemory && c.DataFile != "":
+		invalid("data-file is only used with the file storage backend, set storage to file")
+	}
+
+	for _, path := range c.RuleFiles {
+		if strings.TrimSpace(path) == "" {
+			invalid("rules must not contain an empty path")
+			break
+		}
+	}
+
+	return errors.Join(errs...)
+}
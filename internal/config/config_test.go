@@ -0,0 +1,238 @@
+package config
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lzchong/receipt-processor/internal/api/receipt"
+	"github.com/lzchong/receipt-processor/internal/auth"
+)
+
+func TestLoad_Defaults(t *testing.T) {
+	got, err := Load(nil, noEnv, io.Discard)
+	if err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+	if want := Default(); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected config %+v, but got %+v", want, got)
+	}
+}
+
+func TestLoad_Precedence(t *testing.T) {
+	path := writeFile(t, `{
+		"addr": ":7070",
+		"read-timeout": "20s",
+		"write-timeout": "30s",
+		"max-body-size": 2048,
+		"rules": ["a.json", "b.json"],
+		"log-level": "warn",
+		"openapi-validation": true
+	}`)
+	env := map[string]string{
+		"RECEIPT_CONFIG":           path,
+		"RECEIPT_READ_TIMEOUT":     "15s",
+		"RECEIPT_MAX_BODY_SIZE":    "4096",
+		"RECEIPT_DUPLICATES":       "reject",
+		"RECEIPT_SHUTDOWN_TIMEOUT": "5s",
+		"RECEIPT_LOG_FORMAT":       "json",
+	}
+	args := []string{"-max-body-size", "8192", "-log-level", "debug"}
+
+	got, err := Load(args, mapEnv(env), io.Discard)
+	if err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+
+	assertEqual(t, "addr from file", got.Addr, ":7070")
+	assertEqual(t, "write timeout from file", got.WriteTimeout, 30*time.Second)
+	assertEqual(t, "read timeout from environment", got.ReadTimeout, 15*time.Second)
+	assertEqual(t, "duplicates from environment", got.Duplicates, receipt.DuplicateReject)
+	assertEqual(t, "shutdown timeout from environment", got.ShutdownTimeout, 5*time.Second)
+	assertEqual(t, "max body size from flag", got.MaxBodySize, int64(8192))
+	assertEqual(t, "log level from flag", got.LogLevel, slog.LevelDebug)
+	assertEqual(t, "log format from environment", got.LogFormat, "json")
+	assertEqual(t, "OpenAPI validation from file", got.OpenAPIValidation, true)
+	assertEqual(t, "idle timeout default", got.IdleTimeout, 60*time.Second)
+	if want := []string{"a.json", "b.json"}; !reflect.DeepEqual(got.RuleFiles, want) {
+		t.Errorf("expected rule files %v, but got %v", want, got.RuleFiles)
+	}
+}
+
+func TestLoad_Flags(t *testing.T) {
+	args := []string{
+		"-config", writeFile(t, `{"storage": "memory"}`),
+		"-storage", "file",
+		"-data-file", "receipts.wal",
+		"-fsync", "batch",
+		"-rules", "a.json",
+		"-rules", "b.json",
+		"-api-keys", "ci:" + auth.HashKey("ci-secret") + ":receipts:read|receipts:write",
+		"-api-keys", "ops:" + auth.HashKey("ops-secret") + ":admin",
+		"-read-rate", "2.5",
+		"-write-rate", "0",
+		"-write-burst", "0",
+		"-score-rate", "5",
+		"-openapi-validation",
+	}
+
+	got, err := Load(args, noEnv, io.Discard)
+	if err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+
+	assertEqual(t, "storage", got.Storage, StorageFile)
+	assertEqual(t, "data file", got.DataFile, "receipts.wal")
+	assertEqual(t, "fsync", got.Fsync, receipt.SyncBatch)
+	assertEqual(t, "read rate", got.ReadRate, 2.5)
+	assertEqual(t, "write rate", got.WriteRate, 0.0)
+	assertEqual(t, "score rate", got.ScoreRate, 5.0)
+	assertEqual(t, "score burst default", got.ScoreBurst, 100)
+	assertEqual(t, "OpenAPI validation", got.OpenAPIValidation, true)
+	if want := []string{"a.json", "b.json"}; !reflect.DeepEqual(got.RuleFiles, want) {
+		t.Errorf("expected rule files %v, but got %v", want, got.RuleFiles)
+	}
+	want := []auth.Key{
+		{Name: "ci", Hash: auth.HashKey("ci-secret"), Scopes: []string{auth.ScopeReceiptsRead, auth.ScopeReceiptsWrite}},
+		{Name: "ops", Hash: auth.HashKey("ops-secret"), Scopes: []string{auth.ScopeAdmin}},
+	}
+	if !reflect.DeepEqual(got.APIKeys, want) {
+		t.Errorf("expected API keys %+v, but got %+v", want, got.APIKeys)
+	}
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	tests := map[string]struct {
+		args     []string
+		env      map[string]string
+		file     string
+		expected []string
+	}{
+		"bad flag value": {
+			args:     []string{"-read-timeout", "soon"},
+			expected: []string{`flag -read-timeout: "soon" is not a duration`},
+		},
+		"bad environment value": {
+			env:      map[string]string{"RECEIPT_BATCH_SIZE": "many"},
+			expected: []string{`environment variable RECEIPT_BATCH_SIZE: "many" is not a whole number`},
+		},
+		"every error reported": {
+			args: []string{"-storage", "disk"},
+			env:  map[string]string{"RECEIPT_LOG_LEVEL": "loud"},
+			expected: []string{
+				`environment variable RECEIPT_LOG_LEVEL: unknown log level "loud"`,
+				`flag -storage: unknown storage backend "disk"`,
+			},
+		},
+		"bad log format": {
+			args:     []string{"-log-format", "xml"},
+			expected: []string{`flag -log-format: unknown log format "xml"`},
+		},
+		"bad API key": {
+			args:     []string{"-api-keys", "ci:abc:admin"},
+			expected: []string{`flag -api-keys: key "ci" must have a hash of 64 hexadecimal digits`},
+		},
+		"unknown file setting": {
+			file:     `{"port": 8080}`,
+			expected: []string{`unknown setting "port"`},
+		},
+		"bad file value": {
+			file:     `{"idle-timeout": true}`,
+			expected: []string{`"idle-timeout": must be a string or a number`},
+		},
+		"missing file": {
+			env:      map[string]string{"RECEIPT_CONFIG": "does-not-exist.json"},
+			expected: []string{"config file: open does-not-exist.json"},
+		},
+		"out of range": {
+			args: []string{"-addr", "8080", "-batch-size", "0", "-read-timeout", "-1s", "-shutdown-delay", "-1s"},
+			expected: []string{
+				`addr "8080" must be a host and port`,
+				"read-timeout must be positive",
+				"shutdown-delay must not be negative",
+				"batch-size must be positive",
+			},
+		},
+		"bad rate limits": {
+			args: []string{"-read-rate", "-1", "-write-burst", "0", "-score-rate", "-2", "-batch-rate", "-1", "-ip-burst", "0"},
+			expected: []string{
+				"read-rate must not be negative",
+				"write-burst must be positive",
+				"score-rate must not be negative",
+				"batch-rate must not be negative",
+				"ip-burst must be positive",
+			},
+		},
+		"rates that are not finite": {
+			args: []string{"-read-rate", "NaN", "-write-rate", "+Inf", "-score-rate", "-Inf"},
+			expected: []string{
+				"read-rate must be a finite number",
+				"write-rate must be a finite number",
+				"score-rate must be a finite number",
+			},
+		},
+		"file storage without data file": {
+			args:     []string{"-storage", "file"},
+			expected: []string{"data-file is required with the file storage backend"},
+		},
+		"data file without file storage": {
+			args:     []string{"-data-file", "receipts.wal"},
+			expected: []string{"data-file is only used with the file storage backend"},
+		},
+		"unexpected argument": {
+			args:     []string{"serve"},
+			expected: []string{`unexpected arguments ["serve"]`},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			env := test.env
+			if test.file != "" {
+				env = map[string]string{"RECEIPT_CONFIG": writeFile(t, test.file)}
+			}
+
+			_, err := Load(test.args, mapEnv(env), io.Discard)
+			if err == nil {
+				t.Fatal("expected has error, but got nothing")
+			}
+			for _, want := range test.expected {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("expected error containing %q, but got %q", want, err)
+				}
+			}
+		})
+	}
+}
+
+func noEnv(string) string {
+	return ""
+}
+
+func mapEnv(env map[string]string) func(string) string {
+	return func(key string) string {
+		return env[key]
+	}
+}
+
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func assertEqual[T comparable](t *testing.T, name string, got, want T) {
+	t.Helper()
+	if got != want {
+		t.Errorf("expected %s %v, but got %v", name, want, got)
+	}
+}
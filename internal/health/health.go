@@ -0,0 +1,157 @@
+// Package health serves the liveness and readiness probes of the server.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Statuses reported for the server and each check.
+const (
+	StatusOK           = "ok"
+	StatusFail         = "fail"
+	StatusShuttingDown = "shutting_down"
+)
+
+const defaultTimeout = 2 * time.Second
+
+// Check reports whether a dependency is usable. It should give up when ctx is
+// done.
+type Check func(ctx context.Context) error
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Checker runs the registered readiness checks.
+type Checker struct {
+	lock         sync.RWMutex
+	checks       []namedCheck
+	timeout      time.Duration
+	shuttingDown atomic.Bool
+}
+
+type Option func(*Checker)
+
+// WithTimeout sets how long the readiness checks may take together.
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Checker) {
+		c.timeout = timeout
+	}
+}
+
+func New(options ...Option) *Checker {
+	c := &Checker{timeout: defaultTimeout}
+	for _, option := range options {
+		option(c)
+	}
+	return c
+}
+
+// Register adds a readiness check. Checks are reported in the order they were
+// registered.
+func (c *Checker) Register(name string, check Check) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.checks = append(c.checks, namedCheck{name, check})
+}
+
+// Shutdown makes readiness fail from now on, so no new traffic is routed to
+// a server that is about to stop.
+func (c *Checker) Shutdown() {
+	c.shuttingDown.Store(true)
+}
+
+// Report is the body of a probe response.
+type Report struct {
+	Status string        `json:"status"`
+	Checks []CheckResult `json:"checks,omitempty"`
+}
+
+type CheckResult struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Live reports that the process is running and able to serve requests.
+func (c *Checker) Live(w http.ResponseWriter, r *http.Request) {
+	writeReport(w, http.StatusOK, Report{Status: StatusOK})
+}
+
+// Ready runs every check and fails if any of them fail or the server is
+// shutting down.
+func (c *Checker) Ready(w http.ResponseWriter, r *http.Request) {
+	if c.shuttingDown.Load() {
+		writeReport(w, http.StatusServiceUnavailable, Report{Status: StatusShuttingDown})
+		return
+	}
+
+	report := c.Run(r.Context())
+	status := http.StatusOK
+	if report.Status != StatusOK {
+		status = http.StatusServiceUnavailable
+	}
+	writeReport(w, status, report)
+}
+
+// Run runs the checks concurrently. A check that has not finished by the
+// timeout fails.
+func (c *Checker) Run(ctx context.Context) Report {
+	c.lock.RLock()
+	checks := c.checks
+	c.lock.RUnlock()
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	report := Report{Status: StatusOK, Checks: make([]CheckResult, len(checks))}
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func(i int, check namedCheck) {
+			defer wg.Done()
+			report.Checks[i] = run(ctx, check)
+		}(i, check)
+	}
+	wg.Wait()
+
+	for _, result := range report.Checks {
+		if result.Status != StatusOK {
+			report.Status = StatusFail
+		}
+	}
+	return report
+}
+
+func run(ctx context.Context, check namedCheck) CheckResult {
+	done := make(chan error, 1)
+	go func() {
+		done <- check.check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = errors.New("check timed out")
+	}
+
+	if err != nil {
+		return CheckResult{Name: check.name, Status: StatusFail, Error: err.Error()}
+	}
+	return CheckResult{Name: check.name, Status: StatusOK}
+}
+
+func writeReport(w http.ResponseWriter, status int, report Report) {
+	w.Header().Set("content-type", "application/json")
+	w.Header().Set("cache-control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
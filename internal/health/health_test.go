@@ -0,0 +1,145 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestChecker_Live(t *testing.T) {
+	checker := New()
+	checker.Register("repository", failingCheck)
+
+	response := probe(t, checker.Live)
+
+	assertStatus(t, response, http.StatusOK)
+	assertReport(t, response, Report{Status: StatusOK})
+}
+
+func TestChecker_Ready(t *testing.T) {
+	tests := map[string]struct {
+		checks   map[string]Check
+		status   int
+		expected Report
+	}{
+		"no checks": {
+			status:   http.StatusOK,
+			expected: Report{Status: StatusOK},
+		},
+		"all pass": {
+			checks: map[string]Check{"repository": passingCheck},
+			status: http.StatusOK,
+			expected: Report{Status: StatusOK, Checks: []CheckResult{
+				{Name: "repository", Status: StatusOK},
+			}},
+		},
+		"one fails": {
+			checks: map[string]Check{"rules": failingCheck},
+			status: http.StatusServiceUnavailable,
+			expected: Report{Status: StatusFail, Checks: []CheckResult{
+				{Name: "rules", Status: StatusFail, Error: "disk full"},
+			}},
+		},
+		"timed out": {
+			checks: map[string]Check{"repository": blockingCheck},
+			status: http.StatusServiceUnavailable,
+			expected: Report{Status: StatusFail, Checks: []CheckResult{
+				{Name: "repository", Status: StatusFail, Error: "check timed out"},
+			}},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			checker := New(WithTimeout(50 * time.Millisecond))
+			for name, check := range test.checks {
+				checker.Register(name, check)
+			}
+
+			response := probe(t, checker.Ready)
+
+			assertStatus(t, response, test.status)
+			assertReport(t, response, test.expected)
+		})
+	}
+
+	t.Run("reports checks in order", func(t *testing.T) {
+		checker := New()
+		checker.Register("repository", passingCheck)
+		checker.Register("rules", failingCheck)
+
+		report := checker.Run(context.Background())
+
+		want := []CheckResult{
+			{Name: "repository", Status: StatusOK},
+			{Name: "rules", Status: StatusFail, Error: "disk full"},
+		}
+		if !reflect.DeepEqual(report.Checks, want) {
+			t.Errorf("expected checks %+v, but got %+v", want, report.Checks)
+		}
+	})
+
+	t.Run("shutting down", func(t *testing.T) {
+		checker := New()
+		checker.Register("repository", passingCheck)
+		checker.Shutdown()
+
+		response := probe(t, checker.Ready)
+
+		assertStatus(t, response, http.StatusServiceUnavailable)
+		assertReport(t, response, Report{Status: StatusShuttingDown})
+	})
+}
+
+func passingCheck(ctx context.Context) error {
+	return nil
+}
+
+func failingCheck(ctx context.Context) error {
+	return errors.New("disk full")
+}
+
+func blockingCheck(ctx context.Context) error {
+	<-ctx.Done()
+	time.Sleep(10 * time.Millisecond)
+	return nil
+}
+
+func probe(t *testing.T, handler http.HandlerFunc) *httptest.ResponseRecorder {
+	t.Helper()
+	request, err := http.NewRequest("GET", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := httptest.NewRecorder()
+	handler(response, request)
+	return response
+}
+
+func assertStatus(t *testing.T, response *httptest.ResponseRecorder, want int) {
+	t.Helper()
+	if got := response.Code; got != want {
+		t.Errorf("expected status %d, but got %d", want, got)
+	}
+}
+
+func assertReport(t *testing.T, response *httptest.ResponseRecorder, want Report) {
+	t.Helper()
+	if got := response.Result().Header.Get("content-type"); got != "application/json" {
+		t.Errorf("expected content-type application/json, but got %s", got)
+	}
+
+	var got Report
+	if err := json.NewDecoder(response.Body).Decode(&got); err != nil {
+		t.Fatalf("failed to parse response %q, '%v'", response.Body, err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected report %+v, but got %+v", want, got)
+	}
+}
@@ -0,0 +1,124 @@
+// Package logging sets up structured logging and carries the ID of each
+// request through its context, so every record logged while serving a
+// request can be traced back to it.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// RequestIDHeader carries the request ID from the client and back.
+const RequestIDHeader = "X-Request-ID"
+
+const maxRequestIDLength = 128
+
+// Output formats.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+type contextKey struct{}
+
+// requestInfo is what is known about the request being served.
+type requestInfo struct {
+	id string
+
+	lock      sync.Mutex
+	receiptID string
+}
+
+// WithRequestID returns a context carrying the request ID.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, &requestInfo{id: id})
+}
+
+// RequestID returns the ID of the request the context belongs to, or "" if
+// there is none.
+func RequestID(ctx context.Context) string {
+	if info, ok := ctx.Value(contextKey{}).(*requestInfo); ok {
+		return info.id
+	}
+	return ""
+}
+
+// SetReceiptID records the receipt the request is about, so it is included
+// when the request is logged. It does nothing outside a request.
+func SetReceiptID(ctx context.Context, id string) {
+	if info, ok := ctx.Value(contextKey{}).(*requestInfo); ok {
+		info.lock.Lock()
+		defer info.lock.Unlock()
+		info.receiptID = id
+	}
+}
+
+// ReceiptID returns the receipt recorded by SetReceiptID, or "" if there is
+// none.
+func ReceiptID(ctx context.Context) string {
+	if info, ok := ctx.Value(contextKey{}).(*requestInfo); ok {
+		info.lock.Lock()
+		defer info.lock.Unlock()
+		return info.receiptID
+	}
+	return ""
+}
+
+func NewRequestID() string {
+	return uuid.New().String()
+}
+
+// ValidRequestID reports whether a client-supplied request ID can be used.
+// IDs are limited to printable ASCII so they cannot forge log lines.
+func ValidRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < '!' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// New returns a logger writing records at or above the level in the format,
+// adding the request ID of the context to every record.
+func New(w io.Writer, format string, level slog.Leveler) (*slog.Logger, error) {
+	options := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	switch format {
+	case FormatText:
+		handler = slog.NewTextHandler(w, options)
+	case FormatJSON:
+		handler = slog.NewJSONHandler(w, options)
+	default:
+		return nil, fmt.Errorf("unknown log format %q, must be one of text or json", format)
+	}
+	return slog.New(contextHandler{handler}), nil
+}
+
+// contextHandler adds the request ID of the context to every record.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		record.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
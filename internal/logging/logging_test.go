@@ -0,0 +1,124 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestRequestID(t *testing.T) {
+	ctx := WithRequestID(context.Background(), "abc")
+	if got := RequestID(ctx); got != "abc" {
+		t.Errorf("expected request ID abc, but got %q", got)
+	}
+	if got := RequestID(context.Background()); got != "" {
+		t.Errorf("expected no request ID, but got %q", got)
+	}
+}
+
+func TestReceiptID(t *testing.T) {
+	ctx := WithRequestID(context.Background(), "abc")
+	SetReceiptID(ctx, "7fb1377b-b223-49d9-a31a-5a02701dd310")
+	if got := ReceiptID(ctx); got != "7fb1377b-b223-49d9-a31a-5a02701dd310" {
+		t.Errorf("expected receipt ID 7fb1377b-b223-49d9-a31a-5a02701dd310, but got %q", got)
+	}
+
+	// Outside a request there is nowhere to record it.
+	SetReceiptID(context.Background(), "7fb1377b-b223-49d9-a31a-5a02701dd310")
+	if got := ReceiptID(context.Background()); got != "" {
+		t.Errorf("expected no receipt ID, but got %q", got)
+	}
+}
+
+func TestValidRequestID(t *testing.T) {
+	testCases := map[string]struct {
+		id   string
+		want bool
+	}{
+		"uuid":         {"7fb1377b-b223-49d9-a31a-5a02701dd310", true},
+		"printable":    {"req_42:retry#1", true},
+		"empty":        {"", false},
+		"space":        {"req 42", false},
+		"newline":      {"req\n42", false},
+		"non-ascii":    {"réq", false},
+		"maximum size": {strings.Repeat("a", maxRequestIDLength), true},
+		"too long":     {strings.Repeat("a", maxRequestIDLength+1), false},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			if got := ValidRequestID(tc.id); got != tc.want {
+				t.Errorf("expected %t, but got %t", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	testCases := map[string]struct {
+		format string
+		want   string
+	}{
+		"text": {FormatText, "request_id=abc"},
+		"json": {FormatJSON, `"request_id":"abc"`},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			var buffer bytes.Buffer
+			logger, err := New(&buffer, tc.format, slog.LevelInfo)
+			if err != nil {
+				t.Fatalf("expected no error, but got %v", err)
+			}
+
+			logger.InfoContext(WithRequestID(context.Background(), "abc"), "Served")
+			if got := buffer.String(); !strings.Contains(got, tc.want) {
+				t.Errorf("expected log to contain %s, but got %s", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestNew_WithoutRequest(t *testing.T) {
+	var buffer bytes.Buffer
+	logger, err := New(&buffer, FormatJSON, slog.LevelInfo)
+	if err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+
+	logger.With("component", "test").Info("Started")
+	var record map[string]any
+	if err := json.Unmarshal(buffer.Bytes(), &record); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := record["request_id"]; ok {
+		t.Errorf("expected no request ID, but got %v", record["request_id"])
+	}
+	if got := record["component"]; got != "test" {
+		t.Errorf("expected component test, but got %v", got)
+	}
+}
+
+func TestNew_Level(t *testing.T) {
+	var buffer bytes.Buffer
+	logger, err := New(&buffer, FormatText, slog.LevelWarn)
+	if err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+
+	logger.Info("Ignored")
+	if got := buffer.String(); got != "" {
+		t.Errorf("expected nothing logged, but got %s", got)
+	}
+}
+
+func TestNew_UnknownFormat(t *testing.T) {
+	if _, err := New(&bytes.Buffer{}, "xml", slog.LevelInfo); err == nil {
+		t.Error("expected has error, but got nothing")
+	}
+}
@@ -0,0 +1,92 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/lzchong/receipt-processor/internal/api/receipt"
+)
+
+// RepositoryBuckets suit repository calls, which are mostly in memory.
+var RepositoryBuckets = []float64{.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25}
+
+// Metrics are the metrics of the API server. It is a receipt.Observer, so it
+// can be given to the receipt service and handler.
+type Metrics struct {
+	registry           *Registry
+	requests           *Counter
+	requestDuration    *Histogram
+	panics             *Counter
+	receiptsProcessed  *Counter
+	receiptsScored     *Counter
+	validationFailures *Counter
+	pointsAwarded      *Counter
+	repositoryDuration *Histogram
+}
+
+func New() *Metrics {
+	r := NewRegistry()
+	return &Metrics{
+		registry:           r,
+		requests:           r.NewCounter("http_requests_total", "Number of HTTP requests by route and status.", "route", "status"),
+		requestDuration:    r.NewHistogram("http_request_duration_seconds", "Latency of HTTP requests by route and status.", DefaultBuckets, "route", "status"),
+		panics:             r.NewCounter("http_panics_total", "Number of panics recovered while serving HTTP requests by route.", "route"),
+		receiptsProcessed:  r.NewCounter("receipts_processed_total", "Number of submitted receipts by outcome.", "outcome"),
+		receiptsScored:     r.NewCounter("receipts_scored_total", "Number of receipts scored without being stored."),
+		validationFailures: r.NewCounter("receipt_validation_failures_total", "Number of violations in rejected requests by operation and reason.", "operation", "reason"),
+		pointsAwarded:      r.NewCounter("receipt_points_awarded_total", "Points awarded to stored receipts by rule.", "rule"),
+		repositoryDuration: r.NewHistogram("receipt_repository_operation_duration_seconds", "Latency of repository calls by operation.", RepositoryBuckets, "operation"),
+	}
+}
+
+func (m *Metrics) Handler() http.Handler {
+	return m.registry.Handler()
+}
+
+// Registry returns the registry the metrics are kept in, so more can be added.
+func (m *Metrics) Registry() *Registry {
+	return m.registry
+}
+
+// ObserveRequest records a finished HTTP request.
+func (m *Metrics) ObserveRequest(route string, status int, duration time.Duration) {
+	code := strconv.Itoa(status)
+	m.requests.Inc(route, code)
+	m.requestDuration.Observe(duration.Seconds(), route, code)
+}
+
+// ObservePanic records a panic recovered while serving a request.
+func (m *Metrics) ObservePanic(route string) {
+	m.panics.Inc(route)
+}
+
+// ObserveRepositorySize reports the number of stored receipts, read each time
+// the metrics are written.
+func (m *Metrics) ObserveRepositorySize(size func() int) {
+	m.registry.NewGaugeFunc("receipt_repository_receipts", "Number of stored receipts.", func() float64 {
+		return float64(size())
+	})
+}
+
+func (m *Metrics) ReceiptProcessed(outcome string) {
+	m.receiptsProcessed.Inc(outcome)
+}
+
+func (m *Metrics) ReceiptScored() {
+	m.receiptsScored.Inc()
+}
+
+func (m *Metrics) ValidationFailed(operation, code string) {
+	m.validationFailures.Inc(operation, code)
+}
+
+func (m *Metrics) PointsAwarded(breakdown *receipt.PointsBreakdown) {
+	for _, rule := range breakdown.Rules {
+		m.pointsAwarded.Add(float64(rule.Points), rule.Name)
+	}
+}
+
+func (m *Metrics) RepositoryOperation(operation string, duration time.Duration) {
+	m.repositoryDuration.Observe(duration.Seconds(), operation)
+}
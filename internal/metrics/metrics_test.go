@@ -0,0 +1,47 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lzchong/receipt-processor/internal/api/receipt"
+)
+
+func TestMetrics(t *testing.T) {
+	m := New()
+	m.ObserveRequest("GET /receipts/{id}/points", 200, 30*time.Millisecond)
+	m.ReceiptProcessed(receipt.OutcomeStored)
+	m.ReceiptProcessed(receipt.OutcomeStored)
+	m.ReceiptScored()
+	m.ValidationFailed(receipt.OperationProcess, receipt.CodeRequired)
+	m.ValidationFailed(receipt.OperationScore, receipt.CodeRequired)
+	m.PointsAwarded(&receipt.PointsBreakdown{Points: 16, Rules: []receipt.RulePoints{
+		{Name: "retailerAlphanumeric", Points: 6},
+		{Name: "itemPairs", Points: 10},
+	}})
+	m.RepositoryOperation("create_points", time.Millisecond)
+	m.ObserveRepositorySize(func() int { return 2 })
+
+	var got strings.Builder
+	if err := m.Registry().Write(&got); err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+
+	for _, want := range []string{
+		`http_requests_total{route="GET /receipts/{id}/points",status="200"} 1`,
+		`http_request_duration_seconds_bucket{route="GET /receipts/{id}/points",status="200",le="0.05"} 1`,
+		`receipts_processed_total{outcome="stored"} 2`,
+		`receipts_scored_total 1`,
+		`receipt_validation_failures_total{operation="process",reason="required"} 1`,
+		`receipt_validation_failures_total{operation="score",reason="required"} 1`,
+		`receipt_points_awarded_total{rule="itemPairs"} 10`,
+		`receipt_points_awarded_total{rule="retailerAlphanumeric"} 6`,
+		`receipt_repository_operation_duration_seconds_count{operation="create_points"} 1`,
+		`receipt_repository_receipts 2`,
+	} {
+		if !strings.Contains(got.String(), want+"\n") {
+			t.Errorf("expected output to contain %s, but got\n%s", want, got.String())
+		}
+	}
+}
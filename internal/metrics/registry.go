@@ -0,0 +1,282 @@
+// Package metrics keeps counters, gauges, and histograms and serves them in
+// the Prometheus text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType is the media type of the Prometheus text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// family is one named metric with all of its labelled series.
+type family interface {
+	write(w *bufio.Writer)
+}
+
+// Registry holds the metrics to expose, in the order they were created.
+type Registry struct {
+	lock     sync.Mutex
+	families []family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(f family) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.families = append(r.families, f)
+}
+
+// Write writes every metric in the text exposition format.
+func (r *Registry) Write(w io.Writer) error {
+	r.lock.Lock()
+	families := r.families
+	r.lock.Unlock()
+
+	buffered := bufio.NewWriter(w)
+	for _, f := range families {
+		f.write(buffered)
+	}
+	return buffered.Flush()
+}
+
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("content-type", ContentType)
+		w.WriteHeader(http.StatusOK)
+		r.Write(w)
+	})
+}
+
+// series tracks the label values of a family, keyed by their joined values.
+type series[T any] struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+
+	lock   sync.Mutex
+	values map[string]*T
+	keys   map[string][]string
+}
+
+func newSeries[T any](name, help, kind string, labels []string) *series[T] {
+	return &series[T]{
+		name:   name,
+		help:   help,
+		kind:   kind,
+		labels: labels,
+		values: make(map[string]*T),
+		keys:   make(map[string][]string),
+	}
+}
+
+// get returns the value for the label values, creating it if needed. The
+// caller must hold the lock.
+func (s *series[T]) get(labelValues []string, create func() *T) *T {
+	if len(labelValues) != len(s.labels) {
+		panic(fmt.Sprintf("metric %s has %d labels, but got %d values", s.name, len(s.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	value, ok := s.values[key]
+	if !ok {
+		value = create()
+		s.values[key] = value
+		s.keys[key] = append([]string(nil), labelValues...)
+	}
+	return value
+}
+
+// each visits the series in a stable order. The caller must hold the lock.
+func (s *series[T]) each(visit func(labelValues []string, value *T)) {
+	keys := make([]string, 0, len(s.values))
+	for key := range s.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		visit(s.keys[key], s.values[key])
+	}
+}
+
+func (s *series[T]) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", s.name, escapeHelp(s.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", s.name, s.kind)
+}
+
+// Counter is a value that only goes up, such as a number of requests.
+type Counter struct {
+	*series[float64]
+}
+
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{newSeries[float64](name, help, "counter", labels)}
+	r.register(c)
+	return c
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add increases the counter. Negative values are ignored, as a counter never
+// goes down.
+func (c *Counter) Add(value float64, labelValues ...string) {
+	if value < 0 {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	*c.get(labelValues, func() *float64 { return new(float64) }) += value
+}
+
+// Value returns the current value of the counter, or zero if it was never
+// increased.
+func (c *Counter) Value(labelValues ...string) float64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if value, ok := c.values[strings.Join(labelValues, "\xff")]; ok {
+		return *value
+	}
+	return 0
+}
+
+func (c *Counter) write(w *bufio.Writer) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.writeHeader(w)
+	c.each(func(labelValues []string, value *float64) {
+		writeSample(w, c.name, c.labels, labelValues, *value)
+	})
+}
+
+// GaugeFunc is a value read when the metrics are written, such as the number
+// of stored receipts.
+type GaugeFunc struct {
+	name  string
+	help  string
+	value func() float64
+}
+
+func (r *Registry) NewGaugeFunc(name, help string, value func() float64) *GaugeFunc {
+	g := &GaugeFunc{name, help, value}
+	r.register(g)
+	return g
+}
+
+func (g *GaugeFunc) write(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", g.name, escapeHelp(g.help))
+	fmt.Fprintf(w, "# TYPE %s gauge\n", g.name)
+	writeSample(w, g.name, nil, nil, g.value())
+}
+
+// DefaultBuckets suit request latencies in seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Histogram counts observations, such as latencies, into buckets.
+type Histogram struct {
+	*series[histogramValue]
+	buckets []float64
+}
+
+type histogramValue struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// NewHistogram creates a histogram with the given upper bounds, which must be
+// sorted. The +Inf bucket is added automatically.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{newSeries[histogramValue](name, help, "histogram", labels), buckets}
+	r.register(h)
+	return h
+}
+
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	v := h.get(labelValues, func() *histogramValue {
+		return &histogramValue{counts: make([]uint64, len(h.buckets))}
+	})
+	for i, bound := range h.buckets {
+		if value <= bound {
+			v.counts[i]++
+		}
+	}
+	v.sum += value
+	v.count++
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.writeHeader(w)
+	bucketLabels := withLabel(h.labels, "le")
+	h.each(func(labelValues []string, value *histogramValue) {
+		for i, bound := range h.buckets {
+			writeSample(w, h.name+"_bucket", bucketLabels, withLabel(labelValues, formatFloat(bound)), float64(value.counts[i]))
+		}
+		writeSample(w, h.name+"_bucket", bucketLabels, withLabel(labelValues, "+Inf"), float64(value.count))
+		writeSample(w, h.name+"_sum", h.labels, labelValues, value.sum)
+		writeSample(w, h.name+"_count", h.labels, labelValues, float64(value.count))
+	})
+}
+
+func withLabel(labels []string, label string) []string {
+	return append(append(make([]string, 0, len(labels)+1), labels...), label)
+}
+
+func writeSample(w *bufio.Writer, name string, labels, labelValues []string, value float64) {
+	w.WriteString(name)
+	if len(labels) > 0 {
+		w.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", label, escapeLabel(labelValues[i]))
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(value))
+	w.WriteByte('\n')
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	}
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
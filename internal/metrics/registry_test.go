@@ -0,0 +1,85 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistry_Write(t *testing.T) {
+	registry := NewRegistry()
+	requests := registry.NewCounter("requests_total", "Number of requests.", "route", "status")
+	latency := registry.NewHistogram("latency_seconds", "Request latency.", []float64{0.1, 1}, "route")
+	registry.NewGaugeFunc("queue_size", "Items waiting.", func() float64 { return 3 })
+
+	requests.Inc("/b", "200")
+	requests.Add(2, "/a", "200")
+	requests.Add(-1, "/a", "200")
+	latency.Observe(0.05, "/a")
+	latency.Observe(0.5, "/a")
+	latency.Observe(5, "/a")
+
+	want := `# HELP requests_total Number of requests.
+# TYPE requests_total counter
+requests_total{route="/a",status="200"} 2
+requests_total{route="/b",status="200"} 1
+# HELP latency_seconds Request latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{route="/a",le="0.1"} 1
+latency_seconds_bucket{route="/a",le="1"} 2
+latency_seconds_bucket{route="/a",le="+Inf"} 3
+latency_seconds_sum{route="/a"} 5.55
+latency_seconds_count{route="/a"} 3
+# HELP queue_size Items waiting.
+# TYPE queue_size gauge
+queue_size 3
+`
+	var got strings.Builder
+	if err := registry.Write(&got); err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+	if got.String() != want {
+		t.Errorf("expected output\n%s\nbut got\n%s", want, got.String())
+	}
+}
+
+func TestRegistry_EscapesLabels(t *testing.T) {
+	registry := NewRegistry()
+	counter := registry.NewCounter("errors_total", "Errors by \\ message\nand cause.", "message")
+	counter.Inc("say \"hi\"\n")
+
+	var got strings.Builder
+	registry.Write(&got)
+
+	for _, want := range []string{
+		`# HELP errors_total Errors by \\ message\nand cause.`,
+		`errors_total{message="say \"hi\"\n"} 1`,
+	} {
+		if !strings.Contains(got.String(), want) {
+			t.Errorf("expected output to contain %s, but got\n%s", want, got.String())
+		}
+	}
+}
+
+func TestRegistry_Handler(t *testing.T) {
+	registry := NewRegistry()
+	registry.NewCounter("requests_total", "Number of requests.").Inc()
+
+	request, err := http.NewRequest("GET", "/metrics", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := httptest.NewRecorder()
+	registry.Handler().ServeHTTP(response, request)
+
+	if got := response.Code; got != http.StatusOK {
+		t.Errorf("expected status %d, but got %d", http.StatusOK, got)
+	}
+	if got := response.Result().Header.Get("content-type"); got != ContentType {
+		t.Errorf("expected content-type %s, but got %s", ContentType, got)
+	}
+	if got := response.Body.String(); !strings.Contains(got, "requests_total 1\n") {
+		t.Errorf("expected requests_total 1, but got\n%s", got)
+	}
+}
@@ -0,0 +1,313 @@
+// Package openapi serves the OpenAPI 3 document of the API and can check that
+// the handlers keep to it.
+package openapi
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/lzchong/receipt-processor/internal/api/problem"
+	"github.com/lzchong/receipt-processor/internal/apperr"
+)
+
+//go:embed openapi.json
+var spec []byte
+
+// Spec returns the OpenAPI document.
+func Spec() []byte {
+	return bytes.Clone(spec)
+}
+
+// Handler serves the OpenAPI document.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("content-type", "application/json")
+		w.Write(spec)
+	})
+}
+
+type document struct {
+	Paths      map[string]map[string]*operation `json:"paths"`
+	Components struct {
+		Schemas   map[string]*schema   `json:"schemas"`
+		Responses map[string]*response `json:"responses"`
+	} `json:"components"`
+}
+
+type operation struct {
+	RequestBody *struct {
+		Content map[string]mediaType `json:"content"`
+	} `json:"requestBody"`
+	Responses map[string]*response `json:"responses"`
+}
+
+type response struct {
+	Ref     string               `json:"$ref"`
+	Content map[string]mediaType `json:"content"`
+}
+
+type mediaType struct {
+	Schema *schema `json:"schema"`
+}
+
+const responseRefPrefix = "#/components/responses/"
+
+// Validator checks requests and responses against the OpenAPI document.
+type Validator struct {
+	doc         *document
+	report      func(r *http.Request, err error)
+	maxBodySize int64
+}
+
+// DefaultMaxBodySize is the largest request body in bytes the middleware reads
+// to check.
+const DefaultMaxBodySize = 10 << 20 // 10MB
+
+type Option func(*Validator)
+
+// WithReporter is told about every request or response that does not match
+// the document, instead of having it logged.
+func WithReporter(report func(r *http.Request, err error)) Option {
+	return func(v *Validator) {
+		v.report = report
+	}
+}
+
+// WithMaxBodySize sets the largest request body in bytes the middleware reads
+// to check. It should be no smaller than the largest body a handler accepts.
+func WithMaxBodySize(size int64) Option {
+	return func(v *Validator) {
+		v.maxBodySize = size
+	}
+}
+
+func New(options ...Option) (*Validator, error) {
+	var doc document
+	if err := json.Unmarshal(spec, &doc); err != nil {
+		return nil, fmt.Errorf("invalid OpenAPI document: %w", err)
+	}
+	if err := doc.compile(); err != nil {
+		return nil, fmt.Errorf("invalid OpenAPI document: %w", err)
+	}
+
+	v := &Validator{
+		doc: &doc,
+		report: func(r *http.Request, err error) {
+			slog.ErrorContext(r.Context(), "Request does not match the OpenAPI document", "method", r.Method, "path", r.URL.Path, "error", err)
+		},
+		maxBodySize: DefaultMaxBodySize,
+	}
+	for _, option := range options {
+		option(v)
+	}
+	return v, nil
+}
+
+// compile resolves every reference of the document.
+func (d *document) compile() error {
+	for name, s := range d.Components.Schemas {
+		if err := s.compile(d.Components.Schemas); err != nil {
+			return fmt.Errorf("schema %s: %w", name, err)
+		}
+	}
+	for path, operations := range d.Paths {
+		for method, op := range operations {
+			if err := d.compileOperation(op); err != nil {
+				return fmt.Errorf("%s %s: %w", strings.ToUpper(method), path, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (d *document) compileOperation(op *operation) error {
+	if op.RequestBody != nil {
+		for _, media := range op.RequestBody.Content {
+			if err := media.Schema.compile(d.Components.Schemas); err != nil {
+				return err
+			}
+		}
+	}
+	for status, resp := range op.Responses {
+		if resp.Ref != "" {
+			target, ok := d.Components.Responses[strings.TrimPrefix(resp.Ref, responseRefPrefix)]
+			if !strings.HasPrefix(resp.Ref, responseRefPrefix) || !ok {
+				return fmt.Errorf("response %s: unknown response %s", status, resp.Ref)
+			}
+			op.Responses[status] = target
+			resp = target
+		}
+		for _, media := range resp.Content {
+			if err := media.Schema.compile(d.Components.Schemas); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// operation finds the operation of a route pattern such as
+// "GET /receipts/{id}".
+func (v *Validator) operation(pattern string) (*operation, error) {
+	method, path, _ := strings.Cut(pattern, " ")
+	op, ok := v.doc.Paths[path][strings.ToLower(method)]
+	if !ok {
+		return nil, fmt.Errorf("%s is not documented", pattern)
+	}
+	return op, nil
+}
+
+// ValidateRequest checks a request body sent to the route pattern.
+func (v *Validator) ValidateRequest(pattern, contentType string, body []byte) error {
+	op, err := v.operation(pattern)
+	if err != nil {
+		return err
+	}
+	if op.RequestBody == nil {
+		return nil
+	}
+	return validateContent("request", op.RequestBody.Content, contentType, body)
+}
+
+// ValidateResponse checks a response of the route pattern.
+func (v *Validator) ValidateResponse(pattern string, status int, contentType string, body []byte) error {
+	op, err := v.operation(pattern)
+	if err != nil {
+		return err
+	}
+	resp, ok := op.Responses[strconv.Itoa(status)]
+	if !ok {
+		return fmt.Errorf("%s does not document status %d", pattern, status)
+	}
+	if len(resp.Content) == 0 {
+		if len(bytes.TrimSpace(body)) > 0 {
+			return fmt.Errorf("response with status %d must have no body", status)
+		}
+		return nil
+	}
+	return validateContent(fmt.Sprintf("response with status %d", status), resp.Content, contentType, body)
+}
+
+// validateContent checks a body of the content type against the schema
+// documented for it. Bodies that are not JSON are only checked for a
+// documented content type.
+func validateContent(what string, content map[string]mediaType, contentType string, body []byte) error {
+	mediaType := "application/json"
+	if contentType != "" {
+		parsed, _, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			return fmt.Errorf("%s has an invalid content type %q", what, contentType)
+		}
+		mediaType = parsed
+	}
+	media, ok := content[mediaType]
+	if !ok {
+		return fmt.Errorf("%s has an undocumented content type %s", what, mediaType)
+	}
+	if media.Schema == nil || !isJSON(mediaType) {
+		return nil
+	}
+	if err := validateJSON(media.Schema, body); err != nil {
+		return fmt.Errorf("%s does not match the schema:\n%w", what, err)
+	}
+	return nil
+}
+
+// isJSON reports whether the media type is JSON, such as application/json
+// or application/problem+json.
+func isJSON(mediaType string) bool {
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// Middleware checks the requests and responses of every documented route
+// while passing them through unchanged, and reports those that do not match
+// the document. A request body that breaks the document is only reported if a
+// handler accepts it, as handlers are expected to reject it themselves. The
+// route function returns the pattern of the route serving a request, or "" if
+// there is none.
+//
+// Responses are buffered to be checked, so this is meant for development and
+// tests.
+func (v *Validator) Middleware(next http.Handler, route func(*http.Request) string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pattern := route(r)
+		if pattern == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var requestErr error
+		if r.Body != nil && r.Body != http.NoBody {
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, v.maxBodySize))
+			if err != nil {
+				problem.WriteError(w, r, readError(err))
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			requestErr = v.ValidateRequest(pattern, r.Header.Get("content-type"), body)
+		}
+
+		buffer := &responseBuffer{header: w.Header()}
+		next.ServeHTTP(buffer, r)
+		status := buffer.Status()
+
+		if requestErr != nil && status < http.StatusBadRequest {
+			v.report(r, fmt.Errorf("%s accepted a request the document does not allow: %w", pattern, requestErr))
+		}
+		if err := v.ValidateResponse(pattern, status, w.Header().Get("content-type"), buffer.body.Bytes()); err != nil {
+			v.report(r, fmt.Errorf("%s: %w", pattern, err))
+		}
+
+		w.WriteHeader(status)
+		w.Write(buffer.body.Bytes())
+	})
+}
+
+// readError explains why the request body could not be read.
+func readError(err error) error {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return &apperr.TooLargeError{Reason: fmt.Sprintf("the request body must not be larger than %d bytes", maxBytesErr.Limit)}
+	}
+	return &apperr.ValidationError{Reason: "the request body could not be read"}
+}
+
+// responseBuffer holds a response until it has been checked.
+type responseBuffer struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *responseBuffer) Header() http.Header {
+	return b.header
+}
+
+func (b *responseBuffer) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+func (b *responseBuffer) Write(p []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	return b.body.Write(p)
+}
+
+func (b *responseBuffer) Status() int {
+	if b.status == 0 {
+		return http.StatusOK
+	}
+	return b.status
+}
@@ -0,0 +1,349 @@
+package openapi
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/lzchong/receipt-processor/internal/api/problem"
+)
+
+func TestHandler(t *testing.T) {
+	request := httptest.NewRequest("GET", "/openapi.json", nil)
+	response := httptest.NewRecorder()
+	Handler().ServeHTTP(response, request)
+
+	if got := response.Header().Get("content-type"); got != "application/json" {
+		t.Errorf("expected content-type application/json, but got %s", got)
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(response.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("expected a JSON document, but got %v", err)
+	}
+	if got := doc["openapi"]; got != "3.0.3" {
+		t.Errorf("expected OpenAPI version 3.0.3, but got %v", got)
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New(); err != nil {
+		t.Fatalf("expected the document to be valid, but got %v", err)
+	}
+}
+
+func TestValidator_ValidateRequest(t *testing.T) {
+	validator, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := map[string]struct {
+		pattern     string
+		contentType string
+		body        string
+		want        string
+	}{
+		"valid": {
+			pattern: "POST /receipts/process",
+			body:    `{"retailer": "Target", "purchaseDate": "2022-01-01", "purchaseTime": "13:01", "items": [{"shortDescription": "Mountain Dew 12PK", "price": "6.49"}], "total": "6.49"}`,
+		},
+		"content type with parameters": {
+			pattern:     "POST /admin/recalculate",
+			contentType: "application/json; charset=utf-8",
+			body:        `{"version": "v2"}`,
+		},
+		"ndjson is not checked": {
+			pattern:     "POST /receipts/batch",
+			contentType: "application/x-ndjson",
+			body:        "not json",
+		},
+		"missing property": {
+			pattern: "POST /receipts/process",
+			body:    `{"retailer": "Target", "purchaseDate": "2022-01-01", "purchaseTime": "13:01", "items": [{"shortDescription": "Mountain Dew 12PK", "price": "6.49"}]}`,
+			want:    `body: missing required property "total"`,
+		},
+		"nested pattern": {
+			pattern: "POST /receipts/process",
+			body:    `{"retailer": "Target", "purchaseDate": "2022-01-01", "purchaseTime": "13:01", "items": [{"shortDescription": "Mountain Dew 12PK", "price": "6.5"}], "total": "6.49"}`,
+			want:    `/items/0/price: "6.5" does not match the pattern`,
+		},
+		"unknown property": {
+			pattern: "POST /admin/recalculate",
+			body:    `{"version": "v2", "dryRun": true}`,
+			want:    `body: unknown property "dryRun"`,
+		},
+		"wrong type": {
+			pattern: "POST /admin/recalculate",
+			body:    `{"version": "v2", "commit": "yes"}`,
+			want:    "/commit: must be a boolean, not a string",
+		},
+		"too few items": {
+			pattern: "POST /receipts/process",
+			body:    `{"retailer": "Target", "purchaseDate": "2022-01-01", "purchaseTime": "13:01", "items": [], "total": "6.49"}`,
+			want:    "/items: must have at least 1 items, but has 0",
+		},
+		"malformed": {
+			pattern: "POST /admin/recalculate",
+			body:    `{"version": `,
+			want:    "body is not valid JSON",
+		},
+		"undocumented content type": {
+			pattern:     "POST /admin/recalculate",
+			contentType: "text/plain",
+			body:        "v2",
+			want:        "undocumented content type text/plain",
+		},
+		"undocumented route": {
+			pattern: "DELETE /receipts/{id}",
+			want:    "DELETE /receipts/{id} is not documented",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			err := validator.ValidateRequest(tc.pattern, tc.contentType, []byte(tc.body))
+			assertError(t, err, tc.want)
+		})
+	}
+}
+
+func TestValidator_ValidateResponse(t *testing.T) {
+	validator, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := map[string]struct {
+		pattern     string
+		status      int
+		contentType string
+		body        string
+		want        string
+	}{
+		"valid": {
+			pattern:     "GET /receipts/{id}/points",
+			status:      http.StatusOK,
+			contentType: "application/json",
+			body:        `{"points": 32}`,
+		},
+		"problem": {
+			pattern:     "GET /receipts/{id}/points",
+			status:      http.StatusNotFound,
+			contentType: "application/problem+json",
+			body:        `{"type": "about:blank", "title": "Not Found", "status": 404, "detail": "No receipt found for that ID.", "instance": "/receipts/abc/points"}`,
+		},
+		"metrics": {
+			pattern:     "GET /metrics",
+			status:      http.StatusOK,
+			contentType: "text/plain; version=0.0.4; charset=utf-8",
+			body:        "http_requests_total 1\n",
+		},
+		"undocumented status": {
+			pattern:     "GET /receipts/{id}/points",
+			status:      http.StatusTeapot,
+			contentType: "application/json",
+			body:        `{}`,
+			want:        "GET /receipts/{id}/points does not document status 418",
+		},
+		"undocumented field": {
+			pattern:     "GET /receipts/{id}/points",
+			status:      http.StatusOK,
+			contentType: "application/json",
+			body:        `{"points": 32, "ruleVersion": "v1"}`,
+			want:        `body: unknown property "ruleVersion"`,
+		},
+		"not an integer": {
+			pattern:     "GET /receipts/{id}/points",
+			status:      http.StatusOK,
+			contentType: "application/json",
+			body:        `{"points": 32.5}`,
+			want:        "/points: 32.5 is not a valid integer",
+		},
+		"wrong content type": {
+			pattern:     "GET /receipts/{id}/points",
+			status:      http.StatusNotFound,
+			contentType: "text/plain; charset=utf-8",
+			body:        "404 page not found",
+			want:        "undocumented content type text/plain",
+		},
+		"unknown enum value": {
+			pattern:     "GET /readyz",
+			status:      http.StatusServiceUnavailable,
+			contentType: "application/json",
+			body:        `{"status": "degraded"}`,
+			want:        `/status: "degraded" must be one of ok, fail, shutting_down`,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			err := validator.ValidateResponse(tc.pattern, tc.status, tc.contentType, []byte(tc.body))
+			assertError(t, err, tc.want)
+		})
+	}
+}
+
+func TestValidator_Middleware(t *testing.T) {
+	var lock sync.Mutex
+	var reported []error
+	validator, err := New(WithReporter(func(r *http.Request, err error) {
+		lock.Lock()
+		defer lock.Unlock()
+		reported = append(reported, err)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /admin/recalculate", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if !strings.Contains(string(body), "version") {
+			http.Error(w, "missing version", http.StatusBadRequest)
+			return
+		}
+		// The response lacks most of the documented properties.
+		w.Header().Set("content-type", "application/json")
+		w.Header().Set("x-handled", "true")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"version": "v2"}`))
+	})
+	handler := validator.Middleware(mux, func(r *http.Request) string {
+		_, pattern := mux.Handler(r)
+		return pattern
+	})
+
+	request := httptest.NewRequest("POST", "/admin/recalculate", strings.NewReader(`{"version": "v2", "force": true}`))
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, request)
+
+	if got := response.Code; got != http.StatusOK {
+		t.Errorf("expected status %d, but got %d", http.StatusOK, got)
+	}
+	if got := response.Body.String(); got != `{"version": "v2"}` {
+		t.Errorf("expected the response to pass through, but got %s", got)
+	}
+	if got := response.Header().Get("x-handled"); got != "true" {
+		t.Errorf("expected the headers to pass through, but got %q", got)
+	}
+	if len(reported) != 2 {
+		t.Fatalf("expected the request and the response to be reported, but got %v", reported)
+	}
+	assertError(t, reported[0], `accepted a request the document does not allow: request does not match the schema:
+body: unknown property "force"`)
+	assertError(t, reported[1], `body: missing required property "committed"`)
+
+	// A rejected request is what the document expects, but the plain text
+	// error is not.
+	reported = nil
+	request = httptest.NewRequest("POST", "/admin/recalculate", strings.NewReader(`{}`))
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+	if len(reported) != 1 {
+		t.Fatalf("expected the response to be reported, but got %v", reported)
+	}
+	assertError(t, reported[0], "response with status 400 has an undocumented content type text/plain")
+
+	// Unknown routes are left alone.
+	reported = nil
+	request = httptest.NewRequest("GET", "/invalid/route", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+	if len(reported) != 0 {
+		t.Errorf("expected nothing reported, but got %v", reported)
+	}
+}
+
+func TestValidator_Middleware_BodyTooLarge(t *testing.T) {
+	validator, err := New(WithMaxBodySize(8))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handled := false
+	handler := validator.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handled = true
+	}), func(r *http.Request) string {
+		return "POST /admin/recalculate"
+	})
+
+	request := httptest.NewRequest("POST", "/admin/recalculate", strings.NewReader(`{"version": "v2"}`))
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, request)
+
+	if handled {
+		t.Error("expected the request to be rejected before the handler, but it was handled")
+	}
+	if got := response.Code; got != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected status %d, but got %d", http.StatusRequestEntityTooLarge, got)
+	}
+	if got, want := response.Header().Get("content-type"), "application/problem+json"; got != want {
+		t.Errorf("expected content type %q, but got %q", want, got)
+	}
+}
+
+func TestValidator_Middleware_Abandoned(t *testing.T) {
+	testCases := map[string]struct {
+		err        error
+		wantStatus int
+	}{
+		"timed out": {
+			err:        context.DeadlineExceeded,
+			wantStatus: http.StatusServiceUnavailable,
+		},
+		"canceled": {
+			err:        context.Canceled,
+			wantStatus: problem.StatusClientClosedRequest,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			var reported []error
+			validator, err := New(WithReporter(func(r *http.Request, err error) {
+				reported = append(reported, err)
+			}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			handler := validator.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				problem.WriteError(w, r, tc.err)
+			}), func(r *http.Request) string {
+				return "POST /receipts/score"
+			})
+
+			request := httptest.NewRequest("POST", "/receipts/score", strings.NewReader(`{"retailer": "Target", "purchaseDate": "2022-01-01", "purchaseTime": "13:01", "items": [{"shortDescription": "Mountain Dew 12PK", "price": "6.49"}], "total": "6.49"}`))
+			response := httptest.NewRecorder()
+			handler.ServeHTTP(response, request)
+
+			if got := response.Code; got != tc.wantStatus {
+				t.Errorf("expected status %d, but got %d", tc.wantStatus, got)
+			}
+			if len(reported) != 0 {
+				t.Errorf("expected nothing reported, but got %v", reported)
+			}
+		})
+	}
+}
+
+func assertError(t *testing.T, err error, want string) {
+	t.Helper()
+	if want == "" {
+		if err != nil {
+			t.Errorf("expected no error, but got %v", err)
+		}
+		return
+	}
+	if err == nil {
+		t.Fatal("expected has error, but got nothing")
+	}
+	if got := err.Error(); !strings.Contains(got, want) {
+		t.Errorf("expected error containing %q, but got %q", want, got)
+	}
+}
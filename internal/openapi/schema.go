@@ -0,0 +1,210 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// schema is the subset of JSON Schema the spec uses. Other keywords, such as
+// format and example, are documentation only and are not checked.
+type schema struct {
+	Ref                  string             `json:"$ref"`
+	Type                 string             `json:"type"`
+	Properties           map[string]*schema `json:"properties"`
+	Required             []string           `json:"required"`
+	AdditionalProperties *bool              `json:"additionalProperties"`
+	Items                *schema            `json:"items"`
+	Pattern              string             `json:"pattern"`
+	Enum                 []string           `json:"enum"`
+	MinItems             *int               `json:"minItems"`
+	MaxLength            *int               `json:"maxLength"`
+	Minimum              *float64           `json:"minimum"`
+
+	pattern  *regexp.Regexp
+	resolved *schema
+}
+
+const schemaRefPrefix = "#/components/schemas/"
+
+// compile resolves references and compiles patterns, so that validating
+// cannot fail on a broken spec.
+func (s *schema) compile(schemas map[string]*schema) error {
+	if s == nil || s.resolved != nil {
+		return nil
+	}
+	s.resolved = s
+
+	if s.Ref != "" {
+		target, ok := schemas[strings.TrimPrefix(s.Ref, schemaRefPrefix)]
+		if !strings.HasPrefix(s.Ref, schemaRefPrefix) || !ok {
+			return fmt.Errorf("unknown schema %s", s.Ref)
+		}
+		s.resolved = target
+		return target.compile(schemas)
+	}
+
+	if s.Pattern != "" {
+		pattern, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern %s: %w", s.Pattern, err)
+		}
+		s.pattern = pattern
+	}
+	for _, property := range s.Properties {
+		if err := property.compile(schemas); err != nil {
+			return err
+		}
+	}
+	return s.Items.compile(schemas)
+}
+
+// validate checks a value decoded with json.Decoder.UseNumber against the
+// schema, adding an error for every violation found.
+func (s *schema) validate(value any, pointer string, errs *[]error) {
+	s = s.resolved
+	invalid := func(format string, args ...any) {
+		location := pointer
+		if location == "" {
+			location = "body"
+		}
+		*errs = append(*errs, fmt.Errorf("%s: %s", location, fmt.Sprintf(format, args...)))
+	}
+
+	switch s.Type {
+	case "object":
+		object, ok := value.(map[string]any)
+		if !ok {
+			invalid("must be an object, not %s", kind(value))
+			return
+		}
+		for _, name := range s.Required {
+			if _, ok := object[name]; !ok {
+				invalid("missing required property %q", name)
+			}
+		}
+		// Properties are checked in order so errors are reported consistently.
+		names := make([]string, 0, len(object))
+		for name := range object {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			v := object[name]
+			property, ok := s.Properties[name]
+			if !ok {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					invalid("unknown property %q", name)
+				}
+				continue
+			}
+			property.validate(v, pointer+"/"+escape(name), errs)
+		}
+
+	case "array":
+		array, ok := value.([]any)
+		if !ok {
+			invalid("must be an array, not %s", kind(value))
+			return
+		}
+		if s.MinItems != nil && len(array) < *s.MinItems {
+			invalid("must have at least %d items, but has %d", *s.MinItems, len(array))
+		}
+		if s.Items != nil {
+			for i, item := range array {
+				s.Items.validate(item, fmt.Sprintf("%s/%d", pointer, i), errs)
+			}
+		}
+
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			invalid("must be a string, not %s", kind(value))
+			return
+		}
+		if s.pattern != nil && !s.pattern.MatchString(str) {
+			invalid("%q does not match the pattern %s", str, s.Pattern)
+		}
+		if s.MaxLength != nil && utf8.RuneCountInString(str) > *s.MaxLength {
+			invalid("must not be longer than %d characters", *s.MaxLength)
+		}
+		if len(s.Enum) > 0 && !contains(s.Enum, str) {
+			invalid("%q must be one of %s", str, strings.Join(s.Enum, ", "))
+		}
+
+	case "integer", "number":
+		number, ok := value.(json.Number)
+		if !ok {
+			invalid("must be a %s, not %s", s.Type, kind(value))
+			return
+		}
+		f, err := number.Float64()
+		if s.Type == "integer" {
+			_, err = number.Int64()
+		}
+		if err != nil {
+			invalid("%s is not a valid %s", number, s.Type)
+			return
+		}
+		if s.Minimum != nil && f < *s.Minimum {
+			invalid("%s must not be less than %v", number, *s.Minimum)
+		}
+
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			invalid("must be a boolean, not %s", kind(value))
+		}
+	}
+}
+
+// kind names the JSON type of a decoded value.
+func kind(value any) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case map[string]any:
+		return "an object"
+	case []any:
+		return "an array"
+	case string:
+		return "a string"
+	case json.Number:
+		return "a number"
+	case bool:
+		return "a boolean"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+// escape encodes a property name as a JSON pointer token.
+func escape(name string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(name, "~", "~0"), "/", "~1")
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// validateJSON decodes the body and checks it against the schema.
+func validateJSON(s *schema, body []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return fmt.Errorf("body is not valid JSON: %w", err)
+	}
+	var errs []error
+	s.validate(value, "", &errs)
+	return errors.Join(errs...)
+}
@@ -0,0 +1,162 @@
+// Package ratelimit limits how often each client can make requests, using a
+// token bucket per client.
+package ratelimit
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lzchong/receipt-processor/internal/api/problem"
+	"github.com/lzchong/receipt-processor/internal/apperr"
+	"github.com/lzchong/receipt-processor/internal/auth"
+)
+
+// Limiter allows each client a burst of requests, refilled at a steady rate.
+type Limiter struct {
+	rate  float64
+	burst int
+	now   func() time.Time
+
+	lock      sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type Option func(*Limiter)
+
+// WithClock replaces time.Now, so tests can control time.
+func WithClock(now func() time.Time) Option {
+	return func(l *Limiter) {
+		l.now = now
+	}
+}
+
+// New returns a limiter allowing each client burst requests at once and rate
+// requests per second after that.
+func New(rate float64, burst int, options ...Option) *Limiter {
+	l := &Limiter{
+		rate:    rate,
+		burst:   burst,
+		now:     time.Now,
+		buckets: make(map[string]*bucket),
+	}
+	for _, option := range options {
+		option(l)
+	}
+	l.lastSweep = l.now()
+	return l
+}
+
+// Decision is the outcome of asking for a request.
+type Decision struct {
+	Allowed bool
+	// Limit is the size of the burst.
+	Limit int
+	// Remaining is the number of requests that could be made right now.
+	Remaining int
+	// RetryAfter is how long until the next request is allowed, if this one
+	// was not.
+	RetryAfter time.Duration
+	// Reset is how long until the full burst is available again.
+	Reset time.Duration
+}
+
+// Allow takes a token from the client's bucket if there is one.
+func (l *Limiter) Allow(client string) Decision {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &bucket{tokens: float64(l.burst), last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = math.Min(float64(l.burst), b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	d := Decision{Limit: l.burst}
+	if b.tokens >= 1 {
+		b.tokens--
+		d.Allowed = true
+	} else {
+		d.RetryAfter = l.duration(1 - b.tokens)
+	}
+	d.Remaining = int(b.tokens)
+	d.Reset = l.duration(float64(l.burst) - b.tokens)
+	return d
+}
+
+// Len returns the number of clients being tracked.
+func (l *Limiter) Len() int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return len(l.buckets)
+}
+
+// sweep forgets the buckets that have refilled, as they are no different from
+// new ones. It runs at most once per refill time, so the work is spread over
+// the requests. The caller must hold the lock.
+func (l *Limiter) sweep(now time.Time) {
+	refill := max(l.duration(float64(l.burst)), time.Second)
+	if now.Sub(l.lastSweep) < refill {
+		return
+	}
+	for client, b := range l.buckets {
+		if now.Sub(b.last) >= refill {
+			delete(l.buckets, client)
+		}
+	}
+	l.lastSweep = now
+}
+
+// duration returns how long it takes to refill the tokens.
+func (l *Limiter) duration(tokens float64) time.Duration {
+	return time.Duration(tokens / l.rate * float64(time.Second))
+}
+
+// Client identifies the client of a request by the name of its API key, or by
+// its IP address if it was not authenticated.
+func Client(r *http.Request) string {
+	if name := auth.KeyName(r.Context()); name != "" {
+		return "key:" + name
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// Limit serves the request only if its client is within the limit, answering
+// 429 Too Many Requests otherwise. Every response carries the RateLimit-Limit,
+// RateLimit-Remaining, and RateLimit-Reset headers.
+func (l *Limiter) Limit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d := l.Allow(Client(r))
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(d.Limit))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(d.Remaining))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(seconds(d.Reset)))
+		if !d.Allowed {
+			problem.WriteError(w, r, &apperr.RateLimitedError{RetryAfter: d.RetryAfter})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// seconds rounds the duration up to whole seconds.
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,150 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lzchong/receipt-processor/internal/auth"
+)
+
+// clock is a time that only moves when the test says so.
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	return c.now
+}
+
+func (c *clock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newClock() *clock {
+	return &clock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func TestLimiter_Allow(t *testing.T) {
+	c := newClock()
+	limiter := New(2, 3, WithClock(c.Now))
+
+	for i := 2; i >= 0; i-- {
+		d := limiter.Allow("a")
+		if !d.Allowed || d.Remaining != i {
+			t.Fatalf("expected allowed with %d remaining, but got %+v", i, d)
+		}
+	}
+
+	d := limiter.Allow("a")
+	if d.Allowed {
+		t.Fatalf("expected the burst to be used up, but got %+v", d)
+	}
+	if d.RetryAfter != 500*time.Millisecond {
+		t.Errorf("expected retry after 500ms, but got %v", d.RetryAfter)
+	}
+	if d.Reset != 1500*time.Millisecond {
+		t.Errorf("expected reset after 1.5s, but got %v", d.Reset)
+	}
+
+	if d := limiter.Allow("b"); !d.Allowed {
+		t.Errorf("expected another client to be allowed, but got %+v", d)
+	}
+
+	c.Advance(500 * time.Millisecond)
+	if d := limiter.Allow("a"); !d.Allowed || d.Remaining != 0 {
+		t.Errorf("expected one refilled request allowed, but got %+v", d)
+	}
+
+	// The bucket never holds more than the burst.
+	c.Advance(time.Hour)
+	if d := limiter.Allow("a"); !d.Allowed || d.Remaining != 2 {
+		t.Errorf("expected a full burst, but got %+v", d)
+	}
+}
+
+func TestLimiter_Evict(t *testing.T) {
+	c := newClock()
+	limiter := New(10, 10, WithClock(c.Now))
+
+	limiter.Allow("a")
+	limiter.Allow("b")
+	c.Advance(500 * time.Millisecond)
+	limiter.Allow("b")
+	if got := limiter.Len(); got != 2 {
+		t.Fatalf("expected 2 clients, but got %d", got)
+	}
+
+	// a has refilled and is forgotten, b is still refilling.
+	c.Advance(700 * time.Millisecond)
+	limiter.Allow("c")
+	if got := limiter.Len(); got != 2 {
+		t.Errorf("expected 2 clients, but got %d", got)
+	}
+
+	c.Advance(time.Hour)
+	limiter.Allow("d")
+	if got := limiter.Len(); got != 1 {
+		t.Errorf("expected 1 client, but got %d", got)
+	}
+}
+
+func TestLimiter_Limit(t *testing.T) {
+	c := newClock()
+	handler := New(1, 2, WithClock(c.Now)).Limit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	testCases := []struct {
+		status     int
+		remaining  string
+		reset      string
+		retryAfter string
+	}{
+		{http.StatusOK, "1", "1", ""},
+		{http.StatusOK, "0", "2", ""},
+		{http.StatusTooManyRequests, "0", "2", "1"},
+	}
+	for _, tc := range testCases {
+		request := httptest.NewRequest("POST", "/receipts/process", nil)
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+
+		if got := response.Code; got != tc.status {
+			t.Errorf("expected status %d, but got %d", tc.status, got)
+		}
+		for header, want := range map[string]string{
+			"RateLimit-Limit":     "2",
+			"RateLimit-Remaining": tc.remaining,
+			"RateLimit-Reset":     tc.reset,
+			"Retry-After":         tc.retryAfter,
+		} {
+			if got := response.Header().Get(header); got != want {
+				t.Errorf("expected %s %q, but got %q", header, want, got)
+			}
+		}
+	}
+}
+
+func TestClient(t *testing.T) {
+	keys, err := auth.New(auth.WithKeys(auth.Key{Name: "ci", Hash: auth.HashKey("secret"), Scopes: []string{auth.ScopeReceiptsRead}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got string
+	handler := keys.Require(auth.ScopeReceiptsRead, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = Client(r)
+	}))
+	request := httptest.NewRequest("GET", "/receipts/abc/points", nil)
+	request.Header.Set(auth.KeyHeader, "secret")
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+	if got != "key:ci" {
+		t.Errorf("expected client key:ci, but got %s", got)
+	}
+
+	request = httptest.NewRequest("GET", "/receipts/abc/points", nil)
+	request.RemoteAddr = "192.0.2.7:41234"
+	if got := Client(request); got != "ip:192.0.2.7" {
+		t.Errorf("expected client ip:192.0.2.7, but got %s", got)
+	}
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/lzchong/receipt-processor/internal/api/problem"
+	"github.com/lzchong/receipt-processor/internal/logging"
+	"github.com/lzchong/receipt-processor/internal/metrics"
+)
+
+// statusRecorder remembers the status and the number of body bytes written by
+// a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// Status returns the written status, which is 200 if the handler wrote
+// nothing.
+func (r *statusRecorder) Status() int {
+	if r.status == 0 {
+		return http.StatusOK
+	}
+	return r.status
+}
+
+// unmatchedRoute labels requests that no route matched, so unknown paths do
+// not each create their own series.
+const unmatchedRoute = "unmatched"
+
+// route returns the pattern of the route that serves the request.
+func route(mux *http.ServeMux, r *http.Request) string {
+	if _, pattern := mux.Handler(r); pattern != "" {
+		return pattern
+	}
+	return unmatchedRoute
+}
+
+// instrument counts and times every request by route and status.
+func instrument(next http.Handler, mux *http.ServeMux, m *metrics.Metrics) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r)
+		m.ObserveRequest(route(mux, r), recorder.Status(), time.Since(start))
+	})
+}
+
+// logRequests gives every request an ID, taken from the X-Request-ID header
+// when the client sent a usable one, and echoes it in the response. The ID is
+// carried by the request context. Each request is logged once it is served,
+// unless logger is nil.
+func logRequests(next http.Handler, mux *http.ServeMux, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := r.Header.Get(logging.RequestIDHeader)
+		if !logging.ValidRequestID(id) {
+			id = logging.NewRequestID()
+		}
+		w.Header().Set(logging.RequestIDHeader, id)
+		r = r.WithContext(logging.WithRequestID(r.Context(), id))
+
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r)
+		if logger == nil {
+			return
+		}
+
+		status := recorder.Status()
+		level := slog.LevelInfo
+		switch {
+		case status >= http.StatusInternalServerError:
+			level = slog.LevelError
+		case status >= http.StatusBadRequest:
+			level = slog.LevelWarn
+		}
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("route", route(mux, r)),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", status),
+			slog.Duration("latency", time.Since(start)),
+			slog.Int("bytes", recorder.bytes),
+		}
+		if receiptID := logging.ReceiptID(r.Context()); receiptID != "" {
+			attrs = append(attrs, slog.String("receipt_id", receiptID))
+		}
+		logger.LogAttrs(r.Context(), level, "Request served", attrs...)
+	})
+}
+
+// recoverPanics turns a panic while serving a request into a 500 response, so
+// the bug is logged with its stack instead of dropping the connection. The
+// panic is counted if m is not nil. http.ErrAbortHandler is let through, as it
+// is the way to abort a response on purpose. A panic after the response has
+// started is logged and then turned into http.ErrAbortHandler, so the client
+// sees the response fail rather than end early as if it were complete.
+func recoverPanics(next http.Handler, mux *http.ServeMux, logger *slog.Logger, m *metrics.Metrics) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+
+			route := route(mux, r)
+			logger.ErrorContext(r.Context(), "Recovered from panic",
+				"route", route,
+				"panic", fmt.Sprint(v),
+				"stack", string(debug.Stack()),
+			)
+			if m != nil {
+				m.ObservePanic(route)
+			}
+			// Once the response has started it can only be cut short.
+			if recorder.status != 0 {
+				panic(http.ErrAbortHandler)
+			}
+			problem.Error(recorder, r, "The request could not be completed.", http.StatusInternalServerError)
+		}()
+		next.ServeHTTP(recorder, r)
+	})
+}
@@ -0,0 +1,211 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/lzchong/receipt-processor/internal/api/problem"
+	"github.com/lzchong/receipt-processor/internal/logging"
+	"github.com/lzchong/receipt-processor/internal/metrics"
+)
+
+func TestRouter_RequestID(t *testing.T) {
+	router := NewRouter(&stubHandler{})
+
+	testCases := map[string]struct {
+		header    string
+		propagate bool
+	}{
+		"propagated":          {"req-42", true},
+		"generated":           {"", false},
+		"invalid is replaced": {"req 42", false},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			request, err := http.NewRequest("GET", "/healthz", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.header != "" {
+				request.Header.Set(logging.RequestIDHeader, tc.header)
+			}
+
+			response := httptest.NewRecorder()
+			router.ServeHTTP(response, request)
+
+			got := response.Header().Get(logging.RequestIDHeader)
+			if tc.propagate && got != tc.header {
+				t.Errorf("expected request ID %s, but got %q", tc.header, got)
+			}
+			if !tc.propagate && (got == tc.header || !logging.ValidRequestID(got)) {
+				t.Errorf("expected a generated request ID, but got %q", got)
+			}
+		})
+	}
+}
+
+func TestRouter_Logger(t *testing.T) {
+	var buffer bytes.Buffer
+	logger, err := logging.New(&buffer, logging.FormatJSON, slog.LevelInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logging.SetReceiptID(r.Context(), r.PathValue("id"))
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("missing"))
+	})
+	router := NewRouter(&receiptHandler{points: handler}, WithLogger(logger))
+
+	request, err := http.NewRequest("GET", "/receipts/7fb1377b-b223-49d9-a31a-5a02701dd310/points", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Set(logging.RequestIDHeader, "req-42")
+	router.ServeHTTP(httptest.NewRecorder(), request)
+
+	var record map[string]any
+	if err := json.Unmarshal(buffer.Bytes(), &record); err != nil {
+		t.Fatalf("expected one JSON record, but got %s", buffer.String())
+	}
+	for key, want := range map[string]any{
+		"level":      "WARN",
+		"msg":        "Request served",
+		"method":     "GET",
+		"route":      "GET /receipts/{id}/points",
+		"path":       "/receipts/7fb1377b-b223-49d9-a31a-5a02701dd310/points",
+		"status":     float64(http.StatusNotFound),
+		"bytes":      float64(len("missing")),
+		"receipt_id": "7fb1377b-b223-49d9-a31a-5a02701dd310",
+		"request_id": "req-42",
+	} {
+		if got := record[key]; got != want {
+			t.Errorf("expected %s %v, but got %v", key, want, got)
+		}
+	}
+	if _, ok := record["latency"]; !ok {
+		t.Error("expected latency to be logged, but got nothing")
+	}
+}
+
+func TestRouter_Recover(t *testing.T) {
+	var buffer bytes.Buffer
+	logger, err := logging.New(&buffer, logging.FormatJSON, slog.LevelInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := metrics.New()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("rule exploded")
+	})
+	router := NewRouter(&receiptHandler{points: handler}, WithLogger(logger), WithMetrics(m))
+
+	request, err := http.NewRequest("GET", "/receipts/7fb1377b-b223-49d9-a31a-5a02701dd310/points", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Set(logging.RequestIDHeader, "req-42")
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, request)
+
+	assertStatus(t, response, http.StatusInternalServerError)
+	if got := response.Header().Get("content-type"); got != problem.ContentType {
+		t.Errorf("expected content-type %s, but got %s", problem.ContentType, got)
+	}
+	if got := response.Body.String(); strings.Contains(got, "rule exploded") {
+		t.Errorf("expected the panic to stay out of the response, but got %s", got)
+	}
+
+	records := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+	if len(records) != 2 {
+		t.Fatalf("expected the panic and the request to be logged, but got %s", buffer.String())
+	}
+	var record map[string]any
+	if err := json.Unmarshal([]byte(records[0]), &record); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]any{
+		"level":      "ERROR",
+		"msg":        "Recovered from panic",
+		"panic":      "rule exploded",
+		"request_id": "req-42",
+	} {
+		if got := record[key]; got != want {
+			t.Errorf("expected %s %v, but got %v", key, want, got)
+		}
+	}
+	if stack, _ := record["stack"].(string); !strings.Contains(stack, "TestRouter_Recover") {
+		t.Errorf("expected the stack of the panic, but got %q", stack)
+	}
+
+	var exposition bytes.Buffer
+	m.Registry().Write(&exposition)
+	for _, want := range []string{
+		`http_panics_total{route="GET /receipts/{id}/points"} 1`,
+		`http_requests_total{route="GET /receipts/{id}/points",status="500"} 1`,
+	} {
+		if got := exposition.String(); !strings.Contains(got, want+"\n") {
+			t.Errorf("expected metrics to contain %s, but got\n%s", want, got)
+		}
+	}
+}
+
+func TestRouter_RecoverAfterWrite(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		panic("rule exploded")
+	})
+	router := NewRouter(&receiptHandler{points: handler}, WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+
+	request, err := http.NewRequest("GET", "/receipts/7fb1377b-b223-49d9-a31a-5a02701dd310/points", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := httptest.NewRecorder()
+	defer func() {
+		if got := recover(); got != http.ErrAbortHandler {
+			t.Errorf("expected panic %v, but got %v", http.ErrAbortHandler, got)
+		}
+		assertStatus(t, response, http.StatusOK)
+		if got := response.Body.String(); got != "" {
+			t.Errorf("expected nothing more written, but got %s", got)
+		}
+	}()
+	router.ServeHTTP(response, request)
+}
+
+func TestRouter_RecoverAbort(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	})
+	router := NewRouter(&receiptHandler{points: handler})
+
+	request, err := http.NewRequest("GET", "/receipts/7fb1377b-b223-49d9-a31a-5a02701dd310/points", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if got := recover(); got != http.ErrAbortHandler {
+			t.Errorf("expected panic %v, but got %v", http.ErrAbortHandler, got)
+		}
+	}()
+	router.ServeHTTP(httptest.NewRecorder(), request)
+}
+
+// receiptHandler serves the points route with a handler of the test's own.
+type receiptHandler struct {
+	stubHandler
+	points http.HandlerFunc
+}
+
+func (h *receiptHandler) Points(w http.ResponseWriter, r *http.Request) {
+	h.points(w, r)
+}
@@ -2,12 +2,164 @@ package server
 
 import (
 	"github.com/lzchong/receipt-processor/internal/api/receipt"
+	"github.com/lzchong/receipt-processor/internal/auth"
+	"github.com/lzchong/receipt-processor/internal/health"
+	"github.com/lzchong/receipt-processor/internal/metrics"
+	"github.com/lzchong/receipt-processor/internal/openapi"
+	"github.com/lzchong/receipt-processor/internal/ratelimit"
+	"log/slog"
 	"net/http"
 )
 
-func NewRouter(receiptHandler receipt.Handler) http.Handler {
+type routerConfig struct {
+	health  *health.Checker
+	metrics *metrics.Metrics
+	logger  *slog.Logger
+	keys    *auth.Keys
+	reads   *ratelimit.Limiter
+	writes  *ratelimit.Limiter
+	scores  *ratelimit.Limiter
+	batches *ratelimit.Limiter
+	ips     *ratelimit.Limiter
+	openapi *openapi.Validator
+}
+
+type RouterOption func(*routerConfig)
+
+// WithHealth serves the probes of the checker. Without it the readiness probe
+// has no checks to run.
+func WithHealth(checker *health.Checker) RouterOption {
+	return func(c *routerConfig) {
+		c.health = checker
+	}
+}
+
+// WithMetrics serves the metrics at /metrics and records every request.
+func WithMetrics(m *metrics.Metrics) RouterOption {
+	return func(c *routerConfig) {
+		c.metrics = m
+	}
+}
+
+// WithLogger logs every request once it is served. Requests are given an ID
+// whether or not they are logged.
+func WithLogger(logger *slog.Logger) RouterOption {
+	return func(c *routerConfig) {
+		c.logger = logger
+	}
+}
+
+// WithAuth requires an API key with the scope of the route on every receipt
+// and admin route. The probes and metrics stay open to the infrastructure that
+// scrapes them.
+func WithAuth(keys *auth.Keys) RouterOption {
+	return func(c *routerConfig) {
+		c.keys = keys
+	}
+}
+
+// WithRateLimits limits how often each client can read and write. Either may
+// be nil to leave those requests unlimited. Clients are told apart by API key,
+// or by IP address without WithAuth.
+func WithRateLimits(reads, writes *ratelimit.Limiter) RouterOption {
+	return func(c *routerConfig) {
+		c.reads = reads
+		c.writes = writes
+	}
+}
+
+// WithScoreRateLimit limits how often each client can score a receipt without
+// storing it. Scores are counted apart from writes, so estimating points does
+// not use up a client's writes. A nil limiter leaves scores unlimited.
+func WithScoreRateLimit(scores *ratelimit.Limiter) RouterOption {
+	return func(c *routerConfig) {
+		c.scores = scores
+	}
+}
+
+// WithBatchRateLimit limits how often each client can submit a batch. A batch
+// holds many receipts, so batches are counted apart from single writes rather
+// than each costing one write. A nil limiter leaves batches unlimited.
+func WithBatchRateLimit(batches *ratelimit.Limiter) RouterOption {
+	return func(c *routerConfig) {
+		c.batches = batches
+	}
+}
+
+// WithIPRateLimit limits how often each IP address can call the receipt and
+// admin routes. It applies before authentication, so requests with a missing
+// or wrong API key are limited too.
+func WithIPRateLimit(ips *ratelimit.Limiter) RouterOption {
+	return func(c *routerConfig) {
+		c.ips = ips
+	}
+}
+
+// WithOpenAPIValidation checks every request and response against the OpenAPI
+// document and reports any that do not match. It buffers every response, so
+// it is meant for development and tests.
+func WithOpenAPIValidation(validator *openapi.Validator) RouterOption {
+	return func(c *routerConfig) {
+		c.openapi = validator
+	}
+}
+
+func NewRouter(receiptHandler receipt.Handler, options ...RouterOption) http.Handler {
+	config := &routerConfig{health: health.New()}
+	for _, option := range options {
+		option(config)
+	}
+
 	mux := http.NewServeMux()
-	mux.HandleFunc("GET /receipts/{id}/points", receiptHandler.Points)
-	mux.HandleFunc("POST /receipts/process", receiptHandler.Process)
-	return mux
+	mux.HandleFunc("GET /healthz", config.health.Live)
+	mux.HandleFunc("GET /readyz", config.health.Ready)
+	mux.Handle("GET /openapi.json", openapi.Handler())
+
+	// Requests are authenticated before the route's limit applies, so that
+	// limit is per key rather than per address. The address limit applies
+	// first, as no key is known yet.
+	protect := func(scope string, limiter *ratelimit.Limiter, handler http.HandlerFunc) http.Handler {
+		var h http.Handler = handler
+		if limiter != nil {
+			h = limiter.Limit(h)
+		}
+		if config.keys != nil {
+			h = config.keys.Require(scope, h)
+		}
+		if config.ips != nil {
+			h = config.ips.Limit(h)
+		}
+		return h
+	}
+	mux.Handle("GET /receipts/{id}", protect(auth.ScopeReceiptsRead, config.reads, receiptHandler.Receipt))
+	mux.Handle("GET /receipts/{id}/points", protect(auth.ScopeReceiptsRead, config.reads, receiptHandler.Points))
+	mux.Handle("GET /receipts/{id}/points/breakdown", protect(auth.ScopeReceiptsRead, config.reads, receiptHandler.PointsBreakdown))
+	mux.Handle("POST /receipts/process", protect(auth.ScopeReceiptsWrite, config.writes, receiptHandler.Process))
+	// Scoring stores nothing, so a key that may only read can score receipts.
+	mux.Handle("POST /receipts/score", protect(auth.ScopeReceiptsRead, config.scores, receiptHandler.Score))
+	mux.Handle("POST /receipts/batch", protect(auth.ScopeReceiptsWrite, config.batches, receiptHandler.ProcessBatch))
+	mux.Handle("POST /admin/recalculate", protect(auth.ScopeAdmin, config.writes, receiptHandler.Recalculate))
+	mux.Handle("GET /admin/receipts/flagged", protect(auth.ScopeAdmin, config.reads, receiptHandler.FlaggedReceipts))
+
+	if config.metrics != nil {
+		mux.Handle("GET /metrics", config.metrics.Handler())
+	}
+
+	// Panics are logged even when requests are not.
+	logger := config.logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	var handler http.Handler = mux
+	if config.openapi != nil {
+		handler = config.openapi.Middleware(handler, func(r *http.Request) string {
+			_, pattern := mux.Handler(r)
+			return pattern
+		})
+	}
+	handler = recoverPanics(handler, mux, logger, config.metrics)
+	if config.metrics != nil {
+		handler = instrument(handler, mux, config.metrics)
+	}
+	return logRequests(handler, mux, config.logger)
 }
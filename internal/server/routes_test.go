@@ -1,9 +1,20 @@
 package server
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+
+	"github.com/lzchong/receipt-processor/internal/api/receipt"
+	"github.com/lzchong/receipt-processor/internal/auth"
+	"github.com/lzchong/receipt-processor/internal/health"
+	"github.com/lzchong/receipt-processor/internal/metrics"
+	"github.com/lzchong/receipt-processor/internal/openapi"
+	"github.com/lzchong/receipt-processor/internal/ratelimit"
 )
 
 type stubHandler struct{}
@@ -12,10 +23,34 @@ func (h *stubHandler) Points(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+func (h *stubHandler) PointsBreakdown(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
+
 func (h *stubHandler) Process(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusAccepted)
 }
 
+func (h *stubHandler) Score(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *stubHandler) ProcessBatch(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *stubHandler) Recalculate(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *stubHandler) FlaggedReceipts(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *stubHandler) Receipt(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
+
 func TestRouter(t *testing.T) {
 	handler := &stubHandler{}
 	router := NewRouter(handler)
@@ -25,10 +60,19 @@ func TestRouter(t *testing.T) {
 		path   string
 		want   int
 	}{
+		"liveness":                {"GET", "/healthz", http.StatusOK},
+		"readiness":               {"GET", "/readyz", http.StatusOK},
+		"openapi":                 {"GET", "/openapi.json", http.StatusOK},
 		"get correct points":      {"GET", "/receipts/7fb1377b-b223-49d9-a31a-5a02701dd310/points", http.StatusOK},
+		"get points breakdown":    {"GET", "/receipts/7fb1377b-b223-49d9-a31a-5a02701dd310/points/breakdown", http.StatusOK},
+		"get receipt":             {"GET", "/receipts/7fb1377b-b223-49d9-a31a-5a02701dd310", http.StatusOK},
 		"trailing slash":          {"GET", "/receipts/7fb1377b-b223-49d9-a31a-5a02701dd310/points/", http.StatusNotFound},
 		"missing ID":              {"GET", "/receipts//points", http.StatusMovedPermanently},
 		"process receipt success": {"POST", "/receipts/process", http.StatusAccepted},
+		"process batch":           {"POST", "/receipts/batch", http.StatusOK},
+		"score receipt":           {"POST", "/receipts/score", http.StatusOK},
+		"recalculate":             {"POST", "/admin/recalculate", http.StatusOK},
+		"flagged receipts":        {"GET", "/admin/receipts/flagged", http.StatusOK},
 		"unsupported method":      {"DELETE", "/receipts/process", http.StatusMethodNotAllowed},
 		"invalid path":            {"GET", "/invalid/route", http.StatusNotFound},
 	}
@@ -49,6 +93,283 @@ func TestRouter(t *testing.T) {
 	}
 }
 
+func TestRouter_Health(t *testing.T) {
+	checker := health.New()
+	checker.Register("repository", func(ctx context.Context) error {
+		return errors.New("disk full")
+	})
+	router := NewRouter(&stubHandler{}, WithHealth(checker))
+
+	request, err := http.NewRequest("GET", "/readyz", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, request)
+
+	assertStatus(t, response, http.StatusServiceUnavailable)
+}
+
+func TestRouter_Metrics(t *testing.T) {
+	router := NewRouter(&stubHandler{}, WithMetrics(metrics.New()))
+
+	for _, path := range []string{"/receipts/7fb1377b-b223-49d9-a31a-5a02701dd310/points", "/receipts/7fb1377b-b223-49d9-a31a-5a02701dd310/points", "/invalid/route"} {
+		request, err := http.NewRequest("GET", path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(httptest.NewRecorder(), request)
+	}
+
+	request, err := http.NewRequest("GET", "/metrics", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, request)
+
+	assertStatus(t, response, http.StatusOK)
+	for _, want := range []string{
+		`http_requests_total{route="GET /receipts/{id}/points",status="200"} 2`,
+		`http_requests_total{route="unmatched",status="404"} 1`,
+	} {
+		if got := response.Body.String(); !strings.Contains(got, want+"\n") {
+			t.Errorf("expected metrics to contain %s, but got\n%s", want, got)
+		}
+	}
+}
+
+func TestRouter_Auth(t *testing.T) {
+	keys, err := auth.New(auth.WithKeys(
+		auth.Key{Name: "writer", Hash: auth.HashKey("writer-secret"), Scopes: []string{auth.ScopeReceiptsWrite}},
+		auth.Key{Name: "reader", Hash: auth.HashKey("reader-secret"), Scopes: []string{auth.ScopeReceiptsRead}},
+		auth.Key{Name: "admin", Hash: auth.HashKey("admin-secret"), Scopes: []string{auth.ScopeAdmin}},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := NewRouter(&stubHandler{}, WithAuth(keys), WithMetrics(metrics.New()))
+
+	testCases := map[string]struct {
+		method string
+		path   string
+		key    string
+		want   int
+	}{
+		"liveness is open":         {"GET", "/healthz", "", http.StatusOK},
+		"readiness is open":        {"GET", "/readyz", "", http.StatusOK},
+		"metrics are open":         {"GET", "/metrics", "", http.StatusOK},
+		"read without key":         {"GET", "/receipts/7fb1377b-b223-49d9-a31a-5a02701dd310/points", "", http.StatusUnauthorized},
+		"read":                     {"GET", "/receipts/7fb1377b-b223-49d9-a31a-5a02701dd310/points", "reader-secret", http.StatusOK},
+		"read breakdown":           {"GET", "/receipts/7fb1377b-b223-49d9-a31a-5a02701dd310/points/breakdown", "reader-secret", http.StatusOK},
+		"read receipt":             {"GET", "/receipts/7fb1377b-b223-49d9-a31a-5a02701dd310", "reader-secret", http.StatusOK},
+		"read with write key":      {"GET", "/receipts/7fb1377b-b223-49d9-a31a-5a02701dd310", "writer-secret", http.StatusForbidden},
+		"process":                  {"POST", "/receipts/process", "writer-secret", http.StatusAccepted},
+		"process with read key":    {"POST", "/receipts/process", "reader-secret", http.StatusForbidden},
+		"batch":                    {"POST", "/receipts/batch", "writer-secret", http.StatusOK},
+		"score":                    {"POST", "/receipts/score", "reader-secret", http.StatusOK},
+		"score with write key":     {"POST", "/receipts/score", "writer-secret", http.StatusForbidden},
+		"recalculate":              {"POST", "/admin/recalculate", "admin-secret", http.StatusOK},
+		"recalculate with writer":  {"POST", "/admin/recalculate", "writer-secret", http.StatusForbidden},
+		"flagged":                  {"GET", "/admin/receipts/flagged", "admin-secret", http.StatusOK},
+		"flagged with reader":      {"GET", "/admin/receipts/flagged", "reader-secret", http.StatusForbidden},
+		"unknown route is unknown": {"GET", "/invalid/route", "", http.StatusNotFound},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			request, err := http.NewRequest(tc.method, tc.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.key != "" {
+				request.Header.Set("Authorization", "Bearer "+tc.key)
+			}
+
+			response := httptest.NewRecorder()
+			router.ServeHTTP(response, request)
+
+			assertStatus(t, response, tc.want)
+		})
+	}
+}
+
+func TestRouter_RateLimits(t *testing.T) {
+	router := NewRouter(&stubHandler{},
+		WithRateLimits(ratelimit.New(1, 2), ratelimit.New(1, 1)),
+		WithScoreRateLimit(ratelimit.New(1, 1)),
+		WithBatchRateLimit(ratelimit.New(1, 1)),
+	)
+
+	testCases := []struct {
+		method string
+		path   string
+		want   int
+	}{
+		{"POST", "/receipts/process", http.StatusAccepted},
+		{"POST", "/receipts/process", http.StatusTooManyRequests},
+		{"POST", "/receipts/batch", http.StatusOK},
+		{"POST", "/receipts/batch", http.StatusTooManyRequests},
+		{"POST", "/receipts/score", http.StatusOK},
+		{"POST", "/receipts/score", http.StatusTooManyRequests},
+		{"GET", "/receipts/7fb1377b-b223-49d9-a31a-5a02701dd310/points", http.StatusOK},
+		{"GET", "/receipts/7fb1377b-b223-49d9-a31a-5a02701dd310", http.StatusOK},
+		{"GET", "/admin/receipts/flagged", http.StatusTooManyRequests},
+		{"GET", "/healthz", http.StatusOK},
+	}
+	for _, tc := range testCases {
+		request, err := http.NewRequest(tc.method, tc.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		request.RemoteAddr = "192.0.2.7:41234"
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		assertStatus(t, response, tc.want)
+		if tc.want == http.StatusTooManyRequests && response.Header().Get("Retry-After") == "" {
+			t.Errorf("expected Retry-After for %s %s, but got nothing", tc.method, tc.path)
+		}
+	}
+
+	// Another client has its own limit.
+	request, err := http.NewRequest("POST", "/receipts/process", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.RemoteAddr = "192.0.2.8:41234"
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, request)
+	assertStatus(t, response, http.StatusAccepted)
+}
+
+func TestRouter_IPRateLimit(t *testing.T) {
+	keys, err := auth.New(auth.WithKeys(
+		auth.Key{Name: "reader", Hash: auth.HashKey("reader-secret"), Scopes: []string{auth.ScopeReceiptsRead}},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := NewRouter(&stubHandler{},
+		WithAuth(keys),
+		WithRateLimits(ratelimit.New(1, 10), nil),
+		WithIPRateLimit(ratelimit.New(1, 2)),
+	)
+
+	testCases := []struct {
+		key  string
+		want int
+	}{
+		{"wrong-secret", http.StatusUnauthorized},
+		{"guessed-secret", http.StatusUnauthorized},
+		{"another-guess", http.StatusTooManyRequests},
+		{"reader-secret", http.StatusTooManyRequests},
+	}
+	for _, tc := range testCases {
+		request, err := http.NewRequest("GET", "/receipts/7fb1377b-b223-49d9-a31a-5a02701dd310/points", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		request.RemoteAddr = "192.0.2.7:41234"
+		request.Header.Set("Authorization", "Bearer "+tc.key)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		assertStatus(t, response, tc.want)
+	}
+}
+
+func TestRouter_OpenAPI(t *testing.T) {
+	validator, err := openapi.New(openapi.WithReporter(func(r *http.Request, err error) {
+		t.Errorf("%s %s does not match the OpenAPI document: %v", r.Method, r.URL.Path, err)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := auth.New(auth.WithKeys(
+		auth.Key{Name: "client", Hash: auth.HashKey("client-secret"), Scopes: []string{auth.ScopeReceiptsRead, auth.ScopeReceiptsWrite}},
+		auth.Key{Name: "admin", Hash: auth.HashKey("admin-secret"), Scopes: []string{auth.ScopeAdmin}},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := receipt.NewService(receipt.NewRepository(), receipt.WithDuplicatePolicy(receipt.DuplicateReject))
+	router := NewRouter(receipt.NewHandler(service, receipt.WithMaxBatchSize(2)),
+		WithAuth(keys),
+		WithMetrics(metrics.New()),
+		// The client's last write is one past the burst.
+		WithRateLimits(nil, ratelimit.New(0.001, 6)),
+		WithOpenAPIValidation(validator),
+	)
+
+	serve := func(method, path, key, body string, header ...string) *httptest.ResponseRecorder {
+		t.Helper()
+		request := httptest.NewRequest(method, path, strings.NewReader(body))
+		if key != "" {
+			request.Header.Set("Authorization", "Bearer "+key)
+		}
+		for i := 0; i+1 < len(header); i += 2 {
+			request.Header.Set(header[i], header[i+1])
+		}
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		return response
+	}
+
+	const receiptBody = `{"retailer": "Target", "purchaseDate": "2022-01-01", "purchaseTime": "13:01", "items": [{"shortDescription": "Mountain Dew 12PK", "price": "6.49"}], "total": "6.49"}`
+	response := serve("POST", "/receipts/process", "client-secret", receiptBody, "Idempotency-Key", "first")
+	assertStatus(t, response, http.StatusAccepted)
+	var processed receipt.ProcessResponse
+	if err := json.NewDecoder(response.Body).Decode(&processed); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		method string
+		path   string
+		key    string
+		body   string
+		header []string
+		want   int
+	}{
+		{"POST", "/receipts/process", "client-secret", receiptBody, []string{"Idempotency-Key", "first"}, http.StatusAccepted},
+		{"POST", "/receipts/process", "client-secret", `{"retailer": "Target"}`, []string{"Idempotency-Key", "first"}, http.StatusBadRequest},
+		{"POST", "/receipts/process", "client-secret", strings.Replace(receiptBody, "13:01", "13:02", 1), []string{"Idempotency-Key", "first"}, http.StatusUnprocessableEntity},
+		{"POST", "/receipts/process", "client-secret", receiptBody, nil, http.StatusConflict},
+		{"POST", "/receipts/process", "client-secret", `{"retailer": `, nil, http.StatusBadRequest},
+		{"POST", "/receipts/process", "", receiptBody, nil, http.StatusUnauthorized},
+		{"POST", "/receipts/process", "admin-secret", receiptBody, nil, http.StatusForbidden},
+		{"POST", "/receipts/batch", "client-secret", `[` + receiptBody + `, {"retailer": "Target"}]`, nil, http.StatusOK},
+		{"POST", "/receipts/batch", "client-secret", receiptBody + "\n" + receiptBody, []string{"content-type", "application/x-ndjson"}, http.StatusOK},
+		{"POST", "/receipts/batch", "client-secret", `[{}, {}, {}]`, nil, http.StatusRequestEntityTooLarge},
+		{"POST", "/receipts/batch", "client-secret", `[]`, nil, http.StatusBadRequest},
+		{"POST", "/receipts/score", "client-secret", receiptBody, nil, http.StatusOK},
+		{"POST", "/receipts/score", "client-secret", `{"retailer": "Target"}`, nil, http.StatusBadRequest},
+		{"GET", "/receipts/" + processed.ID, "client-secret", "", nil, http.StatusOK},
+		{"GET", "/receipts/" + processed.ID + "/points", "client-secret", "", nil, http.StatusOK},
+		{"GET", "/receipts/" + processed.ID + "/points/breakdown", "client-secret", "", nil, http.StatusOK},
+		{"GET", "/receipts/unknown/points", "client-secret", "", nil, http.StatusNotFound},
+		{"GET", "/admin/receipts/flagged", "admin-secret", "", nil, http.StatusOK},
+		{"POST", "/admin/recalculate", "admin-secret", `{"version": "builtin"}`, nil, http.StatusOK},
+		{"POST", "/admin/recalculate", "admin-secret", `{"version": "v9"}`, nil, http.StatusNotFound},
+		{"POST", "/admin/recalculate", "admin-secret", `{"version": " "}`, nil, http.StatusBadRequest},
+		{"GET", "/healthz", "", "", nil, http.StatusOK},
+		{"GET", "/readyz", "", "", nil, http.StatusOK},
+		{"GET", "/metrics", "", "", nil, http.StatusOK},
+		{"GET", "/openapi.json", "", "", nil, http.StatusOK},
+		{"POST", "/receipts/process", "client-secret", receiptBody, nil, http.StatusTooManyRequests},
+	}
+	for _, tc := range testCases {
+		response := serve(tc.method, tc.path, tc.key, tc.body, tc.header...)
+		if got := response.Code; got != tc.want {
+			t.Errorf("expected status %d for %s %s, but got %d", tc.want, tc.method, tc.path, got)
+		}
+	}
+}
+
 func assertStatus(t *testing.T, response *httptest.ResponseRecorder, want int) {
 	t.Helper()
 	if got := response.Code; got != want {
@@ -1,11 +1,47 @@
 package server
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
 	"net/http"
 	"time"
 )
 
-func NewServer(handler http.Handler) *http.Server {
+type Option func(*http.Server)
+
+func WithAddr(addr string) Option {
+	return func(s *http.Server) {
+		s.Addr = addr
+	}
+}
+
+func WithReadTimeout(timeout time.Duration) Option {
+	return func(s *http.Server) {
+		s.ReadTimeout = timeout
+	}
+}
+
+func WithReadHeaderTimeout(timeout time.Duration) Option {
+	return func(s *http.Server) {
+		s.ReadHeaderTimeout = timeout
+	}
+}
+
+func WithWriteTimeout(timeout time.Duration) Option {
+	return func(s *http.Server) {
+		s.WriteTimeout = timeout
+	}
+}
+
+func WithIdleTimeout(timeout time.Duration) Option {
+	return func(s *http.Server) {
+		s.IdleTimeout = timeout
+	}
+}
+
+func NewServer(handler http.Handler, options ...Option) *http.Server {
 	server := &http.Server{
 		Addr:              ":8080",
 		Handler:           handler,
@@ -14,5 +50,35 @@ func NewServer(handler http.Handler) *http.Server {
 		WriteTimeout:      10 * time.Second,
 		IdleTimeout:       60 * time.Second,
 	}
+	for _, option := range options {
+		option(server)
+	}
 	return server
 }
+
+// Serve accepts connections on the listener until ctx is done. It then stops
+// accepting new connections and waits up to shutdownTimeout for in-flight
+// requests to finish, closing any that are still active after that.
+func Serve(ctx context.Context, server *http.Server, listener net.Listener, shutdownTimeout time.Duration) error {
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(listener)
+	}()
+
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		server.Close()
+		return fmt.Errorf("failed to drain requests within %v, %w", shutdownTimeout, err)
+	}
+	if err := <-served; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -1,6 +1,8 @@
 package server
 
 import (
+	"context"
+	"net"
 	"net/http"
 	"testing"
 	"time"
@@ -21,9 +23,114 @@ func TestServer(t *testing.T) {
 	assertEqual(t, "idle timeout", server.IdleTimeout, 60*time.Second)
 }
 
+func TestServe(t *testing.T) {
+	t.Run("drains in-flight requests", func(t *testing.T) {
+		release := make(chan struct{})
+		server := startServer(t, release, time.Second)
+
+		responses := make(chan int, 1)
+		go func() {
+			response, err := http.Get("http://" + server.address)
+			if err != nil {
+				responses <- 0
+				return
+			}
+			response.Body.Close()
+			responses <- response.StatusCode
+		}()
+
+		server.waitForRequest(t)
+		server.cancel()
+		close(release)
+
+		if got := <-responses; got != http.StatusOK {
+			t.Errorf("expected status %d, but got %d", http.StatusOK, got)
+		}
+		if err := <-server.served; err != nil {
+			t.Errorf("expected no error, but got %v", err)
+		}
+		if _, err := net.Dial("tcp", server.address); err == nil {
+			t.Error("expected new connections to be refused, but got one")
+		}
+	})
+
+	t.Run("deadline exceeded", func(t *testing.T) {
+		release := make(chan struct{})
+		defer close(release)
+		server := startServer(t, release, 50*time.Millisecond)
+
+		go http.Get("http://" + server.address)
+		server.waitForRequest(t)
+		server.cancel()
+
+		if err := <-server.served; err == nil {
+			t.Error("expected has error, but got nothing")
+		}
+	})
+}
+
+// testServer serves a handler that blocks until release is closed.
+type testServer struct {
+	address string
+	started chan struct{}
+	cancel  context.CancelFunc
+	served  chan error
+}
+
+func startServer(t *testing.T, release chan struct{}, shutdownTimeout time.Duration) *testServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	s := &testServer{
+		address: listener.Addr().String(),
+		started: make(chan struct{}, 1),
+		cancel:  cancel,
+		served:  make(chan error, 1),
+	}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.started <- struct{}{}
+		<-release
+		w.WriteHeader(http.StatusOK)
+	})
+	go func() {
+		s.served <- Serve(ctx, NewServer(handler), listener, shutdownTimeout)
+	}()
+	return s
+}
+
+func (s *testServer) waitForRequest(t *testing.T) {
+	t.Helper()
+	select {
+	case <-s.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected request to start, but got nothing")
+	}
+}
+
 func assertEqual[T comparable](t *testing.T, name string, got, want T) {
 	t.Helper()
 	if got != want {
 		t.Errorf("expected %s %v, but got %v", name, want, got)
 	}
 }
+
+func TestServer_Options(t *testing.T) {
+	server := NewServer(http.NewServeMux(),
+		WithAddr("127.0.0.1:9090"),
+		WithReadTimeout(5*time.Second),
+		WithReadHeaderTimeout(time.Second),
+		WithWriteTimeout(20*time.Second),
+		WithIdleTimeout(2*time.Minute),
+	)
+
+	assertEqual(t, "server address", server.Addr, "127.0.0.1:9090")
+	assertEqual(t, "read timeout", server.ReadTimeout, 5*time.Second)
+	assertEqual(t, "read header timeout", server.ReadHeaderTimeout, time.Second)
+	assertEqual(t, "write timeout", server.WriteTimeout, 20*time.Second)
+	assertEqual(t, "idle timeout", server.IdleTimeout, 2*time.Minute)
+}
@@ -0,0 +1,360 @@
+// Package client calls the receipt API from Go.
+//
+//	c, err := client.New("https://receipts.example.com", client.WithAPIKey(key))
+//	id, err := c.ProcessReceipt(ctx, &client.ProcessRequest{...})
+//	points, err := c.GetPoints(ctx, id)
+//
+// Requests are retried when it is safe to: reads always, receipts because
+// every submission carries an Idempotency-Key, and anything the server turned
+// away with 429 Too Many Requests.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/lzchong/receipt-processor/internal/api/problem"
+	"github.com/lzchong/receipt-processor/internal/api/receipt"
+)
+
+// The types of the API, shared with the server.
+type (
+	ProcessRequest     = receipt.ProcessRequest
+	ItemRequest        = receipt.ItemRequest
+	ProcessResponse    = receipt.ProcessResponse
+	PointsResponse     = receipt.PointsResponse
+	PointsBreakdown    = receipt.PointsBreakdown
+	RulePoints         = receipt.RulePoints
+	ItemPoints         = receipt.ItemPoints
+	BatchResponse      = receipt.BatchResponse
+	BatchResult        = receipt.BatchResult
+	RecalculateRequest = receipt.RecalculateRequest
+	Recalculation      = receipt.Recalculation
+	PointsChange       = receipt.PointsChange
+	FlaggedReceipt     = receipt.FlaggedReceipt
+	Problem            = problem.Problem
+	Violation          = problem.Violation
+)
+
+const (
+	defaultRetries    = 3
+	defaultMinBackoff = 100 * time.Millisecond
+	defaultMaxBackoff = 2 * time.Second
+	maxErrorBodySize  = 1 << 20
+)
+
+type Client struct {
+	baseURL    *url.URL
+	httpClient *http.Client
+	apiKey     string
+	retries    int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+}
+
+type Option func(*Client)
+
+// WithHTTPClient sends requests with the client instead of
+// http.DefaultClient.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithAPIKey authenticates every request with the key.
+func WithAPIKey(key string) Option {
+	return func(c *Client) {
+		c.apiKey = key
+	}
+}
+
+// WithRetries sets how many times a failed request is retried. The default is
+// 3; 0 turns retrying off.
+func WithRetries(retries int) Option {
+	return func(c *Client) {
+		c.retries = retries
+	}
+}
+
+// WithBackoff sets the range of the exponential backoff between retries. The
+// server's Retry-After is used instead when it sends one.
+func WithBackoff(min, max time.Duration) Option {
+	return func(c *Client) {
+		c.minBackoff = min
+		c.maxBackoff = max
+	}
+}
+
+// New returns a client of the API at the base URL, such as
+// https://receipts.example.com.
+func New(baseURL string, options ...Option) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base URL: %w", err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid base URL %q, must be absolute", baseURL)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+
+	c := &Client{
+		baseURL:    u,
+		httpClient: http.DefaultClient,
+		retries:    defaultRetries,
+		minBackoff: defaultMinBackoff,
+		maxBackoff: defaultMaxBackoff,
+	}
+	for _, option := range options {
+		option(c)
+	}
+	return c, nil
+}
+
+type idempotencyKey struct{}
+
+// WithIdempotencyKey makes ProcessReceipt submit the receipt with the key
+// rather than a new one, so that a submission can be retried safely even
+// after the process that first made it has gone.
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKey{}, key)
+}
+
+// ProcessReceipt submits a receipt and returns its ID. A receipt that was
+// already submitted fails with ErrConflict. If an earlier attempt with the same
+// idempotency key is still being processed, such as one that timed out on the
+// network, the call waits and retries until it gets that attempt's result. It
+// fails with ErrInProgress if the attempt is still running when the retries
+// run out, in which case the receipt may yet be stored.
+func (c *Client) ProcessReceipt(ctx context.Context, request *ProcessRequest) (string, error) {
+	key, ok := ctx.Value(idempotencyKey{}).(string)
+	if !ok {
+		key = uuid.New().String()
+	}
+	var response ProcessResponse
+	err := c.do(ctx, call{
+		method:  http.MethodPost,
+		path:    "/receipts/process",
+		body:    request,
+		header:  http.Header{"Idempotency-Key": {key}},
+		retry:   true,
+		success: &response,
+	})
+	return response.ID, err
+}
+
+// ProcessBatch submits several receipts at once. Each receipt succeeds or
+// fails on its own, as reported in the response. The batch is only retried if
+// the server did not start processing it.
+func (c *Client) ProcessBatch(ctx context.Context, requests []ProcessRequest) (*BatchResponse, error) {
+	var response BatchResponse
+	err := c.do(ctx, call{method: http.MethodPost, path: "/receipts/batch", body: requests, success: &response})
+	if err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// ScoreReceipt explains the points the receipt would be awarded, without
+// storing it. Nothing is stored, so it is always safe to retry.
+func (c *Client) ScoreReceipt(ctx context.Context, request *ProcessRequest) (*PointsBreakdown, error) {
+	var response PointsBreakdown
+	err := c.do(ctx, call{method: http.MethodPost, path: "/receipts/score", body: request, retry: true, success: &response})
+	if err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// GetReceipt returns a stored receipt in the form it was submitted in.
+func (c *Client) GetReceipt(ctx context.Context, id string) (*ProcessRequest, error) {
+	var response ProcessRequest
+	err := c.do(ctx, call{method: http.MethodGet, path: "/receipts/" + url.PathEscape(id), retry: true, success: &response})
+	if err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// GetPoints returns the points awarded to a receipt.
+func (c *Client) GetPoints(ctx context.Context, id string) (int64, error) {
+	var response PointsResponse
+	err := c.do(ctx, call{method: http.MethodGet, path: "/receipts/" + url.PathEscape(id) + "/points", retry: true, success: &response})
+	return response.Points, err
+}
+
+// GetPointsBreakdown explains the points awarded to a receipt rule by rule.
+func (c *Client) GetPointsBreakdown(ctx context.Context, id string) (*PointsBreakdown, error) {
+	var response PointsBreakdown
+	err := c.do(ctx, call{method: http.MethodGet, path: "/receipts/" + url.PathEscape(id) + "/points/breakdown", retry: true, success: &response})
+	if err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// Recalculate previews the points of every receipt under another rule
+// version, or moves every receipt to it if commit is set.
+func (c *Client) Recalculate(ctx context.Context, version string, commit bool) (*Recalculation, error) {
+	var response Recalculation
+	err := c.do(ctx, call{
+		method:  http.MethodPost,
+		path:    "/admin/recalculate",
+		body:    RecalculateRequest{Version: version, Commit: commit},
+		success: &response,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// FlaggedReceipts lists the stored receipts that duplicate an earlier one.
+func (c *Client) FlaggedReceipts(ctx context.Context) ([]FlaggedReceipt, error) {
+	var response []FlaggedReceipt
+	err := c.do(ctx, call{method: http.MethodGet, path: "/admin/receipts/flagged", retry: true, success: &response})
+	return response, err
+}
+
+// call is one API request.
+type call struct {
+	method string
+	path   string
+	body   any
+	header http.Header
+	// retry is set if the request can be sent again whatever happened to the
+	// first attempt.
+	retry   bool
+	success any
+}
+
+func (c *Client) do(ctx context.Context, call call) error {
+	var body []byte
+	if call.body != nil {
+		var err error
+		if body, err = json.Marshal(call.body); err != nil {
+			return err
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		err := c.attempt(ctx, call, body)
+		if err == nil || attempt >= c.retries || !c.retryable(call, err) {
+			return err
+		}
+		if err := sleep(ctx, c.backoff(attempt, err)); err != nil {
+			return err
+		}
+	}
+}
+
+func (c *Client) attempt(ctx context.Context, call call, body []byte) error {
+	u := *c.baseURL
+	u.Path += call.path
+	request, err := http.NewRequestWithContext(ctx, call.method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for name, values := range call.header {
+		request.Header[name] = values
+	}
+	request.Header.Set("accept", "application/json")
+	if body != nil {
+		request.Header.Set("content-type", "application/json")
+	}
+	if c.apiKey != "" {
+		request.Header.Set("authorization", "Bearer "+c.apiKey)
+	}
+
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= http.StatusBadRequest {
+		return responseError(response)
+	}
+	if err := json.NewDecoder(response.Body).Decode(call.success); err != nil {
+		return fmt.Errorf("receipt API: invalid response: %w", err)
+	}
+	return nil
+}
+
+func responseError(response *http.Response) error {
+	apiErr := &Error{StatusCode: response.StatusCode}
+	if seconds, err := strconv.Atoi(response.Header.Get("retry-after")); err == nil {
+		apiErr.RetryAfter = time.Duration(seconds) * time.Second
+	}
+	if strings.HasPrefix(response.Header.Get("content-type"), problem.ContentType) {
+		var p Problem
+		if err := json.NewDecoder(io.LimitReader(response.Body, maxErrorBodySize)).Decode(&p); err == nil {
+			apiErr.Problem = &p
+		}
+	}
+	return apiErr
+}
+
+// retryable reports whether the call may be sent again after failing with
+// the error. A request turned away with 429 was never processed, so it can
+// always be retried. A request with an Idempotency-Key that is still in
+// progress gets the result of the earlier attempt when retried.
+func (c *Client) retryable(call call, err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var apiErr *Error
+	if !errors.As(err, &apiErr) {
+		// The request may or may not have reached the server.
+		return call.retry
+	}
+	switch {
+	case apiErr.StatusCode == http.StatusTooManyRequests:
+		return true
+	case apiErr.inProgress():
+		return call.header.Get("Idempotency-Key") != ""
+	case apiErr.StatusCode == http.StatusBadGateway,
+		apiErr.StatusCode == http.StatusServiceUnavailable,
+		apiErr.StatusCode == http.StatusGatewayTimeout:
+		return call.retry
+	default:
+		return false
+	}
+}
+
+// backoff returns how long to wait before retrying: what the server asked
+// for, or an exponential backoff with jitter.
+func (c *Client) backoff(attempt int, err error) time.Duration {
+	var apiErr *Error
+	if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+		return apiErr.RetryAfter
+	}
+	backoff := c.minBackoff << attempt
+	if backoff <= 0 || backoff > c.maxBackoff {
+		backoff = c.maxBackoff
+	}
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
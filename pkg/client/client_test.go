@@ -0,0 +1,485 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lzchong/receipt-processor/internal/api/receipt"
+	"github.com/lzchong/receipt-processor/internal/auth"
+	"github.com/lzchong/receipt-processor/internal/ratelimit"
+	"github.com/lzchong/receipt-processor/internal/server"
+)
+
+const testKey = "secret"
+
+func newReceipt() *ProcessRequest {
+	return &ProcessRequest{
+		Retailer:     "Target",
+		PurchaseDate: "2022-01-01",
+		PurchaseTime: "13:01",
+		Items: []ItemRequest{
+			{ShortDescription: "Mountain Dew 12PK", Price: "6.49"},
+		},
+		Total: "6.49",
+	}
+}
+
+// newServer serves the API with an in-memory repository, authenticating with
+// testKey. The wrap function, if any, sits in front of the router.
+func newServer(t *testing.T, wrap func(http.Handler) http.Handler, options ...server.RouterOption) (*httptest.Server, receipt.Repository) {
+	t.Helper()
+	repository := receipt.NewRepository()
+	service := receipt.NewService(repository, receipt.WithDuplicatePolicy(receipt.DuplicateReject))
+	keys, err := auth.New(auth.WithKeys(auth.Key{
+		Name:   "test",
+		Hash:   auth.HashKey(testKey),
+		Scopes: []string{auth.ScopeReceiptsRead, auth.ScopeReceiptsWrite, auth.ScopeAdmin},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var handler http.Handler = server.NewRouter(receipt.NewHandler(service), append([]server.RouterOption{server.WithAuth(keys)}, options...)...)
+	if wrap != nil {
+		handler = wrap(handler)
+	}
+	s := httptest.NewServer(handler)
+	t.Cleanup(s.Close)
+	return s, repository
+}
+
+func newClient(t *testing.T, s *httptest.Server, options ...Option) *Client {
+	t.Helper()
+	options = append([]Option{WithAPIKey(testKey), WithBackoff(time.Millisecond, time.Millisecond)}, options...)
+	c, err := New(s.URL, options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestNew(t *testing.T) {
+	testCases := map[string]struct {
+		baseURL string
+		wantErr bool
+	}{
+		"absolute":       {"https://receipts.example.com", false},
+		"with path":      {"https://example.com/api/", false},
+		"relative":       {"/receipts", true},
+		"missing scheme": {"receipts.example.com", true},
+		"malformed":      {"http://[::1", true},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			_, err := New(tc.baseURL)
+			if tc.wantErr && err == nil {
+				t.Error("expected has error, but got nothing")
+			}
+			if !tc.wantErr && err != nil {
+				t.Errorf("expected no error, but got %v", err)
+			}
+		})
+	}
+}
+
+func TestClient(t *testing.T) {
+	s, _ := newServer(t, nil)
+	c := newClient(t, s)
+	ctx := context.Background()
+
+	id, err := c.ProcessReceipt(ctx, newReceipt())
+	if err != nil {
+		t.Fatalf("expected the receipt to be processed, but got %v", err)
+	}
+
+	points, err := c.GetPoints(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if points != 12 {
+		t.Errorf("expected 12 points, but got %d", points)
+	}
+
+	breakdown, err := c.GetPointsBreakdown(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if breakdown.Points != points {
+		t.Errorf("expected a breakdown totalling %d, but got %d", points, breakdown.Points)
+	}
+
+	// Scoring the receipt again is not a duplicate, as it is not stored.
+	score, err := c.ScoreReceipt(ctx, newReceipt())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if score.Points != points {
+		t.Errorf("expected a score of %d, but got %d", points, score.Points)
+	}
+
+	stored, err := c.GetReceipt(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Retailer != "Target" {
+		t.Errorf("expected retailer Target, but got %s", stored.Retailer)
+	}
+
+	batch, err := c.ProcessBatch(ctx, []ProcessRequest{*newReceipt(), {Retailer: "Invalid"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if batch.Succeeded != 0 || batch.Failed != 2 {
+		t.Errorf("expected the duplicate and the invalid receipt to fail, but got %+v", batch)
+	}
+
+	recalculation, err := c.Recalculate(ctx, receipt.DefaultRuleVersion, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recalculation.Receipts != 1 || recalculation.Changed != 0 {
+		t.Errorf("expected 1 unchanged receipt, but got %+v", recalculation)
+	}
+
+	flagged, err := c.FlaggedReceipts(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(flagged) != 0 {
+		t.Errorf("expected no flagged receipts, but got %v", flagged)
+	}
+}
+
+func TestClient_Errors(t *testing.T) {
+	s, _ := newServer(t, nil)
+	c := newClient(t, s)
+	ctx := context.Background()
+	if _, err := c.ProcessReceipt(ctx, newReceipt()); err != nil {
+		t.Fatal(err)
+	}
+
+	unauthenticated, err := New(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	invalid := newReceipt()
+	invalid.Total = "6.5"
+
+	testCases := map[string]struct {
+		call       func() error
+		want       error
+		wantStatus int
+	}{
+		"not found": {
+			call:       func() error { _, err := c.GetPoints(ctx, "00000000-0000-0000-0000-000000000000"); return err },
+			want:       ErrNotFound,
+			wantStatus: http.StatusNotFound,
+		},
+		"invalid": {
+			call:       func() error { _, err := c.ProcessReceipt(ctx, invalid); return err },
+			want:       ErrInvalid,
+			wantStatus: http.StatusBadRequest,
+		},
+		"duplicate": {
+			call:       func() error { _, err := c.ProcessReceipt(ctx, newReceipt()); return err },
+			want:       ErrConflict,
+			wantStatus: http.StatusConflict,
+		},
+		"unauthorized": {
+			call:       func() error { _, err := unauthenticated.FlaggedReceipts(ctx); return err },
+			want:       ErrUnauthorized,
+			wantStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			err := tc.call()
+			if !errors.Is(err, tc.want) {
+				t.Fatalf("expected %v, but got %v", tc.want, err)
+			}
+			var apiErr *Error
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("expected an *Error, but got %T", err)
+			}
+			if apiErr.StatusCode != tc.wantStatus {
+				t.Errorf("expected status %d, but got %d", tc.wantStatus, apiErr.StatusCode)
+			}
+			if apiErr.Problem == nil {
+				t.Error("expected the problem to be decoded, but got nothing")
+			}
+		})
+	}
+
+	_, err = c.ProcessReceipt(ctx, invalid)
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || len(apiErr.Violations()) == 0 {
+		t.Errorf("expected the violations of the receipt, but got %v", err)
+	}
+}
+
+// failFirst fails the first n requests with the status, after passing them
+// to the router if process is set, as if the response had been lost.
+type failFirst struct {
+	lock     sync.Mutex
+	n        int
+	status   int
+	process  bool
+	requests int
+}
+
+func (f *failFirst) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.lock.Lock()
+		f.requests++
+		fail := f.requests <= f.n
+		f.lock.Unlock()
+
+		if !fail {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if f.process {
+			next.ServeHTTP(httptest.NewRecorder(), r)
+		} else {
+			io.Copy(io.Discard, r.Body)
+		}
+		w.WriteHeader(f.status)
+	})
+}
+
+func TestClient_Retry(t *testing.T) {
+	testCases := map[string]struct {
+		status       int
+		failures     int
+		retries      int
+		call         func(*Client) error
+		wantRequests int
+		wantErr      error
+	}{
+		"processing after a lost response": {
+			status:       http.StatusBadGateway,
+			failures:     2,
+			retries:      3,
+			call:         func(c *Client) error { _, err := c.ProcessReceipt(context.Background(), newReceipt()); return err },
+			wantRequests: 3,
+		},
+		"reading": {
+			status:       http.StatusServiceUnavailable,
+			failures:     1,
+			retries:      3,
+			call:         func(c *Client) error { _, err := c.FlaggedReceipts(context.Background()); return err },
+			wantRequests: 2,
+		},
+		"giving up": {
+			status:       http.StatusGatewayTimeout,
+			failures:     5,
+			retries:      2,
+			call:         func(c *Client) error { _, err := c.FlaggedReceipts(context.Background()); return err },
+			wantRequests: 3,
+			wantErr:      ErrServer,
+		},
+		"still unavailable": {
+			status:       http.StatusServiceUnavailable,
+			failures:     5,
+			retries:      1,
+			call:         func(c *Client) error { _, err := c.GetPoints(context.Background(), "id"); return err },
+			wantRequests: 2,
+			wantErr:      ErrUnavailable,
+		},
+		"batch is not retried": {
+			status:   http.StatusBadGateway,
+			failures: 1,
+			retries:  3,
+			call: func(c *Client) error {
+				_, err := c.ProcessBatch(context.Background(), []ProcessRequest{*newReceipt()})
+				return err
+			},
+			wantRequests: 1,
+			wantErr:      ErrServer,
+		},
+		"server error is not retried": {
+			status:       http.StatusInternalServerError,
+			failures:     1,
+			retries:      3,
+			call:         func(c *Client) error { _, err := c.FlaggedReceipts(context.Background()); return err },
+			wantRequests: 1,
+			wantErr:      ErrServer,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			f := &failFirst{n: tc.failures, status: tc.status, process: true}
+			s, repository := newServer(t, f.wrap)
+			c := newClient(t, s, WithRetries(tc.retries))
+
+			err := tc.call(c)
+			if tc.wantErr == nil && err != nil {
+				t.Fatalf("expected no error, but got %v", err)
+			}
+			if tc.wantErr != nil && !errors.Is(err, tc.wantErr) {
+				t.Fatalf("expected %v, but got %v", tc.wantErr, err)
+			}
+			if f.requests != tc.wantRequests {
+				t.Errorf("expected %d requests, but got %d", tc.wantRequests, f.requests)
+			}
+			if got, _ := repository.Count(context.Background()); got > 1 {
+				t.Errorf("expected the receipt to be stored once, but got %d", got)
+			}
+		})
+	}
+}
+
+func TestClient_RetryRateLimited(t *testing.T) {
+	// Only one batch is allowed, so the second is turned away and retried
+	// after the second it is told to wait.
+	s, _ := newServer(t, nil, server.WithBatchRateLimit(ratelimit.New(1, 1)))
+	c := newClient(t, s)
+	ctx := context.Background()
+
+	if _, err := c.ProcessBatch(ctx, []ProcessRequest{*newReceipt()}); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if _, err := c.ProcessBatch(ctx, []ProcessRequest{{Retailer: "Invalid"}}); err != nil {
+		t.Fatalf("expected the batch to be retried, but got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 500*time.Millisecond {
+		t.Errorf("expected the client to wait as asked, but it retried after %v", elapsed)
+	}
+
+	c = newClient(t, s, WithRetries(0))
+	_, err := c.ProcessBatch(ctx, []ProcessRequest{{Retailer: "Invalid"}})
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("expected %v, but got %v", ErrRateLimited, err)
+	}
+	if apiErr.RetryAfter <= 0 {
+		t.Errorf("expected Retry-After, but got %v", apiErr.RetryAfter)
+	}
+}
+
+func TestClient_IdempotencyKey(t *testing.T) {
+	s, repository := newServer(t, nil)
+	c := newClient(t, s)
+	ctx := WithIdempotencyKey(context.Background(), "submission-1")
+
+	first, err := c.ProcessReceipt(ctx, newReceipt())
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := c.ProcessReceipt(ctx, newReceipt())
+	if err != nil {
+		t.Fatalf("expected the submission to be replayed, but got %v", err)
+	}
+	if first != second {
+		t.Errorf("expected ID %s, but got %s", first, second)
+	}
+	if got, _ := repository.Count(context.Background()); got != 1 {
+		t.Errorf("expected 1 receipt, but got %d", got)
+	}
+
+	other := newReceipt()
+	other.Retailer = "Walgreens"
+	if _, err := c.ProcessReceipt(ctx, other); !errors.Is(err, ErrIdempotencyMismatch) {
+		t.Errorf("expected %v, but got %v", ErrIdempotencyMismatch, err)
+	}
+}
+
+func TestClient_IdempotencyKeyInProgress(t *testing.T) {
+	testCases := map[string]struct {
+		inProgress   int
+		retries      int
+		key          string
+		wantRequests int
+		wantErr      error
+	}{
+		"retried until processed": {
+			inProgress:   2,
+			retries:      3,
+			key:          "submission-1",
+			wantRequests: 3,
+		},
+		"still in progress": {
+			inProgress:   5,
+			retries:      2,
+			key:          "submission-1",
+			wantRequests: 3,
+			wantErr:      ErrInProgress,
+		},
+		"generated key": {
+			inProgress:   1,
+			retries:      3,
+			wantRequests: 2,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			var lock sync.Mutex
+			requests := 0
+			s, _ := newServer(t, func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					lock.Lock()
+					requests++
+					inProgress := requests <= tc.inProgress
+					lock.Unlock()
+
+					if !inProgress {
+						next.ServeHTTP(w, r)
+						return
+					}
+					io.Copy(io.Discard, r.Body)
+					w.Header().Set("Content-Type", "application/problem+json")
+					w.WriteHeader(http.StatusConflict)
+					io.WriteString(w, `{"type":"urn:receipt-processor:problem:in-progress","title":"Conflict","status":409}`)
+				})
+			})
+			c := newClient(t, s, WithRetries(tc.retries))
+			ctx := context.Background()
+			if tc.key != "" {
+				ctx = WithIdempotencyKey(ctx, tc.key)
+			}
+
+			_, err := c.ProcessReceipt(ctx, newReceipt())
+			if tc.wantErr == nil && err != nil {
+				t.Fatalf("expected no error, but got %v", err)
+			}
+			if tc.wantErr != nil && !errors.Is(err, tc.wantErr) {
+				t.Fatalf("expected %v, but got %v", tc.wantErr, err)
+			}
+			if errors.Is(err, ErrConflict) {
+				t.Errorf("expected the error not to match %v, but got %v", ErrConflict, err)
+			}
+			if requests != tc.wantRequests {
+				t.Errorf("expected %d requests, but got %d", tc.wantRequests, requests)
+			}
+		})
+	}
+}
+
+func TestClient_Canceled(t *testing.T) {
+	f := &failFirst{n: 100, status: http.StatusServiceUnavailable}
+	s, _ := newServer(t, f.wrap)
+	c, err := New(s.URL, WithAPIKey(testKey), WithBackoff(time.Hour, time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.GetPoints(ctx, "id"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected %v, but got %v", context.DeadlineExceeded, err)
+	}
+}
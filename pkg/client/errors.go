@@ -0,0 +1,82 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/lzchong/receipt-processor/internal/api/problem"
+)
+
+// Errors matched by an *Error with errors.Is, by the status of the response.
+// A conflict with a request still being processed matches ErrInProgress rather
+// than ErrConflict, as retrying it may succeed.
+var (
+	ErrInvalid             = errors.New("request is invalid")
+	ErrUnauthorized        = errors.New("API key is missing or unknown")
+	ErrForbidden           = errors.New("API key does not have the required scope")
+	ErrNotFound            = errors.New("not found")
+	ErrConflict            = errors.New("request conflicts with an earlier one")
+	ErrInProgress          = errors.New("an identical request is still being processed")
+	ErrTooLarge            = errors.New("request is too large")
+	ErrIdempotencyMismatch = errors.New("idempotency key was used with a different request")
+	ErrRateLimited         = errors.New("too many requests")
+	ErrUnavailable         = errors.New("service is temporarily unavailable")
+	ErrServer              = errors.New("server failed to handle the request")
+)
+
+var statusErrors = map[int]error{
+	http.StatusBadRequest:            ErrInvalid,
+	http.StatusUnauthorized:          ErrUnauthorized,
+	http.StatusForbidden:             ErrForbidden,
+	http.StatusNotFound:              ErrNotFound,
+	http.StatusConflict:              ErrConflict,
+	http.StatusRequestEntityTooLarge: ErrTooLarge,
+	http.StatusUnprocessableEntity:   ErrIdempotencyMismatch,
+	http.StatusTooManyRequests:       ErrRateLimited,
+	http.StatusServiceUnavailable:    ErrUnavailable,
+}
+
+// Error is an error response from the API.
+type Error struct {
+	StatusCode int
+	// Problem is the body of the response, if it was a problem document.
+	Problem *Problem
+	// RetryAfter is how long the server asked the client to wait, if it did.
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	if e.Problem != nil && e.Problem.Detail != "" {
+		return fmt.Sprintf("receipt API: %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Problem.Detail)
+	}
+	return fmt.Sprintf("receipt API: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// Is matches the error for the status of the response, such as ErrNotFound.
+// Every server error also matches ErrServer.
+func (e *Error) Is(target error) bool {
+	if e.inProgress() {
+		return target == ErrInProgress
+	}
+	if err, ok := statusErrors[e.StatusCode]; ok && err == target {
+		return true
+	}
+	return e.StatusCode >= http.StatusInternalServerError && target == ErrServer
+}
+
+// inProgress reports whether the request conflicts with an identical one the
+// server is still processing.
+func (e *Error) inProgress() bool {
+	return e.StatusCode == http.StatusConflict && e.Problem != nil && e.Problem.Type == problem.TypeInProgress
+}
+
+// Violations lists the invalid parts of the request, if the server reported
+// any.
+func (e *Error) Violations() []Violation {
+	if e.Problem == nil {
+		return nil
+	}
+	return e.Problem.Errors
+}